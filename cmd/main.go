@@ -1,9 +1,14 @@
 package main
 
 import (
+	"flag"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+
+	// The timezones of the policy schedules are available even without a system timezone database
+	_ "time/tzdata"
 
 	"github.com/ardikabs/socks5"
 	"github.com/ardikabs/socks5/pkg/types"
@@ -11,12 +16,20 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "policy" {
+		os.Exit(runPolicy(filepath.Base(os.Args[0]), os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	policyFile := flag.String("policy", "", "policy file declaring the users, access rules, rate limits and egresses")
+	flag.Parse()
+
 	log := logr.FromSlogHandler(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 		Level: slog.LevelDebug,
 	}))
 
 	srv, err := socks5.New(socks5.ServerConfig{
 		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired, types.AuthUserPass},
+		PolicyFile:         *policyFile,
 		Logger:             log,
 	})
 	if err != nil {
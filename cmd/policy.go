@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/policy"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+const policyUsage = `usage:
+  %[1]s policy validate -policy FILE
+  %[1]s policy explain -policy FILE [-user NAME] [-groups A,B] [-client IP] [-command NAME] [-resolved-ip IP] [-time RFC3339] HOST:PORT
+  %[1]s policy test -policy FILE EXPECTATIONS
+`
+
+// runPolicy runs the policy subcommands, returning the exit code: 1 when the policy fails validation,
+// denies the request or doesn't meet its expectations, 2 on usage errors.
+func runPolicy(name string, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintf(stderr, policyUsage, name)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(name+" policy "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	policyFile := fs.String("policy", "", "policy file to load")
+
+	switch args[0] {
+	case "validate":
+		if err := fs.Parse(args[1:]); err != nil || *policyFile == "" || fs.NArg() != 0 {
+			fmt.Fprintf(stderr, policyUsage, name)
+			return 2
+		}
+
+		if _, err := policy.Load(*policyFile); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+
+		fmt.Fprintf(stdout, "%s is valid\n", *policyFile)
+		return 0
+	case "explain":
+		return explainPolicy(name, fs, policyFile, args[1:], stdout, stderr)
+	case "test":
+		if err := fs.Parse(args[1:]); err != nil || *policyFile == "" || fs.NArg() != 1 {
+			fmt.Fprintf(stderr, policyUsage, name)
+			return 2
+		}
+
+		return testPolicy(*policyFile, fs.Arg(0), stdout, stderr)
+	default:
+		fmt.Fprintf(stderr, policyUsage, name)
+		return 2
+	}
+}
+
+func explainPolicy(name string, fs *flag.FlagSet, policyFile *string, args []string, stdout, stderr io.Writer) int {
+	var (
+		user       = fs.String("user", "", "authenticated user, the client is anonymous when empty")
+		groups     = fs.String("groups", "", "comma-separated groups of the user, on top of the ones of the policy")
+		client     = fs.String("client", "", "IP address the client connects from")
+		command    = fs.String("command", "connect", "command, one of connect, bind, udp_associate, resolve or resolve_ptr")
+		resolvedIP = fs.String("resolved-ip", "", "IP address the destination domain name resolves to")
+		at         = fs.String("time", "", "RFC 3339 time of the request, defaults to now")
+	)
+
+	if err := fs.Parse(args); err != nil || *policyFile == "" || fs.NArg() != 1 {
+		fmt.Fprintf(stderr, policyUsage, name)
+		return 2
+	}
+
+	q, err := parseQuery(*user, *groups, *client, *command, fs.Arg(0), *resolvedIP, *at)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	p, err := policy.Load(*policyFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	explanation, err := p.Explain(q)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	printExplanation(stdout, explanation, "")
+	if !explanation.Decision.Allowed() {
+		return 1
+	}
+
+	return 0
+}
+
+func parseQuery(user, groups, client, command, destination, resolvedIP, at string) (policy.Query, error) {
+	q := policy.Query{User: user}
+
+	if groups != "" {
+		q.Groups = strings.Split(groups, ",")
+	}
+
+	if client != "" {
+		addr, err := netip.ParseAddr(client)
+		if err != nil {
+			return q, fmt.Errorf("invalid client IP address %q", client)
+		}
+
+		q.Client = addr.Unmap()
+	}
+
+	cmd, err := policy.ParseCommand(command)
+	if err != nil {
+		return q, err
+	}
+	q.Command = cmd
+
+	dst, err := types.ParseAddress(destination)
+	if err != nil {
+		return q, fmt.Errorf("invalid destination %q, expecting host:port", destination)
+	}
+	q.Destination = *dst
+
+	if resolvedIP != "" {
+		if q.Destination.IP = net.ParseIP(resolvedIP); q.Destination.IP == nil {
+			return q, fmt.Errorf("invalid resolved IP address %q", resolvedIP)
+		}
+	}
+
+	if at != "" {
+		if q.Time, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return q, fmt.Errorf("invalid time %q, expecting an RFC 3339 timestamp", at)
+		}
+	}
+
+	return q, nil
+}
+
+func testPolicy(policyFile, expectationsFile string, stdout, stderr io.Writer) int {
+	p, err := policy.Load(policyFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	expectations, err := policy.LoadExpectations(expectationsFile)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	var failed int
+	for _, e := range expectations {
+		explanation, err := p.Test(e)
+		switch {
+		case errors.Is(err, policy.ErrUnmetExpectation):
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s (%s:%d)\n      %v\n", e.Name, expectationsFile, e.Line, err)
+			printExplanation(stdout, explanation, "      ")
+		case err != nil:
+			failed++
+			fmt.Fprintf(stdout, "FAIL  %s (%s:%d)\n      %v\n", e.Name, expectationsFile, e.Line, err)
+		default:
+			fmt.Fprintf(stdout, "PASS  %s\n", e.Name)
+		}
+	}
+
+	fmt.Fprintf(stdout, "%d passed, %d failed\n", len(expectations)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func printExplanation(w io.Writer, e *policy.Explanation, indent string) {
+	rule := e.Decision.RuleID
+	if rule == "" {
+		rule = "(none)"
+	}
+
+	fmt.Fprintf(w, "%sdecision:  %s\n", indent, e.Decision.Action)
+	fmt.Fprintf(w, "%srule:      %s\n", indent, rule)
+	if !e.Until.IsZero() {
+		fmt.Fprintf(w, "%suntil:     %s\n", indent, e.Until.Format(time.RFC3339))
+	}
+	if e.Egress != "" {
+		fmt.Fprintf(w, "%segress:    %s\n", indent, e.Egress)
+	}
+
+	fmt.Fprintf(w, "%sreasoning:\n", indent)
+	for i, r := range e.Reasoning {
+		fmt.Fprintf(w, "%s  %d. %s\n", indent, i+1, r)
+	}
+}
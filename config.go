@@ -1,19 +1,47 @@
 package socks5
 
 import (
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth"
 	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/gssapi"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
+	"github.com/ardikabs/socks5/pkg/dialguard"
+	"github.com/ardikabs/socks5/pkg/ratelimit"
 	"github.com/ardikabs/socks5/pkg/request"
 	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/ardikabs/socks5/pkg/upstream"
 	"github.com/go-logr/logr"
 )
 
+// NetworkAuthMethods is an ordered list of authentication methods for clients connecting from the networks.
+type NetworkAuthMethods struct {
+	Networks []netip.Prefix
+	Methods  []types.AuthMethod
+}
+
 // ServerConfig is a configuration for the server.
 type ServerConfig struct {
+	// TLS serves SOCKS and HTTP over TLS, keeping the credentials of the clients off the wire.
+	// The certificate files are reloaded on change and on SIGHUP, see TLSConfig for mutual TLS.
+	// This field is optional, the listener is served in clear text when it is not set.
+	TLS *TLSConfig
+
 	// EnabledAuthMethods is a list of authentication methods that the server supports.
 	// Order of the methods is important, the server will choose the first method that the client supports.
 	// It defaults to [types.AuthNoAuthRequired, types.AuthUserPass].
 	EnabledAuthMethods []types.AuthMethod
 
+	// NetworkAuthMethods overrides EnabledAuthMethods for clients connecting from the given networks,
+	// such as NO AUTHENTICATION REQUIRED for a trusted network and USERNAME/PASSWORD everywhere else.
+	// The first entry whose network contains the client address applies, clients outside of every network
+	// fall back to EnabledAuthMethods.
+	NetworkAuthMethods []NetworkAuthMethods
+
 	// CredentialStore is a store for the server to validate the client's credentials.
 	// This field is optional, but once set, it will ignore UserPassMaps and UserPassFilename.
 	CredentialStore credentials.Storer
@@ -23,12 +51,119 @@ type ServerConfig struct {
 	UserPassMaps map[string]string
 
 	// UserPassFilename is a filename that contains the username and password, used for USERNAME/PASSWORD auth method.
+	// Each line is 'username:hash', hashes can be bcrypt, argon2id, SHA-crypt or any format of Apache htpasswd.
 	// Mutual exclusive with UserPassMaps, UserPassFilename will take precedence if both are set.
 	UserPassFilename string
 
+	// UserPassFilePollInterval is how often UserPassFilename is polled for changes, on top of inotify.
+	// The file is also reloaded on SIGHUP, it defaults to 10 seconds.
+	UserPassFilePollInterval time.Duration
+
+	// UserPassFilePlaintext reads UserPassFilename as 'username:password' lines with the password in clear text.
+	UserPassFilePlaintext bool
+
+	// TerminateRevokedSessions terminates the live sessions of the users whose account expires,
+	// or is disabled or removed when the CredentialStore reloads, such as a UserPassFilename account.
+	// Otherwise, such sessions are left to run until they end and only new sessions are refused.
+	TerminateRevokedSessions bool
+
+	// UserPassLockout locks out the source IPs and usernames with too many failed USERNAME/PASSWORD attempts,
+	// for SOCKS5 and HTTP Basic clients alike. Locked out clients are rejected before the CredentialStore is called.
+	// This field is optional, attempts are not limited when it is not set.
+	UserPassLockout *lockout.Config
+
+	// GSSAPIMechanism is a GSS-API mechanism for the server to accept GSSAPI (RFC 1961) clients, such as Kerberos V5.
+	// The GSSAPI method is only selected when this field is set and types.AuthGSSAPI is in EnabledAuthMethods.
+	GSSAPIMechanism gssapi.Mechanism
+
+	// AuthRegistry is a registry of custom authentication methods, such as the ones in the private range (0x80-0xFE).
+	// Registered methods take precedence over the built-in ones, and must be listed in EnabledAuthMethods to be selected.
+	AuthRegistry *auth.Registry
+
 	// Dialer is a custom dialer for the server to establish connection to the target host.
 	Dialer request.Dialer
 
+	// DialGuard refuses the connections to special-purpose addresses, such as loopback, private networks
+	// and cloud metadata endpoints, see dialguard.DefaultBlocked. The address is checked as it is being dialed,
+	// after resolution, and blocked requests are replied with types.ReplyNotAllowed. Upstream proxies are
+	// not checked, as the targets are left to them.
+	// This field is optional, every address is reachable when it is not set.
+	DialGuard *dialguard.Config
+
+	// Upstreams is an ordered chain of upstream proxies for the server to reach the target host through.
+	// The first proxy is reached with the Dialer, and failures at any hop are replied with the matching reply code.
+	Upstreams []upstream.Proxy
+
+	// UpstreamRemoteResolve leaves the target domain name resolution to the last upstream proxy,
+	// instead of resolving it with the Resolver. It applies to the chains of the Egresses as well.
+	UpstreamRemoteResolve bool
+
+	// AccessRules is an ordered list of allow and deny rules deciding which destinations each client may reach,
+	// the first matching rule decides and denied requests are replied with types.ReplyNotAllowed.
+	// Every decision is logged along with the ID of the rule that took it.
+	// This field is optional, every destination is allowed when neither it nor AccessDefault are set.
+	AccessRules []acl.Rule
+
+	// AccessDefault is the action taken on the requests matching none of the AccessRules, it defaults to deny.
+	AccessDefault acl.Action
+
+	// TerminateOutsideSchedule terminates the live connections once the schedules of the access rules no longer
+	// allow them, such as at the end of the business hours of the rule allowing them. Otherwise, the schedules are
+	// only matched as the requests are made. The datagrams of UDP ASSOCIATE are matched again either way.
+	TerminateOutsideSchedule bool
+
+	// Egresses select the outbound path by the authenticated identity, such as a source IP or an upstream chain
+	// of their own, so the streams of different users never share an exit. The first matching egress applies,
+	// the other clients, anonymous ones included, go through the Dialer and Upstreams.
+	Egresses []Egress
+
+	// RateLimits caps the requests and the bandwidth of the authenticated identities, the first matching rule applies.
+	// Requests over the limit are replied with types.ReplyNotAllowed, or 429 Too Many Requests over HTTP.
+	// This field is optional, identities are not limited when it is not set.
+	RateLimits []ratelimit.Rule
+
+	// PolicyFile is a YAML or JSON file declaring the users and groups, access rules, rate limits and egresses,
+	// see the policy package for its format. It is mutually exclusive with AccessRules, AccessDefault, RateLimits
+	// and Egresses, and its users are authenticated unless a CredentialStore, UserPassMaps or UserPassFilename is set.
+	// The file is reloaded on change and on SIGHUP, the new policy applies to the new sessions while the live ones
+	// keep the policy they started with. An invalid file is refused as a whole, keeping the previous policy.
+	PolicyFile string
+
+	// PolicyFilePollInterval is how often PolicyFile is polled for changes, on top of inotify.
+	// It defaults to 10 seconds.
+	PolicyFilePollInterval time.Duration
+
+	// Resolver is a custom resolver for the server to resolve the target domain name.
+	Resolver request.DomainResolver
+
+	// BindAcceptTimeout is how long a BIND request waits for the inbound connection.
+	// It defaults to request.DefaultBindTimeout.
+	BindAcceptTimeout time.Duration
+
+	// BindAdvertiseIP is the IP address advertised to the client in BIND replies.
+	// This is useful when the server is behind NAT, it defaults to the local address of the listening socket.
+	BindAdvertiseIP net.IP
+
+	// BindCheckPeer restricts BIND to only accept the inbound connection coming from the requested DST.ADDR.
+	BindCheckPeer bool
+
+	// EnableSOCKS4 enables SOCKS4 and SOCKS4a on the same listener, supporting CONNECT and BIND commands.
+	EnableSOCKS4 bool
+
+	// SOCKS4UserIDStore is a store for the server to validate the USERID field of SOCKS4 requests.
+	// The USERID is validated as a username with an empty password.
+	// This field is optional, USERID is not checked when it is not set.
+	SOCKS4UserIDStore credentials.Storer
+
+	// EnableResolveCommands enables the Tor RESOLVE (0xF0) and RESOLVE_PTR (0xF1) extension commands.
+	// Forward and reverse lookups are done with the Resolver, reverse lookups require it to implement request.ReverseResolver.
+	EnableResolveCommands bool
+
+	// EnableHTTP enables HTTP CONNECT and plain HTTP forward proxy on the same listener.
+	// HTTP clients are authenticated with Proxy-Authorization Basic against the CredentialStore,
+	// following the EnabledAuthMethods the same way as SOCKS clients.
+	EnableHTTP bool
+
 	// Logger is a logger for the server to log messages.
 	Logger logr.Logger
 }
@@ -0,0 +1,241 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/request"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/upstream"
+)
+
+// Egress is an outbound path for the identities it matches, by user ID or by group.
+type Egress struct {
+	// Name identifies the egress in the logs.
+	Name string
+
+	// Users and Groups are the identities reaching the targets through this egress,
+	// an identity matches when its user ID is in Users or any of its groups is in Groups.
+	Users  []string
+	Groups []string
+
+	// SourceIP is the local address the connections are dialed from, instead of the Dialer.
+	SourceIP net.IP
+
+	// Upstreams is an ordered chain of upstream proxies, instead of the Upstreams of the server.
+	Upstreams []upstream.Proxy
+}
+
+// egressIdleConnTimeout is how long the idle connections of the forwarded HTTP requests are kept,
+// so the ones of the egresses replaced by a policy reload are eventually closed.
+const egressIdleConnTimeout = 90 * time.Second
+
+// egress is an outbound path ready to dial, along with the HTTP transport of its forwarded requests,
+// so idle connections to the origin servers are never shared with another egress.
+type egress struct {
+	name      string
+	users     map[string]struct{}
+	groups    map[string]struct{}
+	dialer    request.Dialer
+	chained   bool
+	transport *http.Transport
+
+	resolver      request.DomainResolver
+	remoteResolve bool
+}
+
+func (s *Server) newEgress(name string, sourceIP net.IP, hops []upstream.Proxy) (*egress, error) {
+	e := &egress{
+		name:     name,
+		users:    make(map[string]struct{}),
+		groups:   make(map[string]struct{}),
+		chained:  len(hops) > 0,
+		resolver: s.cfg.Resolver,
+
+		remoteResolve: s.cfg.UpstreamRemoteResolve,
+	}
+
+	e.dialer = s.egressDialer(sourceIP, e.chained)
+	if e.chained {
+		d, err := upstream.NewDialer(hops, e.dialer)
+		if err != nil {
+			return nil, err
+		}
+
+		e.dialer = d
+	}
+
+	e.transport = &http.Transport{DialContext: e.dial, IdleConnTimeout: egressIdleConnTimeout}
+	return e, nil
+}
+
+// setupEgresses builds the default egress out of the Dialer and Upstreams of the server, followed by the given egresses.
+func (s *Server) setupEgresses(rs *ruleset, egresses []Egress) error {
+	def, err := s.newEgress("default", nil, s.cfg.Upstreams)
+	if err != nil {
+		return err
+	}
+
+	rs.defaultEgress = def
+
+	names := map[string]struct{}{def.name: {}}
+	for i, cfg := range egresses {
+		if cfg.Name == "" {
+			return fmt.Errorf("egress #%d: name is required", i)
+		}
+
+		if _, ok := names[cfg.Name]; ok {
+			return fmt.Errorf("egress %q: duplicate name", cfg.Name)
+		}
+		names[cfg.Name] = struct{}{}
+
+		if len(cfg.Users) == 0 && len(cfg.Groups) == 0 {
+			return fmt.Errorf("egress %q: either users or groups are required", cfg.Name)
+		}
+
+		e, err := s.newEgress(cfg.Name, cfg.SourceIP, cfg.Upstreams)
+		if err != nil {
+			return fmt.Errorf("egress %q: %w", cfg.Name, err)
+		}
+
+		for _, u := range cfg.Users {
+			e.users[u] = struct{}{}
+		}
+
+		for _, g := range cfg.Groups {
+			e.groups[g] = struct{}{}
+		}
+
+		rs.egresses = append(rs.egresses, e)
+	}
+
+	return nil
+}
+
+// egressFor returns the egress of the identity authenticated in the context,
+// the first matching egress wins and the others, anonymous clients included, use the default egress.
+func (s *Server) egressFor(ctx context.Context) *egress {
+	rs := s.rulesetOf(ctx)
+
+	if identity := contexts.GetIdentity(ctx); identity != nil {
+		for _, e := range rs.egresses {
+			if e.matches(identity) {
+				return e
+			}
+		}
+	}
+
+	return rs.defaultEgress
+}
+
+// resolvesRemotely reports whether the domain names are left to the egress of the identity authenticated
+// in the context, the others are resolved beforehand so the access rules match their IP address.
+func (s *Server) resolvesRemotely(ctx context.Context) bool {
+	return s.egressFor(ctx).resolvesRemotely()
+}
+
+// relaysUDP reports whether the egress of the identity authenticated in the context carries UDP,
+// the upstream proxy chains only carrying TCP.
+func (s *Server) relaysUDP(ctx context.Context) bool {
+	return !s.egressFor(ctx).chained
+}
+
+// dial dials the target through the egress of the identity authenticated in the context.
+func (s *Server) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	e := s.egressFor(ctx)
+	contexts.GetLogger(ctx).V(1).Info("selected egress", "egress", e.name)
+
+	return e.dial(ctx, network, address)
+}
+
+// resolvesRemotely reports whether the domain names are left to the last upstream proxy of the egress.
+func (e *egress) resolvesRemotely() bool {
+	return e.chained && e.remoteResolve
+}
+
+func (e *egress) matches(identity *credentials.Identity) bool {
+	if _, ok := e.users[identity.UserID]; ok {
+		return true
+	}
+
+	for _, g := range identity.Groups {
+		if _, ok := e.groups[g]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+type dialIPKey struct{}
+
+// withDialIP pins the domain names dialed with the context to the IP address the access rules were matched against,
+// rather than resolving them again to whatever they resolve to by then.
+func withDialIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, dialIPKey{}, ip)
+}
+
+// dial dials the target, domain names are only left to the last upstream proxy with remote resolution,
+// and resolved with the Resolver otherwise, unless pinned with withDialIP.
+func (e *egress) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if e.resolvesRemotely() {
+		return e.dialer(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) == nil {
+		ip, ok := ctx.Value(dialIPKey{}).(net.IP)
+		if !ok {
+			if ip, err = e.resolver.Resolve(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+
+		address = net.JoinHostPort(ip.String(), port)
+	}
+
+	return e.dialer(ctx, network, address)
+}
+
+// egressDialer returns the dialer an egress reaches the targets, or the first hop of its chain, with.
+// The targets reached directly are checked by the DialGuard, as the chains leave the targets to their last hop.
+func (s *Server) egressDialer(sourceIP net.IP, chained bool) request.Dialer {
+	var control func(network, address string, c syscall.RawConn) error
+	if s.dialGuard != nil && !chained {
+		control = s.dialGuard.Control
+	}
+
+	switch {
+	case sourceIP != nil, s.cfg.Dialer == nil:
+		return netDialer(sourceIP, control)
+	case control != nil:
+		return s.dialGuard.Wrap(s.cfg.Dialer)
+	default:
+		return s.cfg.Dialer
+	}
+}
+
+// netDialer returns a dialer optionally binding the outbound connections to the local IP address,
+// and calling control on every connection right before it is made.
+func netDialer(localIP net.IP, control func(network, address string, c syscall.RawConn) error) request.Dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		d := net.Dialer{Control: control}
+		if localIP != nil {
+			d.LocalAddr = &net.TCPAddr{IP: localIP}
+			if network == "udp" || network == "udp4" || network == "udp6" {
+				d.LocalAddr = &net.UDPAddr{IP: localIP}
+			}
+		}
+
+		return d.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,248 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/ardikabs/socks5/pkg/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// identityStore identifies the users of its map, whose password is always 'password'.
+type identityStore map[string]*credentials.Identity
+
+func (s identityStore) Validate(p credentials.Parameters) error {
+	_, err := s.Identify(context.Background(), nil, p)
+	return err
+}
+
+func (s identityStore) Identify(_ context.Context, _ net.Addr, p credentials.Parameters) (*credentials.Identity, error) {
+	identity, ok := s[p.Username]
+	if !ok || p.Password != "password" {
+		return nil, credentials.ErrInvalidCredentials
+	}
+
+	return identity, nil
+}
+
+func TestServer_Egresses(t *testing.T) {
+	// Create dummy server, replying with the address the connection comes from
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			_, _ = conn.Write([]byte(host))
+			conn.Close()
+		}
+	}()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprint(w, host)
+	}))
+	defer origin.Close()
+
+	// Create the upstream proxy, reaching the targets from its own source IP
+	upstreamAddr := "127.0.0.1:20094"
+	upstreamSrv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		Dialer:             netDialer(net.IPv4(127, 0, 0, 3), nil),
+	})
+	require.NoError(t, err)
+	defer upstreamSrv.Shutdown()
+
+	go func() { upstreamSrv.ListenAndServe(upstreamAddr) }()
+
+	srvAddr := "127.0.0.1:20095"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		CredentialStore: identityStore{
+			"alice": {UserID: "alice"},
+			"bob":   {UserID: "bob", Groups: []string{"scrapers"}},
+			"carol": {UserID: "carol"},
+		},
+		EnableHTTP: true,
+		Egresses: []Egress{
+			{Name: "alice", Users: []string{"alice"}, SourceIP: net.IPv4(127, 0, 0, 2)},
+			{Name: "scrapers", Groups: []string{"scrapers"}, Upstreams: []upstream.Proxy{{Type: upstream.TypeSOCKS5, Address: upstreamAddr}}},
+		},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	for username, want := range map[string]string{
+		"alice": "127.0.0.2",
+		"bob":   "127.0.0.3",
+		"carol": "127.0.0.1",
+	} {
+		t.Run(username, func(t *testing.T) {
+			d := client.New(srvAddr, client.WithUserPass(username, "password"))
+
+			conn, err := d.DialContext(context.Background(), "tcp", dummyListener.Addr().String())
+			require.NoError(t, err)
+			defer conn.Close()
+
+			out := make([]byte, len(want))
+			_, err = io.ReadAtLeast(conn, out, len(out))
+			require.NoError(t, err)
+			assert.Equal(t, want, string(out))
+
+			// Forwarded HTTP requests take the same egress
+			proxyURL := &url.URL{Scheme: "http", Host: srvAddr, User: url.UserPassword(username, "password")}
+			httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+			resp, err := httpClient.Get(origin.URL)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			out, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, want, string(out))
+		})
+	}
+}
+
+func TestServer_EgressFor(t *testing.T) {
+	srv, err := New(ServerConfig{
+		Egresses: []Egress{
+			{Name: "ops", Groups: []string{"ops"}, SourceIP: net.IPv4(127, 0, 0, 2)},
+			{Name: "alice", Users: []string{"alice"}, SourceIP: net.IPv4(127, 0, 0, 3)},
+		},
+	})
+	require.NoError(t, err)
+
+	egressOf := func(identity *credentials.Identity) string {
+		ctx := context.Background()
+		if identity != nil {
+			ctx = contexts.WithAuth(ctx, &auth.AuthContext{Method: types.AuthUserPass, Identity: identity})
+		}
+
+		return srv.egressFor(ctx).name
+	}
+
+	assert.Equal(t, "default", egressOf(nil))
+	assert.Equal(t, "alice", egressOf(&credentials.Identity{UserID: "alice"}))
+	assert.Equal(t, "ops", egressOf(&credentials.Identity{UserID: "alice", Groups: []string{"ops"}}))
+	assert.Equal(t, "default", egressOf(&credentials.Identity{UserID: "bob", Groups: []string{"dev"}}))
+
+	for _, egresses := range [][]Egress{
+		{{Users: []string{"alice"}}},
+		{{Name: "default", Users: []string{"alice"}}},
+		{{Name: "alice"}},
+		{{Name: "alice", Users: []string{"alice"}, Upstreams: []upstream.Proxy{{Type: "ftp", Address: "127.0.0.1:21"}}}},
+	} {
+		_, err := New(ServerConfig{Egresses: egresses})
+		require.Error(t, err)
+	}
+}
+
+func TestServer_EgressRemoteResolve(t *testing.T) {
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	_, dummyPort, _ := net.SplitHostPort(dummyListener.Addr().String())
+
+	// Only the scrapers leave the domain names to their upstream proxy, which is never reached here
+	srvAddr := "127.0.0.1:20104"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		CredentialStore: identityStore{
+			"bob":   {UserID: "bob", Groups: []string{"scrapers"}},
+			"carol": {UserID: "carol"},
+		},
+		Resolver: stubResolver{"local.example.com": net.IPv4(127, 0, 0, 1)},
+		Egresses: []Egress{
+			{Name: "scrapers", Groups: []string{"scrapers"}, Upstreams: []upstream.Proxy{{Type: upstream.TypeSOCKS5, Address: "127.0.0.1:1"}}},
+		},
+		UpstreamRemoteResolve: true,
+		AccessRules: []acl.Rule{
+			{ID: "loopback", Action: acl.ActionDeny, Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+		},
+		AccessDefault: acl.ActionAllow,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The domain names of the clients on a direct egress are resolved before the access rules are matched
+	d := client.New(srvAddr, client.WithUserPass("carol", "password"))
+	_, err = d.Dial("tcp", net.JoinHostPort("local.example.com", dummyPort))
+
+	var repErr *types.ReplyError
+	require.ErrorAs(t, err, &repErr)
+	require.Equal(t, types.ReplyNotAllowed, repErr.Code)
+
+	// The ones of the scrapers are left to the upstream proxy as they are, rather than failing to resolve locally
+	d = client.New(srvAddr, client.WithUserPass("bob", "password"))
+	_, err = d.Dial("tcp", net.JoinHostPort("remote.example.com", dummyPort))
+	require.ErrorAs(t, err, &repErr)
+	require.NotEqual(t, types.ReplyHostUnreach, repErr.Code)
+}
+
+func TestServer_EgressUDPAssociate(t *testing.T) {
+	// The upstream proxy chains only carry TCP, so their clients are not offered a UDP relay
+	srvAddr := "127.0.0.1:20105"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		CredentialStore: identityStore{
+			"bob":   {UserID: "bob", Groups: []string{"scrapers"}},
+			"carol": {UserID: "carol"},
+		},
+		Egresses: []Egress{
+			{Name: "scrapers", Groups: []string{"scrapers"}, Upstreams: []upstream.Proxy{{Type: upstream.TypeSOCKS5, Address: "127.0.0.1:1"}}},
+		},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	associate := func(username string) error {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		d := client.New(srvAddr, client.WithUserPass(username, "password"))
+		_, err = d.Handshake(context.Background(), conn, types.CommandUDPAssoc, &types.Address{IP: net.IPv4zero})
+		return err
+	}
+
+	require.NoError(t, associate("carol"))
+
+	var repErr *types.ReplyError
+	require.ErrorAs(t, associate("bob"), &repErr)
+	require.Equal(t, types.ReplyCommandNotSupported, repErr.Code)
+}
@@ -0,0 +1,350 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
+	"github.com/ardikabs/socks5/pkg/request"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+const (
+	// httpMaxHeaderBytes caps the size of the request line and headers, as http.Server does by default.
+	httpMaxHeaderBytes = http.DefaultMaxHeaderBytes
+
+	// httpReadHeaderTimeout is how long a client has to send the request line and headers,
+	// so a client that never completes them doesn't hold the connection before being authenticated.
+	httpReadHeaderTimeout = 10 * time.Second
+)
+
+var errHTTPHeaderTooLarge = fmt.Errorf("HTTP request headers are larger than %d bytes", httpMaxHeaderBytes)
+
+// hopByHopHeaders are meaningful only for a single transport-level connection,
+// and must not be forwarded by proxies.
+// Reference: https://datatracker.ietf.org/doc/html/rfc9110#section-7.6.1
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// isHTTPMethodStart reports whether b can be the first byte of an HTTP request line,
+// HTTP methods are made of uppercase letters and never collide with the SOCKS version byte.
+func isHTTPMethodStart(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+// bufferedConn is a net.Conn that reads through a buffered reader,
+// so bytes already buffered while parsing HTTP are not lost when tunneling.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// headerLimitReader caps how much is read while the headers are parsed, the body and the tunnel are not capped.
+type headerLimitReader struct {
+	r         io.Reader
+	remaining int64
+	limited   bool
+}
+
+func (l *headerLimitReader) limit(n int64) {
+	l.remaining, l.limited = n, true
+}
+
+func (l *headerLimitReader) Read(b []byte) (int, error) {
+	if !l.limited {
+		return l.r.Read(b)
+	}
+
+	if l.remaining <= 0 {
+		return 0, errHTTPHeaderTooLarge
+	}
+
+	if int64(len(b)) > l.remaining {
+		b = b[:l.remaining]
+	}
+
+	n, err := l.r.Read(b)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// exceeded reports whether the limit was reached, the parser may not return the error of Read as is.
+func (l *headerLimitReader) exceeded() bool {
+	return l.limited && l.remaining <= 0
+}
+
+func (s *Server) handleHTTP(ctx context.Context, conn net.Conn, firstByte byte) {
+	log := contexts.GetLogger(ctx).WithValues("protocol", "http")
+
+	lr := &headerLimitReader{r: io.MultiReader(strings.NewReader(string(firstByte)), conn)}
+	br := bufio.NewReader(lr)
+	bconn := &bufferedConn{Conn: conn, r: br}
+
+	for {
+		// The limit leaves room for what the buffered reader already holds, as http.Server does
+		lr.limit(int64(httpMaxHeaderBytes + br.Size() - br.Buffered()))
+		_ = conn.SetReadDeadline(time.Now().Add(httpReadHeaderTimeout))
+
+		httpReq, err := http.ReadRequest(br)
+		if err != nil {
+			switch {
+			case lr.exceeded():
+				log.Error(errHTTPHeaderTooLarge, "failed to parse HTTP request", "phase", "request parsing")
+				writeHTTPStatus(conn, http.StatusRequestHeaderFieldsTooLarge, nil)
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				log.Info("HTTP request headers not received in time, closing ...", "phase", "request parsing")
+			case !errors.Is(err, io.EOF):
+				log.Error(err, "failed to parse HTTP request", "phase", "request parsing")
+				writeHTTPStatus(conn, http.StatusBadRequest, nil)
+			}
+
+			return
+		}
+
+		lr.limited = false
+		_ = conn.SetReadDeadline(time.Time{})
+
+		authCtx, err := s.authenticateHTTP(ctx, httpReq, conn.RemoteAddr())
+		if err != nil {
+			switch {
+			case errors.Is(err, lockout.ErrLockedOut):
+				writeHTTPStatus(conn, http.StatusTooManyRequests, nil)
+			case errors.Is(err, credentials.ErrInvalidCredentials), errors.Is(err, auth.ErrAuthNotSupported):
+				header := http.Header{}
+				header.Set("Proxy-Authenticate", `Basic realm="socks5"`)
+				writeHTTPStatus(conn, http.StatusProxyAuthRequired, header)
+			default:
+				// The credentials couldn't be checked, such as when the store is unreachable
+				writeHTTPStatus(conn, http.StatusServiceUnavailable, nil)
+			}
+
+			log.Error(err, "failed to authenticate HTTP client", "phase", "authentication")
+			return
+		}
+
+		s.identifyClientCertificate(conn, authCtx)
+
+		if httpReq.Method != http.MethodConnect && !httpReq.URL.IsAbs() {
+			writeHTTPStatus(conn, http.StatusBadRequest, nil)
+			log.Info("HTTP request is not in absolute-form, closing ...", "phase", "request parsing", "uri", httpReq.RequestURI)
+			return
+		}
+
+		// Every request is a session of its own, as the credentials and the policy may change from a request to the next
+		reqCtx, release, err := s.startSession(contexts.WithAuth(s.withRuleset(ctx), authCtx), authCtx)
+		if err != nil {
+			writeHTTPStatus(conn, http.StatusTooManyRequests, nil)
+			log.Error(err, "failed to start session", "phase", "session")
+			return
+		}
+
+		limited := s.limitConn(reqCtx, bconn)
+		if httpReq.Method == http.MethodConnect {
+			s.handleHTTPConnect(reqCtx, limited, httpReq)
+			release()
+			return
+		}
+
+		keepAlive := s.forwardHTTP(reqCtx, limited, httpReq)
+		release()
+
+		if !keepAlive {
+			return
+		}
+	}
+}
+
+// authenticateHTTP maps the Proxy-Authorization Basic credentials onto the USERNAME/PASSWORD method,
+// following the same order of preference as the SOCKS method selection.
+func (s *Server) authenticateHTTP(ctx context.Context, httpReq *http.Request, remote net.Addr) (*auth.AuthContext, error) {
+	username, password, hasCredentials := parseProxyAuthorization(httpReq.Header.Get("Proxy-Authorization"))
+
+	for _, method := range s.authMethods(remote) {
+		switch method {
+		case types.AuthNoAuthRequired:
+			return &auth.AuthContext{Method: types.AuthNoAuthRequired}, nil
+		case types.AuthUserPass:
+			if !hasCredentials || s.cfg.CredentialStore == nil {
+				continue
+			}
+
+			identity, err := auth.ValidateCredentials(ctx, s.lockout, s.cfg.CredentialStore, remote, credentials.Parameters{
+				Username: username,
+				Password: password,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return &auth.AuthContext{
+				Method: types.AuthUserPass,
+				Payload: auth.AuthPayload{
+					"username": username,
+				},
+				Identity: identity,
+			}, nil
+		}
+	}
+
+	return nil, auth.ErrAuthNotSupported
+}
+
+func (s *Server) handleHTTPConnect(ctx context.Context, conn net.Conn, httpReq *http.Request) {
+	log := contexts.GetLogger(ctx).WithValues("protocol", "http")
+
+	addr, err := types.ParseAddress(httpReq.Host)
+	if err != nil {
+		writeHTTPStatus(conn, http.StatusBadRequest, nil)
+		log.Error(err, "failed to parse CONNECT target", "phase", "request parsing", "host", httpReq.Host)
+		return
+	}
+
+	req, err := request.New(types.CommandConnect, addr, SendReplyHTTP, s.requestOptions(ctx)...)
+	if err != nil {
+		writeHTTPStatus(conn, http.StatusInternalServerError, nil)
+		log.Error(err, "failed to create request", "phase", "request parsing")
+		return
+	}
+
+	if err := req.Handle(ctx, conn); err != nil {
+		log.Error(err, "failed to handle HTTP CONNECT request", "phase", "request handling")
+		return
+	}
+
+	log.Info("handling HTTP CONNECT request completed", "remote", req.GetAddress().String(), "phase", "completion")
+}
+
+// forwardHTTP forwards a request in absolute-form to the origin server,
+// it reports whether the client connection can be reused for the next request.
+func (s *Server) forwardHTTP(ctx context.Context, conn net.Conn, httpReq *http.Request) bool {
+	log := contexts.GetLogger(ctx).WithValues("protocol", "http", "method", httpReq.Method, "url", httpReq.URL.String())
+
+	// The access rules see a forwarded request as a CONNECT to the origin server
+	if s.rulesetOf(ctx).acl != nil {
+		addr, err := types.ParseAddress(originAddress(httpReq.URL))
+		if err != nil {
+			writeHTTPStatus(conn, http.StatusBadRequest, nil)
+			log.Error(err, "failed to parse origin server address", "phase", "request parsing")
+			return false
+		}
+
+		req, err := request.New(types.CommandConnect, addr, SendReplyHTTP, s.requestOptions(ctx)...)
+		if err != nil {
+			writeHTTPStatus(conn, http.StatusInternalServerError, nil)
+			log.Error(err, "failed to create request", "phase", "request parsing")
+			return false
+		}
+
+		if err := req.Authorize(ctx, conn); err != nil {
+			log.Error(err, "failed to forward HTTP request", "phase", "authorization")
+			return false
+		}
+
+		var cancel context.CancelFunc
+		ctx, cancel = req.CutOff(ctx)
+		defer cancel()
+
+		// The origin server is dialed at the IP address just authorized, the URL and so the Host header are kept
+		if ip := req.GetAddress().IP; ip != nil {
+			ctx = withDialIP(ctx, ip)
+		}
+	}
+
+	outReq := httpReq.Clone(ctx)
+	outReq.RequestURI = ""
+	removeHopByHopHeaders(outReq.Header)
+
+	resp, err := s.egressFor(ctx).transport.RoundTrip(outReq)
+	if err != nil {
+		status := http.StatusBadGateway
+
+		var repErr *types.ReplyError
+		if errors.As(err, &repErr) && repErr.Code == types.ReplyNotAllowed {
+			status = http.StatusForbidden
+		}
+
+		writeHTTPStatus(conn, status, nil)
+		log.Error(err, "failed to forward HTTP request", "phase", "request handling")
+		return false
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	if err := resp.Write(conn); err != nil {
+		log.Error(err, "failed to write HTTP response", "phase", "request handling")
+		return false
+	}
+
+	log.Info("handling HTTP request completed", "status", resp.StatusCode, "phase", "completion")
+	return !resp.Close && !httpReq.Close
+}
+
+// originAddress returns the host:port of the origin server, the port defaults to the one of the scheme.
+func originAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func parseProxyAuthorization(value string) (username, password string, ok bool) {
+	// Borrow the Basic credentials parser of net/http, which only looks at the Authorization header
+	r := http.Request{Header: http.Header{"Authorization": []string{value}}}
+	return r.BasicAuth()
+}
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+func writeHTTPStatus(w io.Writer, status int, header http.Header) {
+	resp := &http.Response{
+		StatusCode:    status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		ContentLength: 0,
+		Close:         status != http.StatusOK,
+	}
+
+	_ = resp.Write(w)
+}
@@ -0,0 +1,115 @@
+package socks5
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_HTTP(t *testing.T) {
+	// Create dummy HTTP server
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("Proxy-Authorization"))
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	originAddr := origin.Listener.Addr().String()
+
+	// Create SOCKS5 server with HTTP proxy enabled
+	srvAddr := "127.0.0.1:20084"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		UserPassMaps:       map[string]string{"user": "password"},
+		EnableHTTP:         true,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	t.Run("forward proxy", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: srvAddr, User: url.UserPassword("user", "password")}),
+			},
+		}
+
+		resp, err := client.Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "ok", string(body))
+	})
+
+	t.Run("forward proxy without credentials", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: srvAddr}),
+			},
+		}
+
+		resp, err := client.Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+		require.Equal(t, `Basic realm="socks5"`, resp.Header.Get("Proxy-Authenticate"))
+	})
+
+	t.Run("CONNECT", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		credentials := base64.StdEncoding.EncodeToString([]byte("user:password"))
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\nProxy-Authorization: Basic %s\r\n\r\n", originAddr, originAddr, credentials)
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// Tunnel is established, speak HTTP to the origin server through it
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", originAddr)
+
+		resp, err = http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(body))
+	})
+
+	t.Run("headers too large", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Exactly as much as the server reads before giving up, so it has nothing left unread when closing
+		head := fmt.Sprintf("GET %s HTTP/1.1\r\nX-Padding: ", origin.URL)
+		_, err = io.WriteString(conn, head+strings.Repeat("a", httpMaxHeaderBytes+4096-len(head)))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	})
+}
@@ -0,0 +1,444 @@
+// Package acl decides whether a client may reach a destination, with an ordered list of allow and deny rules.
+package acl
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+var (
+	ErrInvalidRule = fmt.Errorf("invalid access rule")
+)
+
+// Action is what happens to the requests matching a rule.
+type Action string
+
+const (
+	ActionAllow = Action("allow")
+	ActionDeny  = Action("deny")
+)
+
+// DefaultRuleID is the rule ID of the decisions taken when no rule matches.
+const DefaultRuleID = "default"
+
+// PortRange is an inclusive range of ports, a single port has From and To equal.
+type PortRange struct {
+	From uint16
+	To   uint16
+}
+
+func (p PortRange) String() string {
+	if p.From == p.To {
+		return strconv.Itoa(int(p.From))
+	}
+
+	return fmt.Sprintf("%d-%d", p.From, p.To)
+}
+
+func (p PortRange) Contains(port int) bool {
+	return port >= int(p.From) && port <= int(p.To)
+}
+
+// Rule matches the requests meeting every criterion it sets, a criterion matches when any of its values does.
+// A rule without any criterion matches every request.
+type Rule struct {
+	// ID identifies the rule in the logs and decisions, it must be unique.
+	ID     string
+	Action Action
+
+	// Commands are the SOCKS commands, such as types.CommandConnect.
+	Commands []types.CommandID
+
+	// Users and Groups are matched against the authenticated identity, anonymous clients never match them.
+	// A rule setting both matches the identities meeting either of them.
+	Users  []string
+	Groups []string
+
+	// Clients are the networks the client connects from.
+	Clients []netip.Prefix
+
+	// Networks and Domains are matched against the destination, a rule setting both matches the destinations
+	// meeting either of them. Networks only match destinations whose IP address is known, such as once resolved.
+	//
+	// A domain is matched exactly as 'example.com', along with its subdomains as '.example.com',
+	// or as a glob pattern such as '*.example.com' or 'api-?.example.com'. Matching is case-insensitive.
+	Networks []netip.Prefix
+	Domains  []string
+
+	// Ports are the destination ports.
+	Ports []PortRange
+
+	// Schedules are the windows of time the rule applies in, such as business hours in a timezone.
+	Schedules []Schedule
+}
+
+// Request is the request to take a decision on.
+type Request struct {
+	Command     types.CommandID
+	Client      netip.Addr
+	Identity    *credentials.Identity
+	Destination types.Address
+
+	// Time is when the request is made, it defaults to now.
+	Time time.Time
+}
+
+// Decision is the outcome of the evaluation, along with the rule that took it.
+type Decision struct {
+	Action Action
+	RuleID string
+}
+
+func (d Decision) Allowed() bool {
+	return d.Action == ActionAllow
+}
+
+// ACL is an ordered list of rules, the first rule matching the request decides.
+// The requests matching no rule are decided by the default action.
+type ACL struct {
+	rules         []Rule
+	defaultAction Action
+}
+
+// New validates the rules, the default action is deny unless given otherwise.
+func New(rules []Rule, defaultAction Action) (*ACL, error) {
+	if defaultAction == "" {
+		defaultAction = ActionDeny
+	}
+
+	if !defaultAction.valid() {
+		return nil, fmt.Errorf("%w: unknown default action %q", ErrInvalidRule, defaultAction)
+	}
+
+	ids := make(map[string]struct{}, len(rules))
+	for i, r := range rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("%w: rule #%d has no ID", ErrInvalidRule, i)
+		}
+
+		if _, ok := ids[r.ID]; ok || r.ID == DefaultRuleID {
+			return nil, fmt.Errorf("%w: duplicate rule ID %q", ErrInvalidRule, r.ID)
+		}
+		ids[r.ID] = struct{}{}
+
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%w: rule %q: %v", ErrInvalidRule, r.ID, err)
+		}
+	}
+
+	return &ACL{rules: rules, defaultAction: defaultAction}, nil
+}
+
+// Evaluate returns the decision of the first rule matching the request, or the default one.
+func (a *ACL) Evaluate(req Request) Decision {
+	req.Time = req.time()
+
+	for _, r := range a.rules {
+		if r.Matches(req) {
+			return Decision{Action: r.Action, RuleID: r.ID}
+		}
+	}
+
+	return Decision{Action: a.defaultAction, RuleID: DefaultRuleID}
+}
+
+// Until returns the first instant the schedules of the rules may change the decision on the request,
+// such as at the end of the window of the rule taking it. It is the zero time when the decision
+// doesn't depend on the time, or doesn't change within a year.
+func (a *ACL) Until(req Request) time.Time {
+	req.Time = req.time()
+
+	var until time.Time
+	for _, r := range a.rules {
+		if !r.matchesRequest(req) {
+			continue
+		}
+
+		// The rules out of their window now may take over the decision once in it
+		until = earliest(until, scheduleChange(r.Schedules, req.Time))
+		if r.matchesSchedules(req.Time) {
+			break
+		}
+	}
+
+	return until
+}
+
+// Explain evaluates the request like Evaluate, along with why each rule up to the decision matches or not.
+func (a *ACL) Explain(req Request) (Decision, []string) {
+	req.Time = req.time()
+
+	var reasons []string
+	decision := a.Evaluate(req)
+	for _, r := range a.rules {
+		if r.ID == decision.RuleID {
+			reasons = append(reasons, fmt.Sprintf("rule %q matches, the request is %s", r.ID, r.Action.verb()))
+			break
+		}
+
+		reasons = append(reasons, fmt.Sprintf("rule %q does not match, %s", r.ID, r.mismatch(req)))
+	}
+
+	if decision.RuleID == DefaultRuleID {
+		reasons = append(reasons, fmt.Sprintf("no rule matches, the default action is %s", a.defaultAction))
+	}
+
+	if until := a.Until(req); !until.IsZero() {
+		reasons = append(reasons, fmt.Sprintf("the decision holds until %s, when the schedules may change it", until.Format(time.RFC3339)))
+	}
+
+	return decision, reasons
+}
+
+func (a Action) verb() string {
+	if a == ActionAllow {
+		return "allowed"
+	}
+
+	return "denied"
+}
+
+func (a Action) valid() bool {
+	return a == ActionAllow || a == ActionDeny
+}
+
+func (r Rule) validate() error {
+	if !r.Action.valid() {
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	for _, p := range append(append([]netip.Prefix(nil), r.Clients...), r.Networks...) {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid network %q", p)
+		}
+	}
+
+	for _, d := range r.Domains {
+		if strings.Trim(d, ".") == "" {
+			return fmt.Errorf("empty domain %q", d)
+		}
+
+		if _, err := path.Match(d, ""); err != nil {
+			return fmt.Errorf("invalid domain pattern %q: %v", d, err)
+		}
+	}
+
+	for _, p := range r.Ports {
+		if p.From > p.To {
+			return fmt.Errorf("invalid port range %d-%d", p.From, p.To)
+		}
+	}
+
+	for _, s := range r.Schedules {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the request meets every criterion of the rule.
+func (r Rule) Matches(req Request) bool {
+	return r.matchesRequest(req) && r.matchesSchedules(req.time())
+}
+
+// matchesRequest reports whether the request meets every criterion of the rule but the schedules.
+func (r Rule) matchesRequest(req Request) bool {
+	return r.matchesCommand(req.Command) &&
+		r.matchesIdentity(req.Identity) &&
+		r.matchesClient(req.Client) &&
+		r.matchesDestination(req.Destination) &&
+		r.matchesPort(req.Destination.Port)
+}
+
+// mismatch returns the first criterion of the rule the request doesn't meet, it is empty when the rule matches.
+func (r Rule) mismatch(req Request) string {
+	switch {
+	case !r.matchesCommand(req.Command):
+		return fmt.Sprintf("command %s is not one of %v", req.Command, r.Commands)
+	case !r.matchesIdentity(req.Identity):
+		if req.Identity == nil {
+			return "the client is anonymous and the rule is for users or groups"
+		}
+
+		return fmt.Sprintf("user %q of groups %v is not in the users %v or groups %v", req.Identity.UserID, req.Identity.Groups, r.Users, r.Groups)
+	case !r.matchesClient(req.Client):
+		return fmt.Sprintf("client %s is not in %v", req.Client, r.Clients)
+	case !r.matchesDestination(req.Destination):
+		why := fmt.Sprintf("destination %s is not in the networks %v or domains %v", destinationHost(req.Destination), r.Networks, r.Domains)
+		if len(r.Networks) > 0 && req.Destination.IP == nil {
+			why += ", its IP address being unknown"
+		}
+
+		return why
+	case !r.matchesPort(req.Destination.Port):
+		return fmt.Sprintf("port %d is not in %v", req.Destination.Port, r.Ports)
+	case !r.matchesSchedules(req.time()):
+		return fmt.Sprintf("time %s is outside the schedules %v", req.time().Format(time.RFC3339), r.Schedules)
+	default:
+		return ""
+	}
+}
+
+func (req Request) time() time.Time {
+	if req.Time.IsZero() {
+		return time.Now()
+	}
+
+	return req.Time
+}
+
+func destinationHost(dst types.Address) string {
+	switch {
+	case dst.DomainName != "" && dst.IP != nil:
+		return fmt.Sprintf("%s (%s)", dst.DomainName, dst.IP)
+	case dst.DomainName != "":
+		return dst.DomainName
+	default:
+		return dst.IP.String()
+	}
+}
+
+func (r Rule) matchesCommand(cmd types.CommandID) bool {
+	if len(r.Commands) == 0 {
+		return true
+	}
+
+	for _, c := range r.Commands {
+		if c == cmd {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) matchesIdentity(identity *credentials.Identity) bool {
+	if len(r.Users) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+
+	if identity == nil {
+		return false
+	}
+
+	for _, u := range r.Users {
+		if u == identity.UserID {
+			return true
+		}
+	}
+
+	for _, g := range r.Groups {
+		for _, ig := range identity.Groups {
+			if g == ig {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (r Rule) matchesClient(client netip.Addr) bool {
+	if len(r.Clients) == 0 {
+		return true
+	}
+
+	return containsAddr(r.Clients, client)
+}
+
+func (r Rule) matchesDestination(dst types.Address) bool {
+	if len(r.Networks) == 0 && len(r.Domains) == 0 {
+		return true
+	}
+
+	if ip, ok := netip.AddrFromSlice(dst.IP); ok && containsAddr(r.Networks, ip) {
+		return true
+	}
+
+	if dst.DomainName == "" {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimSuffix(dst.DomainName, "."))
+	for _, d := range r.Domains {
+		if matchDomain(strings.ToLower(d), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) matchesPort(port int) bool {
+	if len(r.Ports) == 0 {
+		return true
+	}
+
+	for _, p := range r.Ports {
+		if p.Contains(port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r Rule) matchesSchedules(t time.Time) bool {
+	if len(r.Schedules) == 0 {
+		return true
+	}
+
+	return containsTime(r.Schedules, t)
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchDomain matches the lowercase domain name against the pattern, see Rule.Domains.
+func matchDomain(pattern, name string) bool {
+	switch {
+	case strings.ContainsAny(pattern, "*?["):
+		ok, _ := path.Match(pattern, name)
+		return ok
+	case strings.HasPrefix(pattern, "."):
+		return name == pattern[1:] || strings.HasSuffix(name, pattern)
+	default:
+		return name == pattern
+	}
+}
+
+// ClientAddr returns the IP address of the client address, it is the zero value when unknown.
+func ClientAddr(addr net.Addr) netip.Addr {
+	if addr == nil {
+		return netip.Addr{}
+	}
+
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return ap.Addr().Unmap()
+}
@@ -0,0 +1,154 @@
+package acl
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACL_Evaluate(t *testing.T) {
+	a, err := New([]Rule{
+		{ID: "no-bind", Action: ActionDeny, Commands: []types.CommandID{types.CommandBIND}},
+		{ID: "metadata", Action: ActionDeny, Networks: []netip.Prefix{netip.MustParsePrefix("169.254.0.0/16")}},
+		{ID: "ops-internal", Action: ActionAllow, Groups: []string{"ops"}, Domains: []string{".corp.example.com"}, Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{ID: "alice-web", Action: ActionAllow, Users: []string{"alice"}, Ports: []PortRange{{From: 80, To: 80}, {From: 443, To: 443}}},
+		{ID: "office-git", Action: ActionAllow, Clients: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, Domains: []string{"git-?.example.org"}, Ports: []PortRange{{From: 9418, To: 9420}}},
+		{ID: "public-web", Action: ActionAllow, Domains: []string{"example.com", "*.example.net"}},
+	}, "")
+	require.NoError(t, err)
+
+	alice := &credentials.Identity{UserID: "alice"}
+	bob := &credentials.Identity{UserID: "bob", Groups: []string{"dev", "ops"}}
+
+	for _, tt := range []struct {
+		name string
+		req  Request
+		rule string
+		want Action
+	}{
+		{
+			name: "command",
+			req:  Request{Command: types.CommandBIND, Identity: alice, Destination: types.Address{IP: net.ParseIP("198.51.100.1"), Port: 80}},
+			rule: "no-bind", want: ActionDeny,
+		},
+		{
+			name: "network",
+			req:  Request{Command: types.CommandConnect, Identity: alice, Destination: types.Address{DomainName: "metadata.internal", IP: net.ParseIP("169.254.169.254"), Port: 80}},
+			rule: "metadata", want: ActionDeny,
+		},
+		{
+			name: "group with domain suffix",
+			req:  Request{Command: types.CommandConnect, Identity: bob, Destination: types.Address{DomainName: "Wiki.Corp.Example.com.", Port: 8080}},
+			rule: "ops-internal", want: ActionAllow,
+		},
+		{
+			name: "group with network",
+			req:  Request{Command: types.CommandConnect, Identity: bob, Destination: types.Address{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 22}},
+			rule: "ops-internal", want: ActionAllow,
+		},
+		{
+			name: "suffix matches the domain itself",
+			req:  Request{Command: types.CommandConnect, Identity: bob, Destination: types.Address{DomainName: "corp.example.com", Port: 22}},
+			rule: "ops-internal", want: ActionAllow,
+		},
+		{
+			name: "user with ports",
+			req:  Request{Command: types.CommandConnect, Identity: alice, Destination: types.Address{IP: net.ParseIP("198.51.100.1"), Port: 443}},
+			rule: "alice-web", want: ActionAllow,
+		},
+		{
+			name: "user on another port",
+			req:  Request{Command: types.CommandConnect, Identity: alice, Destination: types.Address{IP: net.ParseIP("198.51.100.1"), Port: 22}},
+			rule: DefaultRuleID, want: ActionDeny,
+		},
+		{
+			name: "client network with glob and port range",
+			req:  Request{Command: types.CommandConnect, Client: netip.MustParseAddr("192.0.2.7"), Destination: types.Address{DomainName: "git-1.example.org", Port: 9419}},
+			rule: "office-git", want: ActionAllow,
+		},
+		{
+			name: "another client network",
+			req:  Request{Command: types.CommandConnect, Client: netip.MustParseAddr("198.51.100.7"), Destination: types.Address{DomainName: "git-1.example.org", Port: 9419}},
+			rule: DefaultRuleID, want: ActionDeny,
+		},
+		{
+			name: "exact domain is not a suffix",
+			req:  Request{Command: types.CommandConnect, Destination: types.Address{DomainName: "www.example.com", Port: 80}},
+			rule: DefaultRuleID, want: ActionDeny,
+		},
+		{
+			name: "anonymous client with glob",
+			req:  Request{Command: types.CommandConnect, Destination: types.Address{DomainName: "a.b.example.net", Port: 80}},
+			rule: "public-web", want: ActionAllow,
+		},
+		{
+			name: "anonymous client never matches users and groups",
+			req:  Request{Command: types.CommandConnect, Destination: types.Address{IP: net.ParseIP("10.1.2.3"), Port: 80}},
+			rule: DefaultRuleID, want: ActionDeny,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			d := a.Evaluate(tt.req)
+			assert.Equal(t, tt.rule, d.RuleID)
+			assert.Equal(t, tt.want, d.Action)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(nil, ActionAllow)
+	require.NoError(t, err)
+	assert.True(t, a.Evaluate(Request{}).Allowed())
+
+	for name, rules := range map[string][]Rule{
+		"missing ID":     {{Action: ActionAllow}},
+		"duplicate ID":   {{ID: "a", Action: ActionAllow}, {ID: "a", Action: ActionDeny}},
+		"reserved ID":    {{ID: DefaultRuleID, Action: ActionAllow}},
+		"unknown action": {{ID: "a", Action: "drop"}},
+		"bad pattern":    {{ID: "a", Action: ActionAllow, Domains: []string{"[a-"}}},
+		"empty domain":   {{ID: "a", Action: ActionAllow, Domains: []string{"."}}},
+		"bad port range": {{ID: "a", Action: ActionAllow, Ports: []PortRange{{From: 443, To: 80}}}},
+		"bad network":    {{ID: "a", Action: ActionAllow, Networks: []netip.Prefix{{}}}},
+		"empty schedule": {{ID: "a", Action: ActionAllow, Schedules: []Schedule{{Location: time.UTC}}}},
+		"bad time range": {{ID: "a", Action: ActionAllow, Schedules: []Schedule{{Hours: []TimeRange{{From: 17 * time.Hour, To: 9 * time.Hour}}}}}},
+		"bad date range": {{ID: "a", Action: ActionAllow, Schedules: []Schedule{{Dates: []DateRange{{From: time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}}}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New(rules, "")
+			require.ErrorIs(t, err, ErrInvalidRule)
+		})
+	}
+
+	_, err = New(nil, "drop")
+	require.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestACL_Explain(t *testing.T) {
+	a, err := New([]Rule{
+		{ID: "no-bind", Action: ActionDeny, Commands: []types.CommandID{types.CommandBIND}},
+		{ID: "ops", Action: ActionAllow, Groups: []string{"ops"}},
+		{ID: "internal", Action: ActionAllow, Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+		{ID: "web", Action: ActionAllow, Ports: []PortRange{{From: 80, To: 80}, {From: 8000, To: 8080}}},
+	}, "")
+	require.NoError(t, err)
+
+	d, reasons := a.Explain(Request{Command: types.CommandConnect, Destination: types.Address{DomainName: "example.com", Port: 443}})
+	assert.Equal(t, Decision{Action: ActionDeny, RuleID: DefaultRuleID}, d)
+	assert.Equal(t, []string{
+		`rule "no-bind" does not match, command CONNECT is not one of [BIND]`,
+		`rule "ops" does not match, the client is anonymous and the rule is for users or groups`,
+		`rule "internal" does not match, destination example.com is not in the networks [10.0.0.0/8] or domains [], its IP address being unknown`,
+		`rule "web" does not match, port 443 is not in [80 8000-8080]`,
+		`no rule matches, the default action is deny`,
+	}, reasons)
+
+	d, reasons = a.Explain(Request{Command: types.CommandConnect, Destination: types.Address{DomainName: "example.com", Port: 8080}})
+	assert.Equal(t, Decision{Action: ActionAllow, RuleID: "web"}, d)
+	assert.Equal(t, `rule "web" matches, the request is allowed`, reasons[len(reasons)-1])
+}
@@ -0,0 +1,281 @@
+package acl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scheduleHorizon is how far the schedules are looked ahead for the decisions to change.
+const scheduleHorizon = 366 * 24 * time.Hour
+
+// Schedule is a recurring window of time in a timezone, it contains the instants meeting every criterion it sets.
+type Schedule struct {
+	// Location is the timezone the schedule is in, such as the one of time.LoadLocation("Asia/Jakarta").
+	// It defaults to UTC.
+	Location *time.Location
+
+	// Weekdays are the days of the week, every day when empty.
+	Weekdays []time.Weekday
+
+	// Hours are the times of the day, the whole day when empty.
+	Hours []TimeRange
+
+	// Dates are the periods of the calendar, every date when empty.
+	Dates []DateRange
+}
+
+// TimeRange is a range of the wall clock as offsets since midnight, From included and To excluded.
+// A range can't span midnight, but it can end at it with To being 24 hours.
+type TimeRange struct {
+	From time.Duration
+	To   time.Duration
+}
+
+func (r TimeRange) String() string {
+	return fmt.Sprintf("%s-%s", formatClock(r.From), formatClock(r.To))
+}
+
+// DateRange is an inclusive range of dates, only the year, month and day of From and To are considered.
+type DateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+func (r DateRange) String() string {
+	if dateOf(r.From) == dateOf(r.To) {
+		return r.From.Format(time.DateOnly)
+	}
+
+	return fmt.Sprintf("%s..%s", r.From.Format(time.DateOnly), r.To.Format(time.DateOnly))
+}
+
+func (s Schedule) String() string {
+	var parts []string
+	if len(s.Weekdays) > 0 {
+		days := make([]string, 0, len(s.Weekdays))
+		for _, d := range s.Weekdays {
+			days = append(days, d.String()[:3])
+		}
+
+		parts = append(parts, strings.Join(days, ","))
+	}
+
+	if len(s.Hours) > 0 {
+		parts = append(parts, joinStrings(s.Hours))
+	}
+
+	if len(s.Dates) > 0 {
+		parts = append(parts, joinStrings(s.Dates))
+	}
+
+	return strings.Join(append(parts, s.location().String()), " ")
+}
+
+// Contains reports whether the instant is in the schedule, as seen from the wall clock of its timezone.
+func (s Schedule) Contains(t time.Time) bool {
+	t = t.In(s.location())
+	return s.matchesWeekday(t.Weekday()) && s.matchesHours(clockOf(t)) && s.matchesDate(t)
+}
+
+func (s Schedule) location() *time.Location {
+	if s.Location == nil {
+		return time.UTC
+	}
+
+	return s.Location
+}
+
+func (s Schedule) validate() error {
+	if len(s.Weekdays) == 0 && len(s.Hours) == 0 && len(s.Dates) == 0 {
+		return fmt.Errorf("empty schedule, expecting weekdays, hours or dates")
+	}
+
+	for _, d := range s.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", d)
+		}
+	}
+
+	for _, h := range s.Hours {
+		if h.From < 0 || h.To > 24*time.Hour || h.From >= h.To {
+			return fmt.Errorf("invalid time range %s", h)
+		}
+	}
+
+	for _, d := range s.Dates {
+		if dateOf(d.From) > dateOf(d.To) {
+			return fmt.Errorf("invalid date range %s", d)
+		}
+	}
+
+	return nil
+}
+
+func (s Schedule) matchesWeekday(day time.Weekday) bool {
+	if len(s.Weekdays) == 0 {
+		return true
+	}
+
+	for _, d := range s.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Schedule) matchesHours(clock time.Duration) bool {
+	if len(s.Hours) == 0 {
+		return true
+	}
+
+	for _, h := range s.Hours {
+		if clock >= h.From && clock < h.To {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s Schedule) matchesDate(t time.Time) bool {
+	if len(s.Dates) == 0 {
+		return true
+	}
+
+	date := dateOf(t)
+	for _, d := range s.Dates {
+		if date >= dateOf(d.From) && date <= dateOf(d.To) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// next returns the first instant after t the schedule may start or stop containing, skipping the days
+// the schedule can't change in. It is the zero time when the schedule never changes again.
+func (s Schedule) next(t time.Time) time.Time {
+	loc := s.location()
+	local := t.In(loc)
+	today := dateOf(local)
+
+	if !s.matchesDate(local) {
+		// Nothing changes until the next period of the calendar starts, if any
+		var next time.Time
+		for _, r := range s.Dates {
+			if dateOf(r.From) > today {
+				next = earliest(next, dayStart(r.From, 0, loc))
+			}
+		}
+
+		return next
+	}
+
+	if len(s.Dates) > 0 && len(s.Weekdays) == 0 && len(s.Hours) == 0 {
+		// Every day of the period is in, nothing changes until its end
+		var end time.Time
+		for _, r := range s.Dates {
+			if e := dayStart(r.To, 1, loc); today >= dateOf(r.From) && today <= dateOf(r.To) && e.After(end) {
+				end = e
+			}
+		}
+
+		return end
+	}
+
+	next := dayStart(local, 1, loc)
+	if !s.matchesWeekday(local.Weekday()) {
+		return next
+	}
+
+	y, m, d := local.Date()
+	for _, h := range s.Hours {
+		for _, offset := range []time.Duration{h.From, h.To} {
+			at := time.Date(y, m, d, 0, 0, 0, int(offset), loc)
+			if at.After(t) && at.Before(next) {
+				next = at
+			}
+		}
+	}
+
+	return next
+}
+
+// dayStart returns the midnight starting the date of t, shifted by the given number of days, in the timezone.
+func dayStart(t time.Time, days int, loc *time.Location) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, loc)
+}
+
+// scheduleChange returns the first instant after t the schedules start or stop containing,
+// it is the zero time when there are no schedules or they don't change within the horizon.
+func scheduleChange(schedules []Schedule, t time.Time) time.Time {
+	if len(schedules) == 0 {
+		return time.Time{}
+	}
+
+	in := containsTime(schedules, t)
+	for limit := t.Add(scheduleHorizon); t.Before(limit); {
+		var next time.Time
+		for _, s := range schedules {
+			next = earliest(next, s.next(t))
+		}
+
+		if next.IsZero() {
+			return time.Time{}
+		}
+
+		if t = next; containsTime(schedules, t) != in {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func containsTime(schedules []Schedule, t time.Time) bool {
+	for _, s := range schedules {
+		if s.Contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// earliest returns the earliest of the instants, the zero time standing for never.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// clockOf returns the wall clock of the instant, as an offset since midnight.
+func clockOf(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// dateOf returns the date of the instant as a comparable integer, such as 20260131.
+func dateOf(t time.Time) int {
+	y, m, d := t.Date()
+	return y*10000 + int(m)*100 + d
+}
+
+func joinStrings[T fmt.Stringer](values []T) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, v.String())
+	}
+
+	return strings.Join(s, ",")
+}
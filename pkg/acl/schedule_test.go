@@ -0,0 +1,141 @@
+package acl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule_Contains(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	businessHours := Schedule{
+		Location: jakarta,
+		Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Hours:    []TimeRange{{From: 9 * time.Hour, To: 12 * time.Hour}, {From: 13 * time.Hour, To: 17 * time.Hour}},
+		Dates:    []DateRange{{From: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC)}},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		schedule Schedule
+		at       time.Time
+		want     bool
+	}{
+		{name: "within hours", schedule: businessHours, at: time.Date(2026, 10, 16, 9, 0, 0, 0, jakarta), want: true},
+		{name: "within hours from another timezone", schedule: businessHours, at: time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC), want: true},
+		{name: "lunch break", schedule: businessHours, at: time.Date(2026, 10, 16, 12, 0, 0, 0, jakarta)},
+		{name: "end of the day", schedule: businessHours, at: time.Date(2026, 10, 16, 17, 0, 0, 0, jakarta)},
+		{name: "weekend", schedule: businessHours, at: time.Date(2026, 10, 17, 10, 0, 0, 0, jakarta)},
+		{name: "friday in UTC, saturday in the timezone", schedule: businessHours, at: time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)},
+		{name: "last date", schedule: businessHours, at: time.Date(2026, 12, 31, 16, 59, 0, 0, jakarta), want: true},
+		{name: "past the dates", schedule: businessHours, at: time.Date(2027, 1, 1, 10, 0, 0, 0, jakarta)},
+		{name: "until midnight", schedule: Schedule{Hours: []TimeRange{{From: 22 * time.Hour, To: 24 * time.Hour}}}, at: time.Date(2026, 10, 16, 23, 59, 59, 0, time.UTC), want: true},
+		{name: "wall clock after DST", schedule: Schedule{Location: newYork, Hours: []TimeRange{{From: 9 * time.Hour, To: 17 * time.Hour}}}, at: time.Date(2026, 3, 9, 13, 30, 0, 0, time.UTC), want: true},
+		{name: "wall clock before DST", schedule: Schedule{Location: newYork, Hours: []TimeRange{{From: 9 * time.Hour, To: 17 * time.Hour}}}, at: time.Date(2026, 3, 6, 13, 30, 0, 0, time.UTC)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.schedule.Contains(tt.at))
+		})
+	}
+}
+
+func TestACL_Evaluate_Schedules(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+
+	a, err := New([]Rule{
+		{ID: "night-freeze", Action: ActionDeny, Users: []string{"bob"}, Schedules: []Schedule{
+			{Location: jakarta, Hours: []TimeRange{{From: 22 * time.Hour, To: 24 * time.Hour}}},
+			{Location: jakarta, Hours: []TimeRange{{From: 0, To: 6 * time.Hour}}},
+		}},
+		{ID: "contractors", Action: ActionAllow, Users: []string{"bob"}, Schedules: []Schedule{
+			{Location: jakarta, Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, Hours: []TimeRange{{From: 9 * time.Hour, To: 17 * time.Hour}}},
+		}},
+		{ID: "staff", Action: ActionAllow, Users: []string{"alice"}},
+	}, "")
+	require.NoError(t, err)
+
+	bob := &credentials.Identity{UserID: "bob"}
+	alice := &credentials.Identity{UserID: "alice"}
+
+	for _, tt := range []struct {
+		name  string
+		req   Request
+		want  Decision
+		until time.Time
+	}{
+		{
+			name:  "within the window",
+			req:   Request{Identity: bob, Time: time.Date(2026, 10, 16, 11, 0, 0, 0, jakarta)},
+			want:  Decision{Action: ActionAllow, RuleID: "contractors"},
+			until: time.Date(2026, 10, 16, 17, 0, 0, 0, jakarta),
+		},
+		{
+			name:  "until a deny rule takes over",
+			req:   Request{Identity: bob, Time: time.Date(2026, 10, 16, 20, 0, 0, 0, jakarta)},
+			want:  Decision{Action: ActionDeny, RuleID: DefaultRuleID},
+			until: time.Date(2026, 10, 16, 22, 0, 0, 0, jakarta),
+		},
+		{
+			name:  "window across midnight",
+			req:   Request{Identity: bob, Time: time.Date(2026, 10, 16, 23, 0, 0, 0, jakarta)},
+			want:  Decision{Action: ActionDeny, RuleID: "night-freeze"},
+			until: time.Date(2026, 10, 17, 6, 0, 0, 0, jakarta),
+		},
+		{
+			name:  "until the next window",
+			req:   Request{Identity: bob, Time: time.Date(2026, 10, 17, 11, 0, 0, 0, jakarta)},
+			want:  Decision{Action: ActionDeny, RuleID: DefaultRuleID},
+			until: time.Date(2026, 10, 17, 22, 0, 0, 0, jakarta),
+		},
+		{
+			name: "without schedules",
+			req:  Request{Identity: alice, Time: time.Date(2026, 10, 16, 23, 0, 0, 0, jakarta)},
+			want: Decision{Action: ActionAllow, RuleID: "staff"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, a.Evaluate(tt.req))
+
+			until := a.Until(tt.req)
+			assert.True(t, tt.until.Equal(until), "until %s, got %s", tt.until, until)
+		})
+	}
+
+	d, reasons := a.Explain(Request{Command: types.CommandConnect, Identity: bob, Time: time.Date(2026, 10, 17, 11, 0, 0, 0, jakarta)})
+	assert.Equal(t, DefaultRuleID, d.RuleID)
+	assert.Equal(t, []string{
+		`rule "night-freeze" does not match, time 2026-10-17T11:00:00+07:00 is outside the schedules [22:00-24:00 WIB 00:00-06:00 WIB]`,
+		`rule "contractors" does not match, time 2026-10-17T11:00:00+07:00 is outside the schedules [Mon,Tue,Wed,Thu,Fri 09:00-17:00 WIB]`,
+		`rule "staff" does not match, user "bob" of groups [] is not in the users [alice] or groups []`,
+		`no rule matches, the default action is deny`,
+		`the decision holds until 2026-10-17T22:00:00+07:00, when the schedules may change it`,
+	}, reasons)
+}
+
+func TestACL_Until_Dates(t *testing.T) {
+	jakarta := time.FixedZone("WIB", 7*60*60)
+
+	a, err := New([]Rule{
+		{ID: "campaign", Action: ActionAllow, Schedules: []Schedule{
+			{Location: jakarta, Dates: []DateRange{{From: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2027, 3, 31, 0, 0, 0, 0, time.UTC)}}},
+		}},
+	}, "")
+	require.NoError(t, err)
+
+	// The days the schedule can't change in are skipped, rather than walked one by one
+	for at, want := range map[time.Time]time.Time{
+		time.Date(2026, 10, 17, 11, 0, 0, 0, jakarta): time.Date(2027, 1, 1, 0, 0, 0, 0, jakarta),
+		time.Date(2027, 2, 1, 11, 0, 0, 0, jakarta):   time.Date(2027, 4, 1, 0, 0, 0, 0, jakarta),
+		time.Date(2027, 5, 1, 11, 0, 0, 0, jakarta):   {},
+	} {
+		until := a.Until(Request{Time: at})
+		assert.True(t, want.Equal(until), "at %s, until %s, got %s", at, want, until)
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strings"
 
 	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
 	"github.com/ardikabs/socks5/pkg/types"
 )
 
@@ -30,13 +32,14 @@ func (a *guestAuthenticator) Authenticate(_ context.Context, req io.Reader, rep
 	return &AuthContext{Method: types.AuthNoAuthRequired}, nil
 }
 
-const userPassAuthVersion = uint8(1)
+const userPassAuthVersion = types.UserPassVersion
 
 type userPassAuthenticator struct {
-	cs credentials.Storer
+	cs      credentials.Storer
+	lockout *lockout.Guard
 }
 
-func (a *userPassAuthenticator) Authenticate(_ context.Context, req io.Reader, rep io.Writer) (*AuthContext, error) {
+func (a *userPassAuthenticator) Authenticate(ctx context.Context, req io.Reader, rep io.Writer) (*AuthContext, error) {
 	// Reference: https://datatracker.ietf.org/doc/html/rfc1929
 	// USERNAME/PASSWORD Initial Negotiation
 	// +----+------+----------+------+----------+
@@ -89,18 +92,21 @@ func (a *userPassAuthenticator) Authenticate(_ context.Context, req io.Reader, r
 	// 		Success (0x00)
 	// 		Failure (0x01)
 
-	if err := a.cs.Validate(credentials.Parameters{
+	params := credentials.Parameters{
 		Username: string(uname),
 		Password: string(passwd),
-	}); err != nil {
-		if errors.Is(err, credentials.ErrInvalidCredentials) {
+	}
+
+	identity, err := ValidateCredentials(ctx, a.lockout, a.cs, remoteAddr(req), params)
+	if err != nil {
+		if errors.Is(err, credentials.ErrInvalidCredentials) || errors.Is(err, lockout.ErrLockedOut) {
 			// Send failure reply to indicate that the credentials are invalid
 			if _, err := rep.Write([]byte{userPassAuthVersion, 0x01}); err != nil {
 				return nil, fmt.Errorf("failed to send auth reply: %v", err)
 			}
 		}
 
-		return nil, fmt.Errorf("failed to validate credentials: %v", err)
+		return nil, fmt.Errorf("failed to validate credentials: %w", err)
 	}
 
 	// Send success reply to indicate that the credentials are valid
@@ -111,7 +117,54 @@ func (a *userPassAuthenticator) Authenticate(_ context.Context, req io.Reader, r
 	return &AuthContext{
 		Method: types.AuthUserPass,
 		Payload: AuthPayload{
-			"username": string(uname),
+			"username": params.Username,
 		},
+		Identity: identity,
 	}, nil
 }
+
+// ValidateCredentials identifies the user with the store, see credentials.Identify.
+// When a guard is given, locked out clients are rejected before the store is called and the outcome is recorded.
+func ValidateCredentials(ctx context.Context, g *lockout.Guard, cs credentials.Storer, remoteAddr net.Addr, p credentials.Parameters) (*credentials.Identity, error) {
+	if g == nil {
+		return credentials.Identify(ctx, cs, remoteAddr, p)
+	}
+
+	ip := hostOf(remoteAddr)
+	if err := g.Allow(ctx, ip, p.Username); err != nil {
+		return nil, err
+	}
+
+	identity, err := credentials.Identify(ctx, cs, remoteAddr, p)
+	switch {
+	case err == nil:
+		g.Success(ip, p.Username)
+	case errors.Is(err, credentials.ErrInvalidCredentials):
+		// Only denials count as failures, an unavailable store is not the client's fault
+		g.Failure(ip, p.Username)
+	}
+
+	return identity, err
+}
+
+func hostOf(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
+
+// remoteAddr returns the address of the client when the reader is its connection.
+func remoteAddr(r io.Reader) net.Addr {
+	if conn, ok := r.(interface{ RemoteAddr() net.Addr }); ok {
+		return conn.RemoteAddr()
+	}
+
+	return nil
+}
@@ -3,9 +3,11 @@ package auth
 import (
 	"bytes"
 	"context"
+	"net"
 	"testing"
 
 	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
 	"github.com/ardikabs/socks5/pkg/types"
 	"github.com/stretchr/testify/require"
 )
@@ -85,3 +87,77 @@ func TestUserPassAuthenticator(t *testing.T) {
 		require.Equal(t, []byte{userPassAuthVersion, 0x01}, rep.Bytes())
 	})
 }
+
+type identityStore struct {
+	credentials.MemoryStore
+	groups []string
+}
+
+func (s identityStore) Identify(_ context.Context, _ net.Addr, p credentials.Parameters) (*credentials.Identity, error) {
+	if err := s.Validate(p); err != nil {
+		return nil, err
+	}
+
+	return &credentials.Identity{UserID: "id-" + p.Username, Groups: s.groups}, nil
+}
+
+func TestUserPassAuthenticator_Identity(t *testing.T) {
+	auth := &userPassAuthenticator{
+		cs: identityStore{
+			MemoryStore: credentials.MemoryStore{"test": "test"},
+			groups:      []string{"ops"},
+		},
+	}
+
+	req := bytes.NewBuffer(nil)
+	req.Write([]byte{userPassAuthVersion, 4})
+	req.Write([]byte("test"))
+	req.Write([]byte{4})
+	req.Write([]byte("test"))
+
+	authCtx, err := auth.Authenticate(context.TODO(), req, bytes.NewBuffer(nil))
+	require.NoError(t, err)
+	require.Equal(t, "test", authCtx.Payload["username"])
+	require.Equal(t, &credentials.Identity{UserID: "id-test", Groups: []string{"ops"}}, authCtx.Identity)
+}
+
+type countingStore struct {
+	credentials.MemoryStore
+	calls int
+}
+
+func (s *countingStore) Identify(ctx context.Context, remoteAddr net.Addr, p credentials.Parameters) (*credentials.Identity, error) {
+	s.calls++
+	return s.MemoryStore.Identify(ctx, remoteAddr, p)
+}
+
+func TestUserPassAuthenticator_Lockout(t *testing.T) {
+	cs := &countingStore{MemoryStore: credentials.MemoryStore{"test": "test"}}
+	auth := &userPassAuthenticator{
+		cs:      cs,
+		lockout: lockout.New(lockout.Config{MaxFailures: 2}),
+	}
+
+	attempt := func(passwd string) (*bytes.Buffer, error) {
+		req := bytes.NewBuffer(nil)
+		req.Write([]byte{userPassAuthVersion, 4})
+		req.Write([]byte("test"))
+		req.Write([]byte{byte(len(passwd))})
+		req.Write([]byte(passwd))
+
+		rep := bytes.NewBuffer(nil)
+		_, err := auth.Authenticate(context.TODO(), req, rep)
+		return rep, err
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := attempt("badpassword")
+		require.ErrorIs(t, err, credentials.ErrInvalidCredentials)
+	}
+
+	// Locked out, even with the right password, without calling the store
+	rep, err := attempt("test")
+	require.ErrorIs(t, err, lockout.ErrLockedOut)
+	require.Equal(t, []byte{userPassAuthVersion, 0x01}, rep.Bytes())
+	require.Equal(t, 2, cs.calls)
+}
@@ -0,0 +1,93 @@
+package credentials
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAccountDisabled = fmt.Errorf("%w, account is disabled", ErrInvalidCredentials)
+	ErrAccountExpired  = fmt.Errorf("%w, account has expired", ErrInvalidCredentials)
+	ErrAccountNotFound = fmt.Errorf("%w, account does not exist", ErrInvalidCredentials)
+)
+
+// Account is the lifecycle of a user account.
+type Account struct {
+	// ExpiresAt is when the account stops working, the zero value never expires.
+	ExpiresAt time.Time
+
+	// Disabled refuses the account regardless of its credentials.
+	Disabled bool
+
+	// MaxSessions caps the concurrent sessions of the account, zero is unlimited.
+	MaxSessions int
+}
+
+// AccountStorer is implemented by stores that keep the lifecycle of their accounts,
+// so the accounts of live sessions can be checked again once the store changes.
+type AccountStorer interface {
+	Account(userID string) (Account, bool)
+}
+
+// Check returns an error when the account is disabled or expired at the given time.
+func (a Account) Check(now time.Time) error {
+	if a.Disabled {
+		return ErrAccountDisabled
+	}
+
+	if !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt) {
+		return ErrAccountExpired
+	}
+
+	return nil
+}
+
+// parseAccount parses the comma-separated account options, such as 'expires=2025-12-31,max_sessions=2,disabled'.
+// The 'disabled' option is either bare or set to a boolean, such as 'disabled=false'.
+// The expiry is either an RFC 3339 timestamp or a date, the latter expiring at the start of the day in UTC.
+func parseAccount(s string) (Account, error) {
+	var a Account
+
+	for _, opt := range strings.Split(s, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(opt), "=")
+
+		switch key {
+		case "":
+			continue
+		case "disabled":
+			if !hasValue {
+				a.Disabled = true
+				continue
+			}
+
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return Account{}, fmt.Errorf("invalid disabled %q, expecting a boolean", value)
+			}
+
+			a.Disabled = disabled
+		case "expires":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				if t, err = time.Parse(time.DateOnly, value); err != nil {
+					return Account{}, fmt.Errorf("invalid expiry %q, expecting an RFC 3339 timestamp or a YYYY-MM-DD date", value)
+				}
+			}
+
+			a.ExpiresAt = t
+		case "max_sessions":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return Account{}, fmt.Errorf("invalid max_sessions %q", value)
+			}
+
+			a.MaxSessions = n
+		default:
+			return Account{}, fmt.Errorf("unknown account option %q", key)
+		}
+	}
+
+	return a, nil
+}
@@ -1,6 +1,11 @@
 package credentials
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
 
 var (
 	ErrInvalidCredentials = fmt.Errorf("invalid credentials")
@@ -10,6 +15,55 @@ type Storer interface {
 	Validate(Parameters) error
 }
 
+// StorerV2 is implemented by stores that identify the user along with the validation.
+// The remote address is the address of the client being authenticated, it is nil when unknown.
+type StorerV2 interface {
+	Identify(ctx context.Context, remoteAddr net.Addr, p Parameters) (*Identity, error)
+}
+
+// Identity is the identity of an authenticated user.
+type Identity struct {
+	// UserID identifies the user, it is the username unless the store knows better.
+	UserID string
+
+	// Groups are the groups the user is a member of.
+	Groups []string
+
+	// Attributes are the store-specific attributes of the user.
+	Attributes map[string]interface{}
+
+	// ExpiresAt is when the identity stops being valid, the zero value never expires.
+	ExpiresAt time.Time
+
+	// MaxSessions caps the concurrent sessions of the user, zero is unlimited.
+	MaxSessions int
+}
+
+// Identify validates the credentials with the store, through StorerV2 when the store implements it.
+// The user of a store only implementing Storer is identified by its username.
+func Identify(ctx context.Context, s Storer, remoteAddr net.Addr, p Parameters) (*Identity, error) {
+	if v2, ok := s.(StorerV2); ok {
+		return v2.Identify(ctx, remoteAddr, p)
+	}
+
+	if err := s.Validate(p); err != nil {
+		return nil, err
+	}
+
+	return &Identity{UserID: p.Username}, nil
+}
+
+// Reloader is implemented by stores that are able to reload their credentials at runtime, such as on SIGHUP.
+type Reloader interface {
+	Reload() error
+}
+
+// Watcher is implemented by stores that reload their credentials when their source changes,
+// the outcome of every reload is reported to onReload.
+type Watcher interface {
+	Watch(ctx context.Context, onReload func(error))
+}
+
 type Parameters struct {
 
 	// Parameters used for USERNAME/PASSWORD authentication.
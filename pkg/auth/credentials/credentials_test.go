@@ -0,0 +1,31 @@
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// storerV1 only implements the Storer interface, the Identify method of MemoryStore is shadowed.
+type storerV1 struct {
+	MemoryStore
+}
+
+func (s storerV1) Identify() {}
+
+func TestIdentify(t *testing.T) {
+	for name, store := range map[string]Storer{
+		"Storer":   storerV1{MemoryStore{"alice": "secret"}},
+		"StorerV2": MemoryStore{"alice": "secret"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			identity, err := Identify(context.Background(), store, nil, Parameters{Username: "alice", Password: "secret"})
+			require.NoError(t, err)
+			require.Equal(t, &Identity{UserID: "alice"}, identity)
+
+			_, err = Identify(context.Background(), store, nil, Parameters{Username: "alice", Password: "wrong"})
+			require.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package credentials
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// cryptAlphabet is the base64 alphabet used by crypt(3).
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptMaxSalt       = 16
+)
+
+// shaCrypt256Order and shaCrypt512Order are the byte triplets of the digest, in the order they are encoded.
+var (
+	shaCrypt256Order = [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+
+	shaCrypt512Order = [][3]int{
+		{0, 21, 42}, {22, 43, 1}, {44, 2, 23}, {3, 24, 45}, {25, 46, 4},
+		{47, 5, 26}, {6, 27, 48}, {28, 49, 7}, {50, 8, 29}, {9, 30, 51},
+		{31, 52, 10}, {53, 11, 32}, {12, 33, 54}, {34, 55, 13}, {56, 14, 35},
+		{15, 36, 57}, {37, 58, 16}, {59, 17, 38}, {18, 39, 60}, {40, 61, 19},
+		{62, 20, 41},
+	}
+)
+
+// shaCrypt computes the SHA-crypt hash of the password, variant is either "5" (SHA-256) or "6" (SHA-512).
+//
+// Reference: https://www.akkadia.org/drepper/SHA-crypt.txt
+func shaCrypt(variant, password, salt string, rounds int, customRounds bool) string {
+	newHash, order := sha512.New, shaCrypt512Order
+	if variant == "5" {
+		newHash, order = sha256.New, shaCrypt256Order
+	}
+
+	rounds = max(shaCryptMinRounds, min(rounds, shaCryptMaxRounds))
+	if len(salt) > shaCryptMaxSalt {
+		salt = salt[:shaCryptMaxSalt]
+	}
+
+	pw, sl := []byte(password), []byte(salt)
+
+	// Digest B
+	b := digest(newHash, pw, sl, pw)
+
+	// Digest A
+	h := newHash()
+	h.Write(pw)
+	h.Write(sl)
+	h.Write(repeat(b, len(pw)))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(pw)
+		}
+	}
+	a := h.Sum(nil)
+
+	// Byte sequence P, from digest DP
+	h = newHash()
+	for range pw {
+		h.Write(pw)
+	}
+	p := repeat(h.Sum(nil), len(pw))
+
+	// Byte sequence S, from digest DS
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(sl)
+	}
+	s := repeat(h.Sum(nil), len(sl))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+
+		if i%3 != 0 {
+			h.Write(s)
+		}
+
+		if i%7 != 0 {
+			h.Write(p)
+		}
+
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$" + variant + "$")
+	if customRounds {
+		out.WriteString("rounds=" + strconv.Itoa(rounds) + "$")
+	}
+	out.WriteString(salt + "$")
+
+	for _, o := range order {
+		encode24(&out, c[o[0]], c[o[1]], c[o[2]], 4)
+	}
+
+	if variant == "5" {
+		encode24(&out, 0, c[31], c[30], 3)
+	} else {
+		encode24(&out, 0, 0, c[63], 2)
+	}
+
+	return out.String()
+}
+
+// apr1Crypt computes the Apache variant of the MD5-crypt hash, used by htpasswd.
+func apr1Crypt(password, salt string) string {
+	const magic = "$apr1$"
+
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	pw, sl := []byte(password), []byte(salt)
+
+	final := digest(md5.New, pw, sl, pw)
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic))
+	h.Write(sl)
+	h.Write(repeat(final, len(pw)))
+	for n := len(pw); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final = h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+
+		if i%3 != 0 {
+			h.Write(sl)
+		}
+
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+
+		final = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	encode24(&out, final[0], final[6], final[12], 4)
+	encode24(&out, final[1], final[7], final[13], 4)
+	encode24(&out, final[2], final[8], final[14], 4)
+	encode24(&out, final[3], final[9], final[15], 4)
+	encode24(&out, final[4], final[10], final[5], 4)
+	encode24(&out, 0, 0, final[11], 2)
+
+	return out.String()
+}
+
+func digest(newHash func() hash.Hash, parts ...[]byte) []byte {
+	h := newHash()
+	for _, p := range parts {
+		h.Write(p)
+	}
+
+	return h.Sum(nil)
+}
+
+// repeat returns b repeated up to n bytes.
+func repeat(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+
+	return out
+}
+
+func encode24(out *strings.Builder, b2, b1, b0 byte, n int) {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		out.WriteByte(cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
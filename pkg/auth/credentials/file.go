@@ -2,16 +2,89 @@ package credentials
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/tool/filewatch"
 )
 
+// FileStore validates credentials from a file of 'username:hash' lines, such as an Apache htpasswd file.
+// Blank lines and lines starting with '#' are ignored.
+//
+// A line optionally ends with the account options, as 'username:hash:options', see parseAccount.
+// Account options are not available with WithPlaintext, where the password is the rest of the line.
+//
+// The credentials are swapped atomically on Reload, and kept as they are when the new file fails validation.
 type FileStore struct {
-	mem MemoryStore
+	filename string
+	opts     *fileOptions
+
+	entries atomic.Pointer[map[string]userEntry]
+	now     func() time.Time
+}
+
+type FileOption func(*fileOptions)
+
+type fileOptions struct {
+	plaintext    bool
+	pollInterval time.Duration
+}
+
+// WithPlaintext reads the file as 'username:password' lines with the password in clear text,
+// the password is everything after the first colon.
+func WithPlaintext() FileOption {
+	return func(o *fileOptions) {
+		o.plaintext = true
+	}
+}
+
+// WithPollInterval sets how often Watch polls the file, on top of inotify.
+func WithPollInterval(interval time.Duration) FileOption {
+	return func(o *fileOptions) {
+		o.pollInterval = interval
+	}
+}
+
+func NewFileStore(filename string, opts ...FileOption) (*FileStore, error) {
+	o := new(fileOptions)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	f := &FileStore{filename: filename, opts: o, now: time.Now}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reload reads the file again, the current credentials are kept if the file fails validation.
+func (f *FileStore) Reload() error {
+	entries, err := readFile(f.filename, f.opts)
+	if err != nil {
+		return err
+	}
+
+	f.entries.Store(&entries)
+	return nil
 }
 
-func NewFileStore(filename string) (*FileStore, error) {
+// Watch reloads the credentials every time the file changes, until the context is done.
+// The outcome of every reload is reported to onReload.
+func (f *FileStore) Watch(ctx context.Context, onReload func(error)) {
+	filewatch.Watch(ctx, f.filename, f.opts.pollInterval, func() {
+		onReload(f.Reload())
+	})
+}
+
+func readFile(filename string, o *fileOptions) (map[string]userEntry, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -20,24 +93,96 @@ func NewFileStore(filename string) (*FileStore, error) {
 
 	scanner := bufio.NewScanner(file)
 
-	stash := make(map[string]string)
-	for scanner.Scan() {
-		parts := strings.Split(scanner.Text(), ":")
+	var errs []error
+	entries := make(map[string]userEntry)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, entry, err := parseLine(line, o)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %v", filename, lineNo, err))
+			continue
+		}
 
-		if len(parts) != 2 {
+		if _, exists := entries[username]; exists {
+			errs = append(errs, fmt.Errorf("%s:%d: duplicate username %q", filename, lineNo, username))
 			continue
 		}
 
-		stash[parts[0]] = parts[1]
+		entries[username] = entry
 	}
 
-	if len(stash) == 0 {
-		return nil, fmt.Errorf("no credentials found or credential has invalid format (valid format 'username:password') in %s", filename)
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no credentials found in %s", filename)
+	}
+
+	return entries, nil
+}
+
+func parseLine(line string, o *fileOptions) (string, userEntry, error) {
+	username, secret, found := strings.Cut(line, ":")
+	if !found {
+		return "", userEntry{}, errors.New("invalid format, expecting 'username:hash'")
+	}
+
+	if username == "" {
+		return "", userEntry{}, errors.New("empty username")
+	}
+
+	if o.plaintext {
+		return username, userEntry{hash: plainPassword(secret)}, nil
+	}
+
+	secret, options, _ := strings.Cut(secret, ":")
+
+	h, err := parseHash(secret)
+	if err != nil {
+		return "", userEntry{}, fmt.Errorf("user %q: %v", username, err)
+	}
+
+	account, err := parseAccount(options)
+	if err != nil {
+		return "", userEntry{}, fmt.Errorf("user %q: %v", username, err)
 	}
 
-	return &FileStore{stash}, nil
+	return username, userEntry{hash: h, account: account}, nil
 }
 
 func (f *FileStore) Validate(p Parameters) error {
-	return f.mem.Validate(p)
+	_, err := f.validate(p)
+	return err
+}
+
+func (f *FileStore) validate(p Parameters) (userEntry, error) {
+	return validateUser(*f.entries.Load(), p, f.now())
+}
+
+func (f *FileStore) Identify(_ context.Context, _ net.Addr, p Parameters) (*Identity, error) {
+	entry, err := f.validate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		UserID:      p.Username,
+		ExpiresAt:   entry.account.ExpiresAt,
+		MaxSessions: entry.account.MaxSessions,
+	}, nil
+}
+
+// Account returns the account of the user as currently loaded.
+func (f *FileStore) Account(userID string) (Account, bool) {
+	entry, ok := (*f.entries.Load())[userID]
+	return entry.account, ok
 }
@@ -0,0 +1,212 @@
+package credentials
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeFile(t *testing.T, lines ...string) string {
+	filename := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0o600))
+	return filename
+}
+
+func TestParseHash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("Hello world!"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	salt := []byte("saltsaltsaltsalt")
+	argon2Hash := fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s",
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("Hello world!"), salt, 1, 1024, 1, 32)),
+	)
+
+	tests := map[string]string{
+		"bcrypt":                string(bcryptHash),
+		"bcrypt htpasswd":       "$2y" + string(bcryptHash[3:]),
+		"argon2id":              argon2Hash,
+		"SHA-256 crypt":         "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"SHA-256 crypt rounds":  "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA",
+		"SHA-512 crypt":         "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1",
+		"SHA-512 crypt rounds":  "$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.",
+		"APR1-MD5 htpasswd":     "$apr1$rKm7Qz.1$2N4Sji1s1Gk/S8v7xVC0b0",
+		"SHA1 htpasswd":         "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		"APR1-MD5 empty passwd": "$apr1$ab$S8K6Sgp3W8c9Jb6LxgywZ.",
+	}
+
+	passwords := map[string]string{
+		"APR1-MD5 htpasswd":     "secret",
+		"SHA1 htpasswd":         "secret",
+		"APR1-MD5 empty passwd": "",
+	}
+
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			password, ok := passwords[name]
+			if !ok {
+				password = "Hello world!"
+			}
+
+			h, err := parseHash(encoded)
+			require.NoError(t, err)
+			require.True(t, h.Verify(password))
+			require.False(t, h.Verify(password+"x"))
+		})
+	}
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := parseHash("password")
+		require.ErrorIs(t, err, ErrUnsupportedHash)
+	})
+
+	t.Run("argon2id out of bounds", func(t *testing.T) {
+		encodedSalt := base64.RawStdEncoding.EncodeToString(salt)
+		encodedKey := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+
+		for _, params := range []string{"m=1024,t=0,p=1", "m=1024,t=17,p=1", "m=1024,t=1,p=0", "m=4194304,t=1,p=1"} {
+			_, err := parseHash(fmt.Sprintf("$argon2id$v=19$%s$%s$%s", params, encodedSalt, encodedKey))
+			require.Error(t, err, params)
+		}
+
+		_, err := parseHash(fmt.Sprintf("$argon2id$v=19$m=1024,t=1,p=1$%s$%s", encodedSalt, base64.RawStdEncoding.EncodeToString(make([]byte, 4096))))
+		require.Error(t, err)
+	})
+}
+
+func TestNewFileStore(t *testing.T) {
+	t.Run("hashed", func(t *testing.T) {
+		filename := writeFile(t,
+			"# comment",
+			"",
+			"alice:$apr1$rKm7Qz.1$2N4Sji1s1Gk/S8v7xVC0b0",
+			"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+		)
+
+		fs, err := NewFileStore(filename)
+		require.NoError(t, err)
+		require.NoError(t, fs.Validate(Parameters{Username: "alice", Password: "secret"}))
+		require.NoError(t, fs.Validate(Parameters{Username: "bob", Password: "secret"}))
+		require.ErrorIs(t, fs.Validate(Parameters{Username: "alice", Password: "bad"}), ErrInvalidCredentials)
+		require.ErrorIs(t, fs.Validate(Parameters{Username: "carol", Password: "secret"}), ErrInvalidCredentials)
+	})
+
+	t.Run("plaintext is rejected unless opted in", func(t *testing.T) {
+		filename := writeFile(t,
+			"alice:$apr1$rKm7Qz.1$2N4Sji1s1Gk/S8v7xVC0b0",
+			"bob:password",
+			"invalid line",
+		)
+
+		_, err := NewFileStore(filename)
+		require.ErrorContains(t, err, filename+":2: user \"bob\": "+ErrUnsupportedHash.Error())
+		require.ErrorContains(t, err, filename+":3: invalid format")
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		filename := writeFile(t, "alice:pass:word")
+
+		fs, err := NewFileStore(filename, WithPlaintext())
+		require.NoError(t, err)
+		require.NoError(t, fs.Validate(Parameters{Username: "alice", Password: "pass:word"}))
+	})
+}
+
+func TestFileStore_Accounts(t *testing.T) {
+	const hash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+	filename := writeFile(t,
+		"alice:"+hash+":max_sessions=2,expires=2030-01-01T12:00:00Z",
+		"bob:"+hash+":disabled",
+		"carol:"+hash+":expires=2024-06-01",
+		"dave:"+hash+":",
+		"erin:"+hash+":disabled=false",
+		"frank:"+hash+":disabled=true",
+	)
+
+	fs, err := NewFileStore(filename)
+	require.NoError(t, err)
+	fs.now = func() time.Time { return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	identity, err := fs.Identify(context.Background(), nil, Parameters{Username: "alice", Password: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, &Identity{
+		UserID:      "alice",
+		ExpiresAt:   time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC),
+		MaxSessions: 2,
+	}, identity)
+
+	require.ErrorIs(t, fs.Validate(Parameters{Username: "bob", Password: "secret"}), ErrAccountDisabled)
+	require.ErrorIs(t, fs.Validate(Parameters{Username: "carol", Password: "secret"}), ErrAccountExpired)
+	require.ErrorIs(t, fs.Validate(Parameters{Username: "carol", Password: "secret"}), ErrInvalidCredentials)
+	require.NoError(t, fs.Validate(Parameters{Username: "dave", Password: "secret"}))
+	require.NoError(t, fs.Validate(Parameters{Username: "erin", Password: "secret"}))
+	require.ErrorIs(t, fs.Validate(Parameters{Username: "frank", Password: "secret"}), ErrAccountDisabled)
+
+	account, ok := fs.Account("bob")
+	require.True(t, ok)
+	require.True(t, account.Disabled)
+
+	_, ok = fs.Account("grace")
+	require.False(t, ok)
+
+	t.Run("invalid options", func(t *testing.T) {
+		filename := writeFile(t,
+			"alice:"+hash+":max_sessions=-1",
+			"bob:"+hash+":expires=tomorrow",
+			"carol:"+hash+":admin",
+			"dave:"+hash+":disabled=",
+		)
+
+		_, err := NewFileStore(filename)
+		require.ErrorContains(t, err, filename+":1: user \"alice\": invalid max_sessions")
+		require.ErrorContains(t, err, filename+":2: user \"bob\": invalid expiry")
+		require.ErrorContains(t, err, filename+":3: user \"carol\": unknown account option \"admin\"")
+		require.ErrorContains(t, err, filename+":4: user \"dave\": invalid disabled \"\"")
+	})
+}
+
+func TestFileStore_Reload(t *testing.T) {
+	filename := writeFile(t, "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=")
+
+	fs, err := NewFileStore(filename, WithPollInterval(10*time.Millisecond))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan error, 10)
+	go fs.Watch(ctx, func(err error) { reloaded <- err })
+
+	// Give the watcher some time to start
+	time.Sleep(20 * time.Millisecond)
+
+	t.Run("new credentials are swapped in", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0o600))
+
+		select {
+		case err := <-reloaded:
+			require.NoError(t, err)
+		case <-time.After(time.Second):
+			t.Fatal("file change was not detected")
+		}
+
+		require.ErrorIs(t, fs.Validate(Parameters{Username: "alice", Password: "secret"}), ErrInvalidCredentials)
+		require.NoError(t, fs.Validate(Parameters{Username: "bob", Password: "secret"}))
+	})
+
+	t.Run("previous credentials are kept when the file is invalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("bob:password\n"), 0o600))
+		require.Error(t, fs.Reload())
+		require.NoError(t, fs.Validate(Parameters{Username: "bob", Password: "secret"}))
+	})
+}
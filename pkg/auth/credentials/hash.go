@@ -0,0 +1,185 @@
+package credentials
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrUnsupportedHash = fmt.Errorf("unsupported password hash format")
+)
+
+// passwordHash verifies a password against a stored hash, in constant time.
+type passwordHash interface {
+	Verify(password string) bool
+}
+
+// parseHash recognizes the hash format by its prefix, the formats accepted are the ones
+// produced by Apache htpasswd (bcrypt, APR1-MD5, SHA1), plus argon2id and SHA-crypt.
+func parseHash(s string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(s, "$2a$"), strings.HasPrefix(s, "$2b$"), strings.HasPrefix(s, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(s)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %v", err)
+		}
+
+		return bcryptHash(s), nil
+	case strings.HasPrefix(s, "$argon2id$"):
+		return parseArgon2idHash(s)
+	case strings.HasPrefix(s, "$5$"), strings.HasPrefix(s, "$6$"):
+		return parseSHACryptHash(s)
+	case strings.HasPrefix(s, "$apr1$"):
+		return parseAPR1Hash(s)
+	case strings.HasPrefix(s, "{SHA}"):
+		sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, "{SHA}"))
+		if err != nil || len(sum) != sha1.Size {
+			return nil, fmt.Errorf("invalid SHA1 hash")
+		}
+
+		return sha1Hash(sum), nil
+	default:
+		return nil, ErrUnsupportedHash
+	}
+}
+
+// dummyHash is verified for the unknown usernames, so they take as long to refuse as a wrong password
+// and the response time doesn't tell which usernames exist.
+var dummyHash = sync.OnceValue(func() passwordHash {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+	return bcryptHash(h)
+})
+
+type plainPassword string
+
+func (h plainPassword) Verify(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(h), []byte(password)) == 1
+}
+
+type bcryptHash string
+
+func (h bcryptHash) Verify(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil
+}
+
+type sha1Hash []byte
+
+func (h sha1Hash) Verify(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(h, sum[:]) == 1
+}
+
+// The argon2id parameters are capped so a hash can't exhaust the memory or the CPU of the server at login.
+const (
+	maxArgon2idMemory = 256 << 10 // KiB
+	maxArgon2idTime   = 16        // passes
+	maxArgon2idKeyLen = 1024
+)
+
+// argon2idHash follows the PHC string format, $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2idHash(s string) (passwordHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return nil, fmt.Errorf("invalid argon2id hash: expected 5 fields, got %d", len(parts)-1)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("invalid argon2id hash: unsupported version %q", parts[2])
+	}
+
+	h := argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: malformed parameters %q", parts[3])
+	}
+
+	if h.time < 1 || h.time > maxArgon2idTime || h.threads < 1 || h.memory > maxArgon2idMemory {
+		return nil, fmt.Errorf("invalid argon2id hash: parameters %q out of bounds, expecting t from 1 to %d, p of at least 1 and m up to %d", parts[3], maxArgon2idTime, maxArgon2idMemory)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash: malformed salt: %v", err)
+	}
+
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 || len(h.key) > maxArgon2idKeyLen {
+		return nil, fmt.Errorf("invalid argon2id hash: malformed key")
+	}
+
+	return h, nil
+}
+
+func (h argon2idHash) Verify(password string) bool {
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(h.key, key) == 1
+}
+
+// cryptHash is a hash in the crypt(3) format, verified by recomputing it with the same salt.
+type cryptHash struct {
+	encoded string
+	compute func(password string) string
+}
+
+func (h cryptHash) Verify(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(h.encoded), []byte(h.compute(password))) == 1
+}
+
+func parseSHACryptHash(s string) (passwordHash, error) {
+	// $5$[rounds=N$]salt$hash or $6$[rounds=N$]salt$hash
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 && len(parts) != 5 {
+		return nil, fmt.Errorf("invalid SHA-crypt hash")
+	}
+
+	rounds, customRounds := shaCryptDefaultRounds, false
+	if len(parts) == 5 {
+		n, err := strconv.Atoi(strings.TrimPrefix(parts[2], "rounds="))
+		if err != nil || !strings.HasPrefix(parts[2], "rounds=") {
+			return nil, fmt.Errorf("invalid SHA-crypt hash: malformed rounds %q", parts[2])
+		}
+
+		rounds, customRounds = n, true
+	}
+
+	salt := parts[len(parts)-2]
+	variant := parts[1]
+
+	return cryptHash{
+		encoded: s,
+		compute: func(password string) string {
+			return shaCrypt(variant, password, salt, rounds, customRounds)
+		},
+	}, nil
+}
+
+func parseAPR1Hash(s string) (passwordHash, error) {
+	// $apr1$salt$hash
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid APR1-MD5 hash")
+	}
+
+	salt := parts[2]
+
+	return cryptHash{
+		encoded: s,
+		compute: func(password string) string {
+			return apr1Crypt(password, salt)
+		},
+	}, nil
+}
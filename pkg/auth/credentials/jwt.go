@@ -0,0 +1,291 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/tool/filewatch"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// DefaultJWTLeeway is the clock skew tolerated on the exp, nbf and iat claims when none is given.
+const DefaultJWTLeeway = time.Minute
+
+// DefaultJWTAlgorithms are the signature algorithms accepted when none are given. Only the compact signatures
+// of ECDSA and EdDSA leave room for the claims within the 255 bytes of a SOCKS5 password, an RSA signature alone
+// taking 342 bytes or more once encoded, so the RS and PS algorithms are to be enabled with WithJWTAlgorithms.
+var DefaultJWTAlgorithms = []jose.SignatureAlgorithm{
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// JWTStore validates the password as a signed JWT, such as a short-lived workload identity token.
+//
+// The token is verified against the public keys of a local JWKS file, by its 'kid' header or against
+// every key when it has none, and must carry an 'exp' claim. The issuer and audience are checked when set.
+// The user is identified by the 'sub' claim, or by the username when the token has no subject,
+// and the identity expires with the token.
+//
+// The token is sent as the password, which RFC 1929 caps at 255 bytes over SOCKS5, while HTTP has no such limit.
+// An ES256 or EdDSA token only fits with a few short claims such as 'sub', 'aud', 'exp' and 'nbf',
+// tokens issued for other purposes usually don't.
+//
+// The keys are swapped atomically on Reload, and kept as they are when the new file fails validation.
+type JWTStore struct {
+	filename string
+	opts     *jwtOptions
+
+	keys atomic.Pointer[jose.JSONWebKeySet]
+	now  func() time.Time
+}
+
+type JWTOption func(*jwtOptions)
+
+type jwtOptions struct {
+	issuer        string
+	audiences     []string
+	algorithms    []jose.SignatureAlgorithm
+	leeway        time.Duration
+	usernameClaim string
+	groupsClaim   string
+	claims        []string
+	pollInterval  time.Duration
+}
+
+// WithJWTIssuer requires the 'iss' claim to be the issuer.
+func WithJWTIssuer(issuer string) JWTOption {
+	return func(o *jwtOptions) {
+		o.issuer = issuer
+	}
+}
+
+// WithJWTAudience requires the 'aud' claim to contain any of the audiences.
+func WithJWTAudience(audiences ...string) JWTOption {
+	return func(o *jwtOptions) {
+		o.audiences = append(o.audiences, audiences...)
+	}
+}
+
+// WithJWTAlgorithms sets the accepted signature algorithms, it defaults to DefaultJWTAlgorithms.
+func WithJWTAlgorithms(algorithms ...jose.SignatureAlgorithm) JWTOption {
+	return func(o *jwtOptions) {
+		o.algorithms = algorithms
+	}
+}
+
+// WithJWTLeeway sets the clock skew tolerated on the time claims, it defaults to DefaultJWTLeeway.
+func WithJWTLeeway(leeway time.Duration) JWTOption {
+	return func(o *jwtOptions) {
+		o.leeway = leeway
+	}
+}
+
+// WithJWTUsernameClaim requires the username to be the value of the claim, such as 'sub', and the claim
+// to be a non-empty string. Otherwise the username is not checked against the token.
+func WithJWTUsernameClaim(claim string) JWTOption {
+	return func(o *jwtOptions) {
+		o.usernameClaim = claim
+	}
+}
+
+// WithJWTGroupsClaim sets the claim holding the groups of the user, as a list or a single string.
+func WithJWTGroupsClaim(claim string) JWTOption {
+	return func(o *jwtOptions) {
+		o.groupsClaim = claim
+	}
+}
+
+// WithJWTClaims copies the claims into the attributes of the identity, for policy decisions.
+func WithJWTClaims(claims ...string) JWTOption {
+	return func(o *jwtOptions) {
+		o.claims = append(o.claims, claims...)
+	}
+}
+
+// WithJWTPollInterval sets how often Watch polls the JWKS file, on top of inotify.
+func WithJWTPollInterval(interval time.Duration) JWTOption {
+	return func(o *jwtOptions) {
+		o.pollInterval = interval
+	}
+}
+
+func NewJWTStore(jwksFilename string, opts ...JWTOption) (*JWTStore, error) {
+	o := &jwtOptions{
+		algorithms: DefaultJWTAlgorithms,
+		leeway:     DefaultJWTLeeway,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	j := &JWTStore{filename: jwksFilename, opts: o, now: time.Now}
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Reload reads the JWKS file again, the current keys are kept if the file fails validation.
+func (j *JWTStore) Reload() error {
+	data, err := os.ReadFile(j.filename)
+	if err != nil {
+		return err
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("%s: invalid JWKS: %w", j.filename, err)
+	}
+
+	// Only the public half of the keys is ever needed
+	keys := &jose.JSONWebKeySet{}
+	for i, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		if !key.IsPublic() {
+			key = key.Public()
+			if !key.Valid() {
+				return fmt.Errorf("%s: key #%d (kid %q) has no public key", j.filename, i, jwks.Keys[i].KeyID)
+			}
+		}
+
+		keys.Keys = append(keys.Keys, key)
+	}
+
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("%s: no signing key found", j.filename)
+	}
+
+	j.keys.Store(keys)
+	return nil
+}
+
+// Watch reloads the keys every time the JWKS file changes, until the context is done.
+// The outcome of every reload is reported to onReload.
+func (j *JWTStore) Watch(ctx context.Context, onReload func(error)) {
+	filewatch.Watch(ctx, j.filename, j.opts.pollInterval, func() {
+		onReload(j.Reload())
+	})
+}
+
+func (j *JWTStore) Validate(p Parameters) error {
+	_, err := j.Identify(context.Background(), nil, p)
+	return err
+}
+
+func (j *JWTStore) Identify(_ context.Context, _ net.Addr, p Parameters) (*Identity, error) {
+	token, err := jwt.ParseSigned(p.Password, j.opts.algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("%w, malformed token: %v", ErrInvalidCredentials, err)
+	}
+
+	var (
+		claims jwt.Claims
+		extra  map[string]interface{}
+	)
+
+	if err := j.verify(token, &claims, &extra); err != nil {
+		return nil, err
+	}
+
+	if claims.Expiry == nil {
+		return nil, fmt.Errorf("%w, token has no expiry", ErrInvalidCredentials)
+	}
+
+	expected := jwt.Expected{
+		Issuer:      j.opts.issuer,
+		AnyAudience: j.opts.audiences,
+		Time:        j.now(),
+	}
+
+	if err := claims.ValidateWithLeeway(expected, j.opts.leeway); err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrInvalidCredentials, err)
+	}
+
+	if j.opts.usernameClaim != "" {
+		// A missing claim would otherwise match an empty username
+		value, _ := extra[j.opts.usernameClaim].(string)
+		if value == "" {
+			return nil, fmt.Errorf("%w, token has no %q claim", ErrInvalidCredentials, j.opts.usernameClaim)
+		}
+
+		if value != p.Username {
+			return nil, fmt.Errorf("%w, username does not match the %q claim", ErrInvalidCredentials, j.opts.usernameClaim)
+		}
+	}
+
+	identity := &Identity{
+		UserID:    claims.Subject,
+		ExpiresAt: claims.Expiry.Time().UTC(),
+	}
+
+	if identity.UserID == "" {
+		identity.UserID = p.Username
+	}
+
+	if j.opts.groupsClaim != "" {
+		identity.Groups = stringsClaim(extra[j.opts.groupsClaim])
+	}
+
+	for _, name := range j.opts.claims {
+		if value, ok := extra[name]; ok {
+			if identity.Attributes == nil {
+				identity.Attributes = make(map[string]interface{})
+			}
+
+			identity.Attributes[name] = value
+		}
+	}
+
+	return identity, nil
+}
+
+// verify checks the signature of the token with the key of its 'kid' header, or with every key when it has none.
+func (j *JWTStore) verify(token *jwt.JSONWebToken, dest ...interface{}) error {
+	jwks := j.keys.Load()
+
+	keys := jwks.Keys
+	if kid := token.Headers[0].KeyID; kid != "" {
+		keys = jwks.Key(kid)
+		if len(keys) == 0 {
+			return fmt.Errorf("%w, unknown key %q", ErrInvalidCredentials, kid)
+		}
+	}
+
+	var err error
+	for _, key := range keys {
+		if err = token.Claims(key, dest...); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w, invalid signature: %v", ErrInvalidCredentials, err)
+}
+
+// stringsClaim returns the claim as a list of strings, non-string items are left out.
+func stringsClaim(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+
+		return out
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package credentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type jwtKey struct {
+	kid string
+	key *ecdsa.PrivateKey
+}
+
+func newJWTKey(t *testing.T, kid string) jwtKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	return jwtKey{kid: kid, key: key}
+}
+
+func (k jwtKey) sign(t *testing.T, claims ...interface{}) string {
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if k.kid != "" {
+		opts = opts.WithHeader("kid", k.kid)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: k.key}, opts)
+	require.NoError(t, err)
+
+	builder := jwt.Signed(signer)
+	for _, c := range claims {
+		builder = builder.Claims(c)
+	}
+
+	token, err := builder.Serialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+func writeJWKS(t *testing.T, filename string, keys ...jwtKey) {
+	var jwks jose.JSONWebKeySet
+	for _, k := range keys {
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: &k.key.PublicKey, KeyID: k.kid, Algorithm: string(jose.ES256), Use: "sig"})
+	}
+
+	data, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filename, data, 0o600))
+}
+
+func TestJWTStore(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	k1 := newJWTKey(t, "k1")
+	filename := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, filename, k1)
+
+	store, err := NewJWTStore(filename,
+		WithJWTIssuer("https://identity.example.org"),
+		WithJWTAudience("socks5"),
+		WithJWTUsernameClaim("workload"),
+		WithJWTGroupsClaim("groups"),
+		WithJWTClaims("tenant", "env"),
+	)
+	require.NoError(t, err)
+	store.now = func() time.Time { return now }
+
+	claims := func(mutate func(*jwt.Claims)) *jwt.Claims {
+		c := &jwt.Claims{
+			Issuer:    "https://identity.example.org",
+			Subject:   "spiffe://example.org/billing",
+			Audience:  jwt.Audience{"socks5"},
+			Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+		}
+		if mutate != nil {
+			mutate(c)
+		}
+
+		return c
+	}
+
+	extra := map[string]interface{}{"workload": "billing", "groups": []string{"payments"}, "tenant": "acme"}
+
+	identify := func(username, token string) (*Identity, error) {
+		return store.Identify(context.Background(), nil, Parameters{Username: username, Password: token})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		identity, err := identify("billing", k1.sign(t, claims(nil), extra))
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{
+			UserID:     "spiffe://example.org/billing",
+			Groups:     []string{"payments"},
+			Attributes: map[string]interface{}{"tenant": "acme"},
+			ExpiresAt:  now.Add(5 * time.Minute),
+		}, identity)
+	})
+
+	t.Run("fits a SOCKS5 password", func(t *testing.T) {
+		// Only the claims the store needs fit along with an ES256 signature, not the ones above
+		store, err := NewJWTStore(filename, WithJWTAudience("socks5"))
+		require.NoError(t, err)
+		store.now = func() time.Time { return now }
+
+		token := k1.sign(t, &jwt.Claims{
+			Subject:   "billing",
+			Audience:  jwt.Audience{"socks5"},
+			Expiry:    jwt.NewNumericDate(now.Add(5 * time.Minute)),
+			NotBefore: jwt.NewNumericDate(now.Add(-time.Minute)),
+		})
+		assert.LessOrEqual(t, len(token), 255)
+
+		identity, err := store.Identify(context.Background(), nil, Parameters{Username: "billing", Password: token})
+		require.NoError(t, err)
+		assert.Equal(t, "billing", identity.UserID)
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		other := newJWTKey(t, "k1")
+
+		for name, token := range map[string]string{
+			"malformed":       "not-a-jwt",
+			"wrong issuer":    k1.sign(t, claims(func(c *jwt.Claims) { c.Issuer = "https://evil.example.org" }), extra),
+			"wrong audience":  k1.sign(t, claims(func(c *jwt.Claims) { c.Audience = jwt.Audience{"other"} }), extra),
+			"expired":         k1.sign(t, claims(func(c *jwt.Claims) { c.Expiry = jwt.NewNumericDate(now.Add(-2 * time.Minute)) }), extra),
+			"not yet valid":   k1.sign(t, claims(func(c *jwt.Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(2 * time.Minute)) }), extra),
+			"no expiry":       k1.sign(t, claims(func(c *jwt.Claims) { c.Expiry = nil }), extra),
+			"unknown key":     newJWTKey(t, "k2").sign(t, claims(nil), extra),
+			"wrong signature": other.sign(t, claims(nil), extra),
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := identify("billing", token)
+				require.ErrorIs(t, err, ErrInvalidCredentials)
+			})
+		}
+	})
+
+	t.Run("username must match the claim", func(t *testing.T) {
+		_, err := identify("reporting", k1.sign(t, claims(nil), extra))
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+
+		// A missing or empty claim doesn't match an empty username
+		for _, workload := range []interface{}{nil, ""} {
+			_, err = identify("", k1.sign(t, claims(nil), map[string]interface{}{"workload": workload}))
+			require.ErrorIs(t, err, ErrInvalidCredentials)
+		}
+
+		_, err = identify("", k1.sign(t, claims(nil)))
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("keys are reloaded", func(t *testing.T) {
+		k2 := newJWTKey(t, "")
+		writeJWKS(t, filename, k2)
+		require.NoError(t, store.Reload())
+
+		_, err := identify("billing", k1.sign(t, claims(nil), extra))
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+
+		// Tokens without a 'kid' header are checked against every key
+		require.NoError(t, store.Validate(Parameters{Username: "billing", Password: k2.sign(t, claims(nil), extra)}))
+
+		// A broken file keeps the previous keys
+		require.NoError(t, os.WriteFile(filename, []byte(`{"keys": []}`), 0o600))
+		require.Error(t, store.Reload())
+		require.NoError(t, store.Validate(Parameters{Username: "billing", Password: k2.sign(t, claims(nil), extra)}))
+	})
+}
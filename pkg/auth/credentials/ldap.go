@@ -0,0 +1,372 @@
+package credentials
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	ErrLDAPUnavailable = fmt.Errorf("LDAP server unavailable")
+)
+
+const (
+	DefaultLDAPTimeout     = 5 * time.Second
+	DefaultLDAPPoolSize    = 4
+	DefaultLDAPGroupFilter = "(|(member={dn})(uniqueMember={dn}))"
+	DefaultLDAPGroupAttr   = "cn"
+)
+
+// LDAPStore validates credentials with an LDAP simple bind as the user.
+//
+// The user DN is either built from a template, such as 'uid={username},ou=people,dc=example,dc=org',
+// or looked up with a search bound as a service account before binding as the user.
+// The groups of the user are looked up after the bind, and returned in its identity along with its DN as the "dn" attribute.
+type LDAPStore struct {
+	url       string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	userDNTemplate string
+
+	searchBaseDN   string
+	searchFilter   string
+	bindDN         string
+	bindPassword   string
+	groupBaseDN    string
+	groupFilter    string
+	groupAttribute string
+	requiredGroup  string
+
+	pool chan *ldap.Conn
+}
+
+type LDAPOption func(*LDAPStore)
+
+// WithLDAPUserDNTemplate builds the user DN from the template, replacing {username} with the escaped username.
+func WithLDAPUserDNTemplate(template string) LDAPOption {
+	return func(l *LDAPStore) {
+		l.userDNTemplate = template
+	}
+}
+
+// WithLDAPSearch looks up the user DN under baseDN with the filter, replacing {username} with the escaped username,
+// such as '(&(objectClass=person)(uid={username}))'. The search is bound as bindDN, or anonymous when it is empty.
+func WithLDAPSearch(baseDN, filter, bindDN, bindPassword string) LDAPOption {
+	return func(l *LDAPStore) {
+		l.searchBaseDN = baseDN
+		l.searchFilter = filter
+		l.bindDN = bindDN
+		l.bindPassword = bindPassword
+	}
+}
+
+// WithLDAPGroups looks up the groups of the user under baseDN with the filter, replacing {dn} with the user DN
+// and {username} with the username, the group names are read from the attribute.
+// The filter defaults to DefaultLDAPGroupFilter and the attribute to DefaultLDAPGroupAttr when empty.
+func WithLDAPGroups(baseDN, filter, attribute string) LDAPOption {
+	return func(l *LDAPStore) {
+		l.groupBaseDN = baseDN
+		if filter != "" {
+			l.groupFilter = filter
+		}
+
+		if attribute != "" {
+			l.groupAttribute = attribute
+		}
+	}
+}
+
+// WithLDAPRequiredGroup only accepts the users member of the group, matched by name or DN.
+// It requires WithLDAPGroups.
+func WithLDAPRequiredGroup(group string) LDAPOption {
+	return func(l *LDAPStore) {
+		l.requiredGroup = group
+	}
+}
+
+// WithLDAPStartTLS upgrades ldap:// connections with StartTLS.
+func WithLDAPStartTLS() LDAPOption {
+	return func(l *LDAPStore) {
+		l.startTLS = true
+	}
+}
+
+// WithLDAPTLSConfig sets the TLS configuration for ldaps:// and StartTLS connections.
+func WithLDAPTLSConfig(config *tls.Config) LDAPOption {
+	return func(l *LDAPStore) {
+		l.tlsConfig = config
+	}
+}
+
+// WithLDAPTimeout bounds dialing and every LDAP operation, it defaults to DefaultLDAPTimeout.
+func WithLDAPTimeout(timeout time.Duration) LDAPOption {
+	return func(l *LDAPStore) {
+		l.timeout = timeout
+	}
+}
+
+// WithLDAPPoolSize sets how many idle connections are kept for reuse, it defaults to DefaultLDAPPoolSize.
+func WithLDAPPoolSize(size int) LDAPOption {
+	return func(l *LDAPStore) {
+		l.pool = make(chan *ldap.Conn, max(size, 0))
+	}
+}
+
+// NewLDAPStore creates a store for the LDAP server at the URL, either ldap:// or ldaps://.
+// Either WithLDAPUserDNTemplate or WithLDAPSearch is required.
+func NewLDAPStore(ldapURL string, opts ...LDAPOption) (*LDAPStore, error) {
+	l := &LDAPStore{
+		url:            ldapURL,
+		timeout:        DefaultLDAPTimeout,
+		groupFilter:    DefaultLDAPGroupFilter,
+		groupAttribute: DefaultLDAPGroupAttr,
+		pool:           make(chan *ldap.Conn, DefaultLDAPPoolSize),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	switch {
+	case l.userDNTemplate == "" && l.searchFilter == "":
+		return nil, errors.New("LDAP store requires either a user DN template or a user search")
+	case l.userDNTemplate != "" && l.searchFilter != "":
+		return nil, errors.New("LDAP store accepts either a user DN template or a user search, not both")
+	case l.requiredGroup != "" && l.groupBaseDN == "":
+		return nil, errors.New("LDAP required group needs a group search base DN")
+	case l.startTLS && strings.HasPrefix(strings.ToLower(ldapURL), "ldaps://"):
+		return nil, errors.New("LDAP StartTLS is not available over ldaps://")
+	}
+
+	return l, nil
+}
+
+func (l *LDAPStore) Validate(p Parameters) error {
+	_, err := l.Identify(context.Background(), nil, p)
+	return err
+}
+
+// Identify binds as the user and returns its identity, the operations are bounded by the timeout of the store.
+func (l *LDAPStore) Identify(ctx context.Context, _ net.Addr, p Parameters) (*Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLDAPUnavailable, err)
+	}
+
+	// An empty password is an unauthenticated bind, which succeeds for any DN (RFC 4513, section 5.1.2)
+	if p.Username == "" || p.Password == "" {
+		return nil, fmt.Errorf("%w, empty username or password", ErrInvalidCredentials)
+	}
+
+	conn, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := l.identify(conn, p)
+	if err != nil && !errors.Is(err, ErrInvalidCredentials) {
+		// The connection is left in an unknown state
+		conn.Close()
+		return nil, err
+	}
+
+	l.put(conn)
+	return identity, err
+}
+
+func (l *LDAPStore) identify(conn *ldap.Conn, p Parameters) (*Identity, error) {
+	userDN, err := l.userDN(conn, p.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(userDN, p.Password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, fmt.Errorf("%w, either username or password is incorrect", ErrInvalidCredentials)
+		}
+
+		return nil, fmt.Errorf("%w: bind: %v", ErrLDAPUnavailable, err)
+	}
+
+	identity := &Identity{
+		UserID:     p.Username,
+		Attributes: map[string]interface{}{"dn": userDN},
+	}
+
+	if l.groupBaseDN == "" {
+		return identity, nil
+	}
+
+	groups, err := l.groups(conn, userDN, p.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.requiredGroup != "" && !l.memberOf(groups) {
+		return nil, fmt.Errorf("%w, user is not a member of %q", ErrInvalidCredentials, l.requiredGroup)
+	}
+
+	for _, g := range groups {
+		identity.Groups = append(identity.Groups, g.name)
+	}
+
+	return identity, nil
+}
+
+func (l *LDAPStore) userDN(conn *ldap.Conn, username string) (string, error) {
+	if l.userDNTemplate != "" {
+		return strings.ReplaceAll(l.userDNTemplate, "{username}", ldap.EscapeDN(username)), nil
+	}
+
+	if err := l.bindService(conn); err != nil {
+		return "", err
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		l.searchBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(l.timeout.Seconds()), false,
+		strings.ReplaceAll(l.searchFilter, "{username}", ldap.EscapeFilter(username)),
+		[]string{"dn"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return "", fmt.Errorf("%w: user search: %v", ErrLDAPUnavailable, err)
+	}
+
+	// An ambiguous search is refused rather than binding as an arbitrary entry
+	if res == nil || len(res.Entries) != 1 {
+		return "", fmt.Errorf("%w, user not found or not unique", ErrInvalidCredentials)
+	}
+
+	return res.Entries[0].DN, nil
+}
+
+type ldapGroup struct {
+	dn   string
+	name string
+}
+
+func (l *LDAPStore) groups(conn *ldap.Conn, userDN, username string) ([]ldapGroup, error) {
+	// Groups are looked up as the service account when there is one, the user may not be allowed to read them
+	if l.bindDN != "" {
+		if err := l.bindService(conn); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(userDN),
+		"{username}", ldap.EscapeFilter(username),
+	).Replace(l.groupFilter)
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		l.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(l.timeout.Seconds()), false,
+		filter, []string{l.groupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("%w: group search: %v", ErrLDAPUnavailable, err)
+	}
+
+	groups := make([]ldapGroup, 0, len(res.Entries))
+	for _, entry := range res.Entries {
+		name := entry.GetAttributeValue(l.groupAttribute)
+		if name == "" {
+			name = entry.DN
+		}
+
+		groups = append(groups, ldapGroup{dn: entry.DN, name: name})
+	}
+
+	return groups, nil
+}
+
+func (l *LDAPStore) memberOf(groups []ldapGroup) bool {
+	for _, g := range groups {
+		if strings.EqualFold(g.name, l.requiredGroup) || strings.EqualFold(g.dn, l.requiredGroup) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (l *LDAPStore) bindService(conn *ldap.Conn) error {
+	var err error
+	if l.bindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(l.bindDN, l.bindPassword)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: service bind: %v", ErrLDAPUnavailable, err)
+	}
+
+	return nil
+}
+
+// get takes an idle connection from the pool, or dials a new one.
+func (l *LDAPStore) get() (*ldap.Conn, error) {
+	for {
+		select {
+		case conn := <-l.pool:
+			if conn.IsClosing() {
+				continue
+			}
+
+			return conn, nil
+		default:
+			return l.dial()
+		}
+	}
+}
+
+// put returns the connection to the pool, or closes it when the pool is full.
+func (l *LDAPStore) put(conn *ldap.Conn) {
+	select {
+	case l.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+func (l *LDAPStore) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(l.url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: l.timeout}),
+		ldap.DialWithTLSConfig(l.tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLDAPUnavailable, err)
+	}
+
+	conn.SetTimeout(l.timeout)
+
+	if l.startTLS {
+		config := l.tlsConfig
+		if config == nil {
+			config = &tls.Config{ServerName: l.hostname()}
+		}
+
+		if err := conn.StartTLS(config); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: StartTLS: %v", ErrLDAPUnavailable, err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (l *LDAPStore) hostname() string {
+	u, err := url.Parse(l.url)
+	if err != nil {
+		return ""
+	}
+
+	return u.Hostname()
+}
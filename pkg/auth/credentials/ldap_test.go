@@ -0,0 +1,337 @@
+package credentials
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeLDAPServer is an in-process stand-in of an LDAP server, answering simple binds,
+// searches with equality/presence filters and StartTLS.
+type fakeLDAPServer struct {
+	entries   []fakeLDAPEntry
+	tlsConfig *tls.Config
+	conns     atomic.Int32
+}
+
+var fakeLDAPDirectory = []fakeLDAPEntry{
+	{dn: "cn=proxy,dc=example,dc=org", password: "service"},
+	{dn: "uid=alice,ou=people,dc=example,dc=org", password: "alice-secret", attrs: map[string][]string{"uid": {"alice"}, "objectClass": {"person"}}},
+	{dn: "uid=bob,ou=people,dc=example,dc=org", password: "bob-secret", attrs: map[string][]string{"uid": {"bob"}, "objectClass": {"person"}}},
+	{dn: "cn=proxy-users,ou=groups,dc=example,dc=org", attrs: map[string][]string{"cn": {"proxy-users"}, "member": {"uid=alice,ou=people,dc=example,dc=org"}}},
+	{dn: "cn=ops,ou=groups,dc=example,dc=org", attrs: map[string][]string{"cn": {"ops"}, "member": {"uid=alice,ou=people,dc=example,dc=org", "uid=bob,ou=people,dc=example,dc=org"}}},
+}
+
+func newFakeLDAPServer(t *testing.T, tlsConfig *tls.Config) (*fakeLDAPServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	s := &fakeLDAPServer{entries: fakeLDAPDirectory, tlsConfig: tlsConfig}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			s.conns.Add(1)
+			go s.handle(conn)
+		}
+	}()
+
+	return s, "ldap://" + l.Addr().String()
+}
+
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		msgID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := s.bind(op.Children[1].Data.String(), op.Children[2].Data.String())
+			writeLDAPMessage(conn, msgID, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			base, filter := op.Children[0].Data.String(), op.Children[6]
+			for _, e := range s.entries {
+				if hasDNSuffix(e.dn, base) && matchFilter(filter, e) {
+					writeLDAPMessage(conn, msgID, searchEntry(e))
+				}
+			}
+
+			writeLDAPMessage(conn, msgID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationExtendedRequest:
+			if s.tlsConfig == nil {
+				writeLDAPMessage(conn, msgID, ldapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+
+			writeLDAPMessage(conn, msgID, ldapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			conn = tls.Server(conn, s.tlsConfig)
+		default:
+			return
+		}
+	}
+}
+
+func (s *fakeLDAPServer) bind(dn, password string) uint16 {
+	if password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	for _, e := range s.entries {
+		if strings.EqualFold(e.dn, dn) && e.password != "" && e.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func hasDNSuffix(dn, base string) bool {
+	return strings.HasSuffix(strings.ToLower(dn), strings.ToLower(base))
+}
+
+func matchFilter(f *ber.Packet, e fakeLDAPEntry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matchFilter(c, e) {
+				return false
+			}
+		}
+
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matchFilter(c, e) {
+				return true
+			}
+		}
+
+		return false
+	case ldap.FilterEqualityMatch:
+		for _, v := range entryValues(e, f.Children[0].Data.String()) {
+			if strings.EqualFold(v, f.Children[1].Data.String()) {
+				return true
+			}
+		}
+
+		return false
+	case ldap.FilterPresent:
+		return len(entryValues(e, f.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+func entryValues(e fakeLDAPEntry, attr string) []string {
+	for name, values := range e.attrs {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+
+	return nil
+}
+
+func writeLDAPMessage(conn net.Conn, msgID int64, op *ber.Packet) {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "MessageID"))
+	packet.AppendChild(op)
+
+	_, _ = conn.Write(packet.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return op
+}
+
+func searchEntry(e fakeLDAPEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+
+	op.AppendChild(attrs)
+	return op
+}
+
+func TestLDAPStore_DNTemplate(t *testing.T) {
+	srv, url := newFakeLDAPServer(t, nil)
+
+	store, err := NewLDAPStore(url, WithLDAPUserDNTemplate("uid={username},ou=people,dc=example,dc=org"))
+	require.NoError(t, err)
+
+	identity, err := store.Identify(context.Background(), nil, Parameters{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+	assert.Equal(t, "alice", identity.UserID)
+	assert.Equal(t, "uid=alice,ou=people,dc=example,dc=org", identity.Attributes["dn"])
+
+	require.ErrorIs(t, store.Validate(Parameters{Username: "alice", Password: "wrong"}), ErrInvalidCredentials)
+	require.ErrorIs(t, store.Validate(Parameters{Username: "nobody", Password: "alice-secret"}), ErrInvalidCredentials)
+
+	// An unauthenticated bind must never be mistaken for a valid password
+	require.ErrorIs(t, store.Validate(Parameters{Username: "alice", Password: ""}), ErrInvalidCredentials)
+
+	// Connections are pooled
+	require.NoError(t, store.Validate(Parameters{Username: "bob", Password: "bob-secret"}))
+	assert.EqualValues(t, 1, srv.conns.Load())
+}
+
+func TestLDAPStore_SearchThenBind(t *testing.T) {
+	_, url := newFakeLDAPServer(t, nil)
+
+	store, err := NewLDAPStore(url,
+		WithLDAPSearch("ou=people,dc=example,dc=org", "(&(objectClass=person)(uid={username}))", "cn=proxy,dc=example,dc=org", "service"),
+		WithLDAPGroups("ou=groups,dc=example,dc=org", "", ""),
+		WithLDAPRequiredGroup("proxy-users"),
+	)
+	require.NoError(t, err)
+
+	identity, err := store.Identify(context.Background(), nil, Parameters{Username: "alice", Password: "alice-secret"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"proxy-users", "ops"}, identity.Groups)
+
+	// Valid credentials, but not a member of the required group
+	require.ErrorIs(t, store.Validate(Parameters{Username: "bob", Password: "bob-secret"}), ErrInvalidCredentials)
+
+	require.ErrorIs(t, store.Validate(Parameters{Username: "alice", Password: "bob-secret"}), ErrInvalidCredentials)
+	require.ErrorIs(t, store.Validate(Parameters{Username: "*", Password: "alice-secret"}), ErrInvalidCredentials)
+}
+
+func TestLDAPStore_StartTLS(t *testing.T) {
+	serverConfig, clientConfig := testTLSConfigs(t)
+	_, url := newFakeLDAPServer(t, serverConfig)
+
+	store, err := NewLDAPStore(url,
+		WithLDAPUserDNTemplate("uid={username},ou=people,dc=example,dc=org"),
+		WithLDAPStartTLS(),
+		WithLDAPTLSConfig(clientConfig),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, store.Validate(Parameters{Username: "alice", Password: "alice-secret"}))
+
+	// The server certificate is not trusted without the CA
+	store, err = NewLDAPStore(url,
+		WithLDAPUserDNTemplate("uid={username},ou=people,dc=example,dc=org"),
+		WithLDAPStartTLS(),
+	)
+	require.NoError(t, err)
+
+	err = store.Validate(Parameters{Username: "alice", Password: "alice-secret"})
+	require.ErrorIs(t, err, ErrLDAPUnavailable)
+}
+
+func TestLDAPStore_Unavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	store, err := NewLDAPStore("ldap://"+addr,
+		WithLDAPUserDNTemplate("uid={username},ou=people,dc=example,dc=org"),
+		WithLDAPTimeout(time.Second),
+	)
+	require.NoError(t, err)
+
+	err = store.Validate(Parameters{Username: "alice", Password: "alice-secret"})
+	require.ErrorIs(t, err, ErrLDAPUnavailable)
+	require.NotErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestNewLDAPStore(t *testing.T) {
+	_, err := NewLDAPStore("ldap://127.0.0.1:389")
+	require.Error(t, err)
+
+	_, err = NewLDAPStore("ldap://127.0.0.1:389",
+		WithLDAPUserDNTemplate("uid={username},dc=example,dc=org"),
+		WithLDAPRequiredGroup("proxy-users"),
+	)
+	require.Error(t, err)
+
+	_, err = NewLDAPStore("ldaps://127.0.0.1:636",
+		WithLDAPUserDNTemplate("uid={username},dc=example,dc=org"),
+		WithLDAPStartTLS(),
+	)
+	require.Error(t, err)
+}
+
+// testTLSConfigs returns a server configuration with a self-signed certificate for 127.0.0.1,
+// and a client configuration trusting it.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap.test"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	clientConfig := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+
+	return serverConfig, clientConfig
+}
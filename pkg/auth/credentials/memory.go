@@ -1,16 +1,26 @@
 package credentials
 
 import (
+	"context"
 	"fmt"
+	"net"
 )
 
 type MemoryStore map[string]string
 
 func (m MemoryStore) Validate(p Parameters) error {
 	passwd, ok := m[p.Username]
-	if !ok || passwd != p.Password {
+	if !ok || !plainPassword(passwd).Verify(p.Password) {
 		return fmt.Errorf("%w, either username or password is incorrect", ErrInvalidCredentials)
 	}
 
 	return nil
 }
+
+func (m MemoryStore) Identify(_ context.Context, _ net.Addr, p Parameters) (*Identity, error) {
+	if err := m.Validate(p); err != nil {
+		return nil, err
+	}
+
+	return &Identity{UserID: p.Username}, nil
+}
@@ -0,0 +1,111 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// User is a user of a UserStore.
+type User struct {
+	Username string
+
+	// Hash is the password hash, in any of the formats of a FileStore such as bcrypt or argon2id.
+	Hash string
+
+	// Groups are the groups the user is a member of.
+	Groups []string
+
+	Account Account
+}
+
+// UserStore validates credentials against a fixed list of users, such as the ones of a policy file.
+type UserStore struct {
+	users map[string]userEntry
+	now   func() time.Time
+}
+
+// userEntry is a user known to a UserStore or a FileStore, the latter having no groups.
+type userEntry struct {
+	hash    passwordHash
+	groups  []string
+	account Account
+}
+
+func NewUserStore(users []User) (*UserStore, error) {
+	s := &UserStore{users: make(map[string]userEntry, len(users)), now: time.Now}
+
+	for _, u := range users {
+		if u.Username == "" {
+			return nil, fmt.Errorf("empty username")
+		}
+
+		if _, exists := s.users[u.Username]; exists {
+			return nil, fmt.Errorf("duplicate username %q", u.Username)
+		}
+
+		h, err := parseHash(u.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %v", u.Username, err)
+		}
+
+		s.users[u.Username] = userEntry{hash: h, groups: u.Groups, account: u.Account}
+	}
+
+	return s, nil
+}
+
+// ValidateHash returns an error when the password hash is not in a supported format.
+func ValidateHash(hash string) error {
+	_, err := parseHash(hash)
+	return err
+}
+
+func (s *UserStore) Validate(p Parameters) error {
+	_, err := s.validate(p)
+	return err
+}
+
+func (s *UserStore) validate(p Parameters) (userEntry, error) {
+	return validateUser(s.users, p, s.now())
+}
+
+// validateUser checks the password and the account of the user, as both UserStore and FileStore do.
+func validateUser(users map[string]userEntry, p Parameters, now time.Time) (userEntry, error) {
+	entry, ok := users[p.Username]
+	if !ok {
+		dummyHash().Verify(p.Password)
+		return userEntry{}, fmt.Errorf("%w, either username or password is incorrect", ErrInvalidCredentials)
+	}
+
+	if !entry.hash.Verify(p.Password) {
+		return userEntry{}, fmt.Errorf("%w, either username or password is incorrect", ErrInvalidCredentials)
+	}
+
+	if err := entry.account.Check(now); err != nil {
+		return userEntry{}, err
+	}
+
+	return entry, nil
+}
+
+func (s *UserStore) Identify(_ context.Context, _ net.Addr, p Parameters) (*Identity, error) {
+	entry, err := s.validate(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		UserID:      p.Username,
+		Groups:      append([]string(nil), entry.groups...),
+		ExpiresAt:   entry.account.ExpiresAt,
+		MaxSessions: entry.account.MaxSessions,
+	}, nil
+}
+
+// Account returns the account of the user.
+func (s *UserStore) Account(userID string) (Account, bool) {
+	entry, ok := s.users[userID]
+	return entry.account, ok
+}
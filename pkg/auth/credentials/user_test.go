@@ -0,0 +1,43 @@
+package credentials
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserStore(t *testing.T) {
+	const hash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+	s, err := NewUserStore([]User{
+		{Username: "alice", Hash: hash, Groups: []string{"ops"}, Account: Account{MaxSessions: 2}},
+		{Username: "bob", Hash: hash, Account: Account{Disabled: true}},
+	})
+	require.NoError(t, err)
+
+	identity, err := s.Identify(context.Background(), nil, Parameters{Username: "alice", Password: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, &Identity{UserID: "alice", Groups: []string{"ops"}, MaxSessions: 2}, identity)
+
+	require.ErrorIs(t, s.Validate(Parameters{Username: "alice", Password: "wrong"}), ErrInvalidCredentials)
+	require.ErrorIs(t, s.Validate(Parameters{Username: "bob", Password: "secret"}), ErrAccountDisabled)
+
+	account, ok := s.Account("bob")
+	require.True(t, ok)
+	require.True(t, account.Disabled)
+
+	for name, users := range map[string][]User{
+		"empty username":     {{Hash: hash}},
+		"duplicate username": {{Username: "alice", Hash: hash}, {Username: "alice", Hash: hash}},
+		"unsupported hash":   {{Username: "alice", Hash: "secret"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewUserStore(users)
+			require.Error(t, err)
+		})
+	}
+
+	require.NoError(t, ValidateHash(hash))
+	require.ErrorIs(t, ValidateHash("secret"), ErrUnsupportedHash)
+}
@@ -0,0 +1,329 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	ErrWebhookUnavailable = fmt.Errorf("credential webhook unavailable")
+)
+
+const (
+	DefaultWebhookTimeout     = 5 * time.Second
+	DefaultWebhookPositiveTTL = time.Minute
+	DefaultWebhookNegativeTTL = 10 * time.Second
+	DefaultWebhookCacheSize   = 10000
+)
+
+// maxWebhookResponseSize bounds the JSON response read from the webhook.
+const maxWebhookResponseSize = 1 << 20
+
+// WebhookStore validates credentials against an HTTP endpoint.
+//
+// The username, password and client address are POSTed as JSON, a 2xx response allows the user
+// while 401 and 403 deny the user. The body of a 2xx response optionally identifies the user,
+// as {"user_id": "...", "groups": [...], "attributes": {...}, "expires_at": "<RFC 3339>", "max_sessions": N}.
+// Any other outcome, including a timeout, is a failure of the webhook rather than a denial.
+// Allowed and denied results are cached for their own TTL, up to a number of entries.
+type WebhookStore struct {
+	url         string
+	client      *http.Client
+	timeout     time.Duration
+	header      http.Header
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	cacheSize   int
+
+	group singleflight.Group
+
+	mu    sync.Mutex
+	cache map[string]webhookResult
+	now   func() time.Time
+}
+
+type webhookRequest struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	ClientAddress string `json:"client_address,omitempty"`
+}
+
+type webhookResponse struct {
+	UserID      string                 `json:"user_id"`
+	Groups      []string               `json:"groups"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	ExpiresAt   time.Time              `json:"expires_at"`
+	MaxSessions int                    `json:"max_sessions"`
+}
+
+type webhookResult struct {
+	identity  *Identity
+	err       error
+	expiresAt time.Time
+}
+
+type WebhookOption func(*WebhookStore)
+
+// WithWebhookClient sets the HTTP client used to call the webhook, such as one configured with mTLS.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(w *WebhookStore) {
+		w.client = client
+	}
+}
+
+// WithWebhookTimeout bounds every call to the webhook, it defaults to DefaultWebhookTimeout.
+func WithWebhookTimeout(timeout time.Duration) WebhookOption {
+	return func(w *WebhookStore) {
+		w.timeout = timeout
+	}
+}
+
+// WithWebhookHeader adds a header to every call to the webhook, such as an API key.
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(w *WebhookStore) {
+		w.header.Add(key, value)
+	}
+}
+
+// WithWebhookCacheTTL sets how long allowed (positive) and denied (negative) results are cached,
+// a zero TTL disables the cache for that result.
+func WithWebhookCacheTTL(positive, negative time.Duration) WebhookOption {
+	return func(w *WebhookStore) {
+		w.positiveTTL = positive
+		w.negativeTTL = negative
+	}
+}
+
+// WithWebhookCacheSize caps the number of cached results, it defaults to DefaultWebhookCacheSize.
+// Once full, the expired results are swept and the results closest to expiry are evicted.
+func WithWebhookCacheSize(size int) WebhookOption {
+	return func(w *WebhookStore) {
+		w.cacheSize = size
+	}
+}
+
+func NewWebhookStore(url string, opts ...WebhookOption) *WebhookStore {
+	w := &WebhookStore{
+		url:         url,
+		client:      http.DefaultClient,
+		timeout:     DefaultWebhookTimeout,
+		header:      make(http.Header),
+		positiveTTL: DefaultWebhookPositiveTTL,
+		negativeTTL: DefaultWebhookNegativeTTL,
+		cacheSize:   DefaultWebhookCacheSize,
+		cache:       make(map[string]webhookResult),
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+func (w *WebhookStore) Validate(p Parameters) error {
+	_, err := w.Identify(context.Background(), nil, p)
+	return err
+}
+
+func (w *WebhookStore) Identify(ctx context.Context, remoteAddr net.Addr, p Parameters) (*Identity, error) {
+	key := w.cacheKey(p, remoteAddr)
+
+	if res, ok := w.cached(key); ok {
+		return w.result(res)
+	}
+
+	// Concurrent lookups of the same credentials share a single call to the webhook,
+	// which is not cancelled when one of the callers gives up
+	ch := w.group.DoChan(key, func() (interface{}, error) {
+		res := w.call(p, remoteAddr)
+		w.store(key, res)
+		return res, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: %v", ErrWebhookUnavailable, ctx.Err())
+	case v := <-ch:
+		return w.result(v.Val.(webhookResult))
+	}
+}
+
+// result rejects an identity the webhook returned as already expired.
+func (w *WebhookStore) result(res webhookResult) (*Identity, error) {
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	if !res.identity.ExpiresAt.IsZero() && !w.now().Before(res.identity.ExpiresAt) {
+		return nil, ErrAccountExpired
+	}
+
+	return res.identity, nil
+}
+
+func (w *WebhookStore) call(p Parameters, remoteAddr net.Addr) webhookResult {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	body := webhookRequest{Username: p.Username, Password: p.Password}
+	if remoteAddr != nil {
+		body.ClientAddress = remoteAddr.String()
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return webhookResult{err: fmt.Errorf("%w: %v", ErrWebhookUnavailable, err)}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(payload))
+	if err != nil {
+		return webhookResult{err: fmt.Errorf("%w: %v", ErrWebhookUnavailable, err)}
+	}
+
+	for key, values := range w.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return webhookResult{err: fmt.Errorf("%w: %v", ErrWebhookUnavailable, err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return webhookResult{err: fmt.Errorf("%w, rejected by webhook (%d)", ErrInvalidCredentials, resp.StatusCode)}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return webhookResult{err: fmt.Errorf("%w: unexpected status %d", ErrWebhookUnavailable, resp.StatusCode)}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseSize))
+	if err != nil {
+		return webhookResult{err: fmt.Errorf("%w: %v", ErrWebhookUnavailable, err)}
+	}
+
+	var out webhookResponse
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := json.Unmarshal(data, &out); err != nil {
+			return webhookResult{err: fmt.Errorf("%w: malformed response: %v", ErrWebhookUnavailable, err)}
+		}
+	}
+
+	if out.UserID == "" {
+		out.UserID = p.Username
+	}
+
+	return webhookResult{identity: &Identity{
+		UserID:      out.UserID,
+		Groups:      out.Groups,
+		Attributes:  out.Attributes,
+		ExpiresAt:   out.ExpiresAt,
+		MaxSessions: out.MaxSessions,
+	}}
+}
+
+func (w *WebhookStore) cached(key string) (webhookResult, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res, ok := w.cache[key]
+	if !ok {
+		return webhookResult{}, false
+	}
+
+	if w.now().After(res.expiresAt) {
+		delete(w.cache, key)
+		return webhookResult{}, false
+	}
+
+	return res, true
+}
+
+func (w *WebhookStore) store(key string, res webhookResult) {
+	ttl := w.positiveTTL
+	if res.err != nil {
+		// Only denials are cached, failures of the webhook are retried on the next lookup
+		if !errors.Is(res.err, ErrInvalidCredentials) {
+			return
+		}
+
+		ttl = w.negativeTTL
+	}
+
+	if ttl <= 0 || w.cacheSize <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	res.expiresAt = now.Add(ttl)
+	if res.identity != nil && !res.identity.ExpiresAt.IsZero() && res.identity.ExpiresAt.Before(res.expiresAt) {
+		res.expiresAt = res.identity.ExpiresAt
+	}
+
+	if _, ok := w.cache[key]; !ok && len(w.cache) >= w.cacheSize {
+		w.evict(now)
+	}
+
+	w.cache[key] = res
+}
+
+// evict makes room in the full cache, sweeping the expired results or else evicting the one closest to expiry,
+// so a flood of distinct credentials such as credential stuffing can't grow it without bounds.
+func (w *WebhookStore) evict(now time.Time) {
+	var (
+		oldestKey string
+		oldest    time.Time
+	)
+
+	for key, res := range w.cache {
+		if now.After(res.expiresAt) {
+			delete(w.cache, key)
+			continue
+		}
+
+		if oldestKey == "" || res.expiresAt.Before(oldest) {
+			oldestKey, oldest = key, res.expiresAt
+		}
+	}
+
+	if len(w.cache) >= w.cacheSize {
+		delete(w.cache, oldestKey)
+	}
+}
+
+// cacheKey digests the credentials and the client IP, so no password is kept in clear text.
+func (w *WebhookStore) cacheKey(p Parameters, remoteAddr net.Addr) string {
+	var clientIP string
+	if remoteAddr != nil {
+		clientIP = remoteAddr.String()
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+	}
+
+	h := sha256.New()
+	for _, s := range []string{p.Username, p.Password, clientIP} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,198 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newWebhookServer(t *testing.T, handler func(w http.ResponseWriter, body webhookRequest)) (*httptest.Server, *atomic.Int32) {
+	calls := new(atomic.Int32)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		var body webhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		handler(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, calls
+}
+
+func TestWebhookStore(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}
+
+	srv, calls := newWebhookServer(t, func(w http.ResponseWriter, body webhookRequest) {
+		switch {
+		case body.Username == "admin" && body.Password == "secret":
+			if body.ClientAddress != remote.String() {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"user_id":"u-1001","groups":["ops"],"attributes":{"team":"platform"},"expires_at":"2030-01-01T00:00:00Z"}`))
+		case body.Username == "expired":
+			_, _ = w.Write([]byte(`{"expires_at":"2020-01-01T00:00:00Z"}`))
+		case body.Username == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+
+	store := NewWebhookStore(srv.URL)
+
+	t.Run("allowed with identity", func(t *testing.T) {
+		identity, err := store.Identify(context.Background(), remote, Parameters{Username: "admin", Password: "secret"})
+		require.NoError(t, err)
+		assert.Equal(t, "u-1001", identity.UserID)
+		assert.Equal(t, []string{"ops"}, identity.Groups)
+		assert.Equal(t, "platform", identity.Attributes["team"])
+		assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), identity.ExpiresAt)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		_, err := store.Identify(context.Background(), remote, Parameters{Username: "admin", Password: "wrong"})
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("already expired", func(t *testing.T) {
+		_, err := store.Identify(context.Background(), remote, Parameters{Username: "expired", Password: "secret"})
+		require.ErrorIs(t, err, ErrAccountExpired)
+	})
+
+	t.Run("webhook failure is not a denial", func(t *testing.T) {
+		err := store.Validate(Parameters{Username: "broken", Password: "secret"})
+		require.ErrorIs(t, err, ErrWebhookUnavailable)
+		require.NotErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("results are cached", func(t *testing.T) {
+		before := calls.Load()
+
+		_, err := store.Identify(context.Background(), remote, Parameters{Username: "admin", Password: "secret"})
+		require.NoError(t, err)
+		_, err = store.Identify(context.Background(), remote, Parameters{Username: "admin", Password: "wrong"})
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+		assert.Equal(t, before, calls.Load())
+
+		// Failures are retried
+		require.Error(t, store.Validate(Parameters{Username: "broken", Password: "secret"}))
+		assert.Equal(t, before+1, calls.Load())
+	})
+}
+
+func TestWebhookStore_CacheTTL(t *testing.T) {
+	srv, calls := newWebhookServer(t, func(w http.ResponseWriter, body webhookRequest) {
+		if body.Password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+
+	now := time.Now()
+	store := NewWebhookStore(srv.URL, WithWebhookCacheTTL(time.Minute, 10*time.Second))
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Validate(Parameters{Username: "admin", Password: "secret"}))
+	require.ErrorIs(t, store.Validate(Parameters{Username: "admin", Password: "wrong"}), ErrInvalidCredentials)
+	require.EqualValues(t, 2, calls.Load())
+
+	// The negative result expires first
+	now = now.Add(30 * time.Second)
+	require.NoError(t, store.Validate(Parameters{Username: "admin", Password: "secret"}))
+	require.ErrorIs(t, store.Validate(Parameters{Username: "admin", Password: "wrong"}), ErrInvalidCredentials)
+	require.EqualValues(t, 3, calls.Load())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, store.Validate(Parameters{Username: "admin", Password: "secret"}))
+	require.EqualValues(t, 4, calls.Load())
+}
+
+func TestWebhookStore_CacheSize(t *testing.T) {
+	srv, calls := newWebhookServer(t, func(w http.ResponseWriter, body webhookRequest) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	now := time.Now()
+	store := NewWebhookStore(srv.URL, WithWebhookCacheSize(2))
+	store.now = func() time.Time { return now }
+
+	for _, password := range []string{"a", "b", "c"} {
+		require.ErrorIs(t, store.Validate(Parameters{Username: "admin", Password: password}), ErrInvalidCredentials)
+		now = now.Add(time.Second)
+	}
+	require.Len(t, store.cache, 2)
+
+	// The result closest to expiry is evicted, the latest ones are still cached
+	require.ErrorIs(t, store.Validate(Parameters{Username: "admin", Password: "c"}), ErrInvalidCredentials)
+	require.EqualValues(t, 3, calls.Load())
+
+	// The expired results are swept as a whole
+	now = now.Add(time.Minute)
+	require.ErrorIs(t, store.Validate(Parameters{Username: "admin", Password: "d"}), ErrInvalidCredentials)
+	require.Len(t, store.cache, 1)
+}
+
+func TestWebhookStore_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv, _ := newWebhookServer(t, func(w http.ResponseWriter, body webhookRequest) {
+		<-release
+	})
+	defer close(release)
+
+	store := NewWebhookStore(srv.URL, WithWebhookTimeout(50*time.Millisecond))
+
+	err := store.Validate(Parameters{Username: "admin", Password: "secret"})
+	require.ErrorIs(t, err, ErrWebhookUnavailable)
+	require.NotErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestWebhookStore_Singleflight(t *testing.T) {
+	release := make(chan struct{})
+	srv, calls := newWebhookServer(t, func(w http.ResponseWriter, body webhookRequest) {
+		<-release
+	})
+
+	store := NewWebhookStore(srv.URL)
+
+	const n = 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.Validate(Parameters{Username: "admin", Password: "secret"})
+		}()
+	}
+
+	// Let every lookup join the in-flight call before answering it
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	assert.EqualValues(t, 1, calls.Load())
+}
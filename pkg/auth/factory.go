@@ -6,17 +6,27 @@ import (
 	"github.com/ardikabs/socks5/pkg/types"
 )
 
-func factory(enabledMethods []types.AuthMethod, offeredMethods []byte, cs credentials.Storer) (types.AuthMethod, Authenticator) {
+func factory(enabledMethods []types.AuthMethod, offeredMethods []byte, cs credentials.Storer, o *options) (types.AuthMethod, Authenticator) {
 	for _, e := range enabledMethods {
 		if !slice.In(byte(e), offeredMethods) {
 			continue
 		}
 
+		if c, ok := o.registry.Lookup(e); ok {
+			return e, registeredAuthenticator{Authenticator: c(), method: e}
+		}
+
 		switch e {
 		case types.AuthNoAuthRequired:
 			return e, &guestAuthenticator{}
 		case types.AuthUserPass:
-			return e, &userPassAuthenticator{cs: cs}
+			return e, &userPassAuthenticator{cs: cs, lockout: o.lockout}
+		case types.AuthGSSAPI:
+			if o.gssapiMech == nil {
+				continue
+			}
+
+			return e, &gssapiAuthenticator{mech: o.gssapiMech}
 		}
 	}
 
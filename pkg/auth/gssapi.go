@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/gssapi"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+type gssapiAuthenticator struct {
+	mech gssapi.Mechanism
+}
+
+func (a *gssapiAuthenticator) Authenticate(_ context.Context, req io.Reader, rep io.Writer) (*AuthContext, error) {
+	// Reference: https://datatracker.ietf.org/doc/html/rfc1961
+	secCtx, err := a.mech.NewContext()
+	if err != nil {
+		_ = gssapi.WriteMessage(rep, gssapi.MessageAbort, nil)
+		return nil, fmt.Errorf("failed to create GSS-API security context: %v", err)
+	}
+
+	// Context establishment, tokens are exchanged until the mechanism reports completion
+	for established := false; !established; {
+		mtyp, token, err := gssapi.ReadMessage(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mtyp {
+		case gssapi.MessageAuth:
+		case gssapi.MessageAbort:
+			return nil, gssapi.ErrAborted
+		default:
+			_ = gssapi.WriteMessage(rep, gssapi.MessageAbort, nil)
+			return nil, fmt.Errorf("%w: %d", gssapi.ErrUnexpectedMessage, mtyp)
+		}
+
+		var output []byte
+		output, established, err = secCtx.Accept(token)
+		if err != nil {
+			_ = gssapi.WriteMessage(rep, gssapi.MessageAbort, nil)
+			return nil, fmt.Errorf("failed to accept GSS-API security context: %v", err)
+		}
+
+		if len(output) > 0 || !established {
+			if err := gssapi.WriteMessage(rep, gssapi.MessageAuth, output); err != nil {
+				return nil, fmt.Errorf("failed to send GSS-API token: %v", err)
+			}
+		}
+	}
+
+	// Protection level negotiation, the level is a single octet protected with gss_wrap
+	level, err := a.negotiateProtection(secCtx, req, rep)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthContext{
+		Method: types.AuthGSSAPI,
+		Payload: AuthPayload{
+			"principal": secCtx.Principal(),
+		},
+		Identity: &credentials.Identity{UserID: secCtx.Principal()},
+		Wrap: func(conn net.Conn) net.Conn {
+			return gssapi.NewConn(conn, secCtx, level == gssapi.ProtectionConfidentiality)
+		},
+	}, nil
+}
+
+func (a *gssapiAuthenticator) negotiateProtection(secCtx gssapi.Context, req io.Reader, rep io.Writer) (uint8, error) {
+	mtyp, token, err := gssapi.ReadMessage(req)
+	if err != nil {
+		return 0, err
+	}
+
+	if mtyp != gssapi.MessageProtection {
+		return 0, fmt.Errorf("%w: %d", gssapi.ErrUnexpectedMessage, mtyp)
+	}
+
+	msg, err := secCtx.Unwrap(token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unwrap GSS-API protection level: %v", err)
+	}
+
+	if len(msg) != 1 {
+		return 0, errors.New("malformed GSS-API protection level")
+	}
+
+	level := msg[0]
+	switch level {
+	case gssapi.ProtectionIntegrity, gssapi.ProtectionConfidentiality, gssapi.ProtectionSelective:
+	default:
+		return 0, fmt.Errorf("unsupported GSS-API protection level: %d", level)
+	}
+
+	reply, err := secCtx.Wrap([]byte{level}, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to wrap GSS-API protection level: %v", err)
+	}
+
+	if err := gssapi.WriteMessage(rep, gssapi.MessageProtection, reply); err != nil {
+		return 0, fmt.Errorf("failed to send GSS-API protection level: %v", err)
+	}
+
+	return level, nil
+}
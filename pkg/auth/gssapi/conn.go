@@ -0,0 +1,81 @@
+package gssapi
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// maxChunkLength leaves room for the mechanism overhead when a chunk is wrapped into a single token.
+const maxChunkLength = 32 * 1024
+
+// Conn encapsulates every message of the connection with the established security context,
+// once integrity or confidentiality protection is negotiated.
+type Conn struct {
+	net.Conn
+
+	secCtx Context
+	conf   bool
+
+	readMu sync.Mutex
+	buf    []byte
+
+	writeMu sync.Mutex
+}
+
+// NewConn wraps the connection, conf tells whether outgoing messages are encrypted.
+func NewConn(conn net.Conn, secCtx Context, conf bool) *Conn {
+	return &Conn{
+		Conn:   conn,
+		secCtx: secCtx,
+		conf:   conf,
+	}
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.buf) == 0 {
+		mtyp, token, err := ReadMessage(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+
+		if mtyp != MessageEncapsulation {
+			return 0, fmt.Errorf("%w: %d", ErrUnexpectedMessage, mtyp)
+		}
+
+		c.buf, err = c.secCtx.Unwrap(token)
+		if err != nil {
+			return 0, fmt.Errorf("failed to unwrap GSS-API message: %v", err)
+		}
+	}
+
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		chunk := b[written:min(len(b), written+maxChunkLength)]
+
+		token, err := c.secCtx.Wrap(chunk, c.conf)
+		if err != nil {
+			return written, fmt.Errorf("failed to wrap GSS-API message: %v", err)
+		}
+
+		if err := WriteMessage(c.Conn, MessageEncapsulation, token); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+	}
+
+	return written, nil
+}
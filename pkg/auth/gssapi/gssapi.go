@@ -0,0 +1,114 @@
+// Package gssapi implements the SOCKS V5 GSS-API sub-negotiation framing,
+// on top of a pluggable GSS-API mechanism.
+//
+// Reference: https://datatracker.ietf.org/doc/html/rfc1961
+package gssapi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Version is the version of the GSS-API sub-negotiation messages.
+const Version = uint8(1)
+
+const (
+	// Message types
+	MessageAuth          = uint8(1)   // 0x01, context establishment token
+	MessageProtection    = uint8(2)   // 0x02, protection level negotiation
+	MessageEncapsulation = uint8(3)   // 0x03, per-message encapsulation
+	MessageAbort         = uint8(255) // 0xFF, context establishment failure
+)
+
+const (
+	// Protection levels
+	ProtectionIntegrity       = uint8(1) // required per-message integrity
+	ProtectionConfidentiality = uint8(2) // required per-message integrity and confidentiality
+	ProtectionSelective       = uint8(3) // selective per-message integrity or confidentiality
+)
+
+// MaxTokenLength is the largest token a single message is able to carry.
+const MaxTokenLength = 0xFFFF
+
+var (
+	ErrAborted            = fmt.Errorf("GSS-API context establishment aborted by peer")
+	ErrUnexpectedMessage  = fmt.Errorf("unexpected GSS-API message type")
+	ErrUnsupportedVersion = fmt.Errorf("unsupported GSS-API message version")
+)
+
+// Mechanism creates acceptor security contexts, such as Kerberos V5.
+type Mechanism interface {
+	NewContext() (Context, error)
+}
+
+// Context is an acceptor (server side) GSS-API security context.
+type Context interface {
+	// Accept consumes a context establishment token sent by the client,
+	// it returns the token to be sent back and whether the context is established.
+	Accept(token []byte) (output []byte, established bool, err error)
+
+	// Principal returns the authenticated client principal, once the context is established.
+	Principal() string
+
+	// Wrap protects the message for the peer, encrypting it when conf is true (gss_wrap).
+	Wrap(msg []byte, conf bool) ([]byte, error)
+
+	// Unwrap verifies and decodes a message protected by the peer (gss_unwrap).
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// ReadMessage reads a single GSS-API sub-negotiation message.
+// +------+------+------+.......................+
+// + ver  | mtyp | len  |       token           |
+// +------+------+------+.......................+
+// + 0x01 | 0x01 | 0x02 | up to 2^16 - 1 octets |
+// +------+------+------+.......................+
+func ReadMessage(r io.Reader) (mtyp uint8, token []byte, err error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, fmt.Errorf("failed to read GSS-API message header: %v", err)
+	}
+
+	if header[0] != Version {
+		return 0, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header[0])
+	}
+
+	// The abort message carries neither length nor token
+	if header[1] == MessageAbort {
+		return MessageAbort, nil, nil
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return 0, nil, fmt.Errorf("failed to read GSS-API token length: %v", err)
+	}
+
+	token = make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, token); err != nil {
+		return 0, nil, fmt.Errorf("failed to read GSS-API token: %v", err)
+	}
+
+	return header[1], token, nil
+}
+
+// WriteMessage writes a single GSS-API sub-negotiation message.
+func WriteMessage(w io.Writer, mtyp uint8, token []byte) error {
+	if mtyp == MessageAbort {
+		_, err := w.Write([]byte{Version, MessageAbort})
+		return err
+	}
+
+	if len(token) > MaxTokenLength {
+		return fmt.Errorf("GSS-API token exceeds %d bytes", MaxTokenLength)
+	}
+
+	msg := make([]byte, 4+len(token))
+	msg[0] = Version
+	msg[1] = mtyp
+	binary.BigEndian.PutUint16(msg[2:], uint16(len(token)))
+	copy(msg[4:], token)
+
+	_, err := w.Write(msg)
+	return err
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/ardikabs/socks5/pkg/auth/gssapi"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGSSAPIAuthenticator(t *testing.T) {
+	mech := testMechanism{Key: []byte("secret")}
+	auth := &gssapiAuthenticator{mech: mech}
+
+	t.Run("established with confidentiality", func(t *testing.T) {
+		srvConn, cliConn := net.Pipe()
+		defer srvConn.Close()
+		defer cliConn.Close()
+
+		initiator := mech.NewInitiator("alice@EXAMPLE.COM")
+
+		go func() {
+			require.NoError(t, gssapi.WriteMessage(cliConn, gssapi.MessageAuth, initiator.InitialToken()))
+
+			mtyp, token, err := gssapi.ReadMessage(cliConn)
+			require.NoError(t, err)
+			require.Equal(t, gssapi.MessageAuth, mtyp)
+			require.NoError(t, initiator.Complete(token))
+
+			level, err := initiator.Wrap([]byte{gssapi.ProtectionConfidentiality}, false)
+			require.NoError(t, err)
+			require.NoError(t, gssapi.WriteMessage(cliConn, gssapi.MessageProtection, level))
+
+			mtyp, token, err = gssapi.ReadMessage(cliConn)
+			require.NoError(t, err)
+			require.Equal(t, gssapi.MessageProtection, mtyp)
+
+			level, err = initiator.Unwrap(token)
+			require.NoError(t, err)
+			require.Equal(t, []byte{gssapi.ProtectionConfidentiality}, level)
+
+			// Every message after the negotiation is encapsulated
+			wrapped := gssapi.NewConn(cliConn, initiator, true)
+			_, err = wrapped.Write([]byte("ping"))
+			require.NoError(t, err)
+		}()
+
+		authCtx, err := auth.Authenticate(context.TODO(), srvConn, srvConn)
+		require.NoError(t, err)
+		require.Equal(t, types.AuthGSSAPI, authCtx.Method)
+		require.Equal(t, "alice@EXAMPLE.COM", authCtx.Payload["principal"])
+		require.NotNil(t, authCtx.Wrap)
+
+		out := make([]byte, 4)
+		_, err = io.ReadFull(authCtx.Wrap(srvConn), out)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(out))
+	})
+
+	t.Run("aborted on invalid token", func(t *testing.T) {
+		srvConn, cliConn := net.Pipe()
+		defer srvConn.Close()
+		defer cliConn.Close()
+
+		initiator := testMechanism{Key: []byte("wrong")}.NewInitiator("mallory@EXAMPLE.COM")
+
+		go func() {
+			require.NoError(t, gssapi.WriteMessage(cliConn, gssapi.MessageAuth, initiator.InitialToken()))
+
+			mtyp, _, err := gssapi.ReadMessage(cliConn)
+			require.NoError(t, err)
+			require.Equal(t, gssapi.MessageAbort, mtyp)
+		}()
+
+		authCtx, err := auth.Authenticate(context.TODO(), srvConn, srvConn)
+		require.Error(t, err)
+		require.Nil(t, authCtx)
+	})
+}
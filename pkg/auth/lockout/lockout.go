@@ -0,0 +1,252 @@
+// Package lockout guards password authentication against brute-force and credential-stuffing,
+// by locking out the source IPs and usernames with too many recent failures.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+var (
+	ErrLockedOut = fmt.Errorf("too many failed authentication attempts")
+)
+
+const (
+	DefaultMaxFailures = 5
+	DefaultWindow      = time.Minute
+	DefaultLockout     = 30 * time.Second
+	DefaultMaxLockout  = time.Hour
+)
+
+// Config is a configuration for the Guard, zero fields take their default value.
+type Config struct {
+	// MaxFailures is how many failures within the Window lock out a source IP or a username.
+	// It defaults to DefaultMaxFailures.
+	MaxFailures int
+
+	// Window is the sliding window the failures are counted in, it defaults to DefaultWindow.
+	Window time.Duration
+
+	// Lockout is the duration of the first lockout, it doubles on every following lockout
+	// up to MaxLockout. It defaults to DefaultLockout.
+	Lockout time.Duration
+
+	// MaxLockout caps the lockout duration. The backoff starts over once there was no lockout for as long.
+	// It defaults to DefaultMaxLockout.
+	MaxLockout time.Duration
+
+	// Tarpit delays the rejection of locked out clients, slowing them down without holding a slot of the Storer.
+	Tarpit time.Duration
+
+	// Logger logs the lockout and unlock events.
+	Logger logr.Logger
+}
+
+// Guard tracks the authentication failures per source IP and per username.
+type Guard struct {
+	cfg Config
+	now func() time.Time
+
+	mu       sync.Mutex
+	trackers map[subject]*tracker
+}
+
+// subject is what a tracker counts the failures of, either a source IP or a username.
+type subject struct {
+	kind  string
+	value string
+}
+
+type tracker struct {
+	failures    []time.Time
+	lockedUntil time.Time
+	strikes     int
+	lastLockout time.Time
+}
+
+func New(cfg Config) *Guard {
+	if cfg.MaxFailures <= 0 {
+		cfg.MaxFailures = DefaultMaxFailures
+	}
+
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+
+	if cfg.Lockout <= 0 {
+		cfg.Lockout = DefaultLockout
+	}
+
+	if cfg.MaxLockout <= 0 {
+		cfg.MaxLockout = DefaultMaxLockout
+	}
+
+	if cfg.Logger.IsZero() {
+		cfg.Logger = logr.Discard()
+	}
+
+	return &Guard{
+		cfg:      cfg,
+		now:      time.Now,
+		trackers: make(map[subject]*tracker),
+	}
+}
+
+// Allow returns ErrLockedOut after the tarpit delay when either the source IP or the username is locked out.
+// It is meant to be called before the credentials are validated, an empty IP or username is not checked.
+func (g *Guard) Allow(ctx context.Context, ip, username string) error {
+	remaining := g.lockedFor(ip, username)
+	if remaining <= 0 {
+		return nil
+	}
+
+	if g.cfg.Tarpit > 0 {
+		t := time.NewTimer(g.cfg.Tarpit)
+		defer t.Stop()
+
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+	}
+
+	return fmt.Errorf("%w, retry in %s", ErrLockedOut, remaining.Round(time.Second))
+}
+
+// Failure records a failed attempt, locking out the source IP or the username once it reaches MaxFailures.
+func (g *Guard) Failure(ip, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for _, s := range subjects(ip, username) {
+		t, ok := g.trackers[s]
+		if !ok {
+			t = new(tracker)
+			g.trackers[s] = t
+		}
+
+		t.failures = append(t.pruned(now, g.cfg.Window), now)
+		if len(t.failures) < g.cfg.MaxFailures {
+			continue
+		}
+
+		// The backoff starts over after a quiet period as long as the longest lockout
+		if !t.lastLockout.IsZero() && now.Sub(t.lastLockout) > g.cfg.MaxLockout {
+			t.strikes = 0
+		}
+
+		t.strikes++
+		duration := g.cfg.Lockout << min(t.strikes-1, 30)
+		if duration <= 0 || duration > g.cfg.MaxLockout {
+			duration = g.cfg.MaxLockout
+		}
+
+		t.failures = nil
+		t.lockedUntil = now.Add(duration)
+		t.lastLockout = t.lockedUntil
+
+		g.cfg.Logger.Info("locked out after too many failed authentication attempts",
+			s.kind, s.value, "duration", duration.String(), "strikes", t.strikes)
+	}
+}
+
+// Success clears the recent failures of the source IP and the username, the backoff is kept.
+func (g *Guard) Success(ip, username string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, s := range subjects(ip, username) {
+		if t, ok := g.trackers[s]; ok {
+			t.failures = nil
+		}
+	}
+}
+
+// Run periodically logs the expired lockouts and forgets the idle subjects, until the context is done.
+func (g *Guard) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.cfg.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.sweep()
+		}
+	}
+}
+
+func (g *Guard) sweep() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	for s, t := range g.trackers {
+		g.expire(s, t, now)
+
+		t.failures = t.pruned(now, g.cfg.Window)
+		idle := t.lastLockout.IsZero() || now.Sub(t.lastLockout) > g.cfg.MaxLockout
+		if len(t.failures) == 0 && t.lockedUntil.IsZero() && idle {
+			delete(g.trackers, s)
+		}
+	}
+}
+
+func (g *Guard) lockedFor(ip, username string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+
+	var remaining time.Duration
+	for _, s := range subjects(ip, username) {
+		t, ok := g.trackers[s]
+		if !ok {
+			continue
+		}
+
+		g.expire(s, t, now)
+		remaining = max(remaining, t.lockedUntil.Sub(now))
+	}
+
+	return remaining
+}
+
+// expire lifts the lockout of the tracker once it is over.
+func (g *Guard) expire(s subject, t *tracker, now time.Time) {
+	if t.lockedUntil.IsZero() || now.Before(t.lockedUntil) {
+		return
+	}
+
+	t.lockedUntil = time.Time{}
+	g.cfg.Logger.Info("lockout expired", s.kind, s.value, "strikes", t.strikes)
+}
+
+// pruned returns the failures within the sliding window.
+func (t *tracker) pruned(now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(t.failures) && now.Sub(t.failures[i]) >= window {
+		i++
+	}
+
+	return t.failures[i:]
+}
+
+func subjects(ip, username string) []subject {
+	s := make([]subject, 0, 2)
+	if ip != "" {
+		s = append(s, subject{kind: "ip", value: ip})
+	}
+
+	if username != "" {
+		s = append(s, subject{kind: "username", value: username})
+	}
+
+	return s
+}
@@ -0,0 +1,162 @@
+package lockout
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func (c *clock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newGuard(cfg Config) (*Guard, *clock, *[]string) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+
+	cfg.Logger = funcr.New(func(prefix, args string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, args)
+	}, funcr.Options{})
+
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	g := New(cfg)
+	g.now = c.Now
+
+	return g, c, &events
+}
+
+func TestGuard_LockoutPerIP(t *testing.T) {
+	g, c, events := newGuard(Config{MaxFailures: 3, Window: time.Minute, Lockout: 10 * time.Second})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, g.Allow(ctx, "192.0.2.1", "alice"))
+		g.Failure("192.0.2.1", "user"+string(rune('a'+i)))
+	}
+
+	require.NoError(t, g.Allow(ctx, "192.0.2.1", "carol"))
+	g.Failure("192.0.2.1", "carol")
+
+	require.ErrorIs(t, g.Allow(ctx, "192.0.2.1", "dave"), ErrLockedOut)
+	require.NoError(t, g.Allow(ctx, "192.0.2.2", "dave"))
+	assert.Contains(t, (*events)[len(*events)-1], `"ip"="192.0.2.1"`)
+
+	c.Advance(10 * time.Second)
+	require.NoError(t, g.Allow(ctx, "192.0.2.1", "dave"))
+	assert.Contains(t, (*events)[len(*events)-1], "lockout expired")
+}
+
+func TestGuard_LockoutPerUsername(t *testing.T) {
+	g, _, _ := newGuard(Config{MaxFailures: 3})
+	ctx := context.Background()
+
+	// Credential stuffing spread over many source IPs
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		g.Failure(ip, "alice")
+	}
+
+	require.ErrorIs(t, g.Allow(ctx, "192.0.2.4", "alice"), ErrLockedOut)
+	require.NoError(t, g.Allow(ctx, "192.0.2.4", "bob"))
+}
+
+func TestGuard_SlidingWindow(t *testing.T) {
+	g, c, _ := newGuard(Config{MaxFailures: 3, Window: time.Minute})
+	ctx := context.Background()
+
+	g.Failure("192.0.2.1", "")
+	c.Advance(40 * time.Second)
+	g.Failure("192.0.2.1", "")
+	c.Advance(40 * time.Second)
+
+	// The first failure is out of the window
+	g.Failure("192.0.2.1", "")
+	require.NoError(t, g.Allow(ctx, "192.0.2.1", ""))
+
+	g.Failure("192.0.2.1", "")
+	require.ErrorIs(t, g.Allow(ctx, "192.0.2.1", ""), ErrLockedOut)
+}
+
+func TestGuard_Success(t *testing.T) {
+	g, _, _ := newGuard(Config{MaxFailures: 2})
+	ctx := context.Background()
+
+	g.Failure("192.0.2.1", "alice")
+	g.Success("192.0.2.1", "alice")
+	g.Failure("192.0.2.1", "alice")
+	require.NoError(t, g.Allow(ctx, "192.0.2.1", "alice"))
+}
+
+func TestGuard_Backoff(t *testing.T) {
+	g, c, events := newGuard(Config{MaxFailures: 1, Lockout: 10 * time.Second, MaxLockout: 30 * time.Second})
+	ctx := context.Background()
+
+	for _, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		g.Failure("", "alice")
+		assert.Contains(t, (*events)[len(*events)-1], `"duration"="`+expected.String()+`"`)
+
+		c.Advance(expected - time.Second)
+		require.ErrorIs(t, g.Allow(ctx, "", "alice"), ErrLockedOut)
+
+		c.Advance(time.Second)
+		require.NoError(t, g.Allow(ctx, "", "alice"))
+	}
+
+	// The backoff starts over after a quiet period
+	c.Advance(time.Minute)
+	g.Failure("", "alice")
+	assert.Contains(t, (*events)[len(*events)-1], `"duration"="10s"`)
+}
+
+func TestGuard_Tarpit(t *testing.T) {
+	g, _, _ := newGuard(Config{MaxFailures: 1, Tarpit: 50 * time.Millisecond})
+
+	g.Failure("192.0.2.1", "")
+
+	start := time.Now()
+	require.ErrorIs(t, g.Allow(context.Background(), "192.0.2.1", ""), ErrLockedOut)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// The tarpit gives up with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start = time.Now()
+	require.ErrorIs(t, g.Allow(ctx, "192.0.2.1", ""), ErrLockedOut)
+	assert.Less(t, time.Since(start), 50*time.Millisecond)
+}
+
+func TestGuard_Sweep(t *testing.T) {
+	g, c, events := newGuard(Config{MaxFailures: 1, Lockout: 10 * time.Second, MaxLockout: 10 * time.Second})
+
+	g.Failure("192.0.2.1", "")
+	g.Failure("192.0.2.2", "")
+
+	c.Advance(10 * time.Second)
+	g.sweep()
+
+	var unlocks int
+	for _, e := range *events {
+		if strings.Contains(e, "lockout expired") {
+			unlocks++
+		}
+	}
+	assert.Equal(t, 2, unlocks)
+
+	c.Advance(time.Minute)
+	g.sweep()
+	assert.Empty(t, g.trackers)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"github.com/ardikabs/socks5/pkg/auth/gssapi"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
+)
+
+type Option func(*options)
+
+type options struct {
+	gssapiMech gssapi.Mechanism
+	registry   *Registry
+	lockout    *lockout.Guard
+}
+
+// WithGSSAPI enables the GSSAPI method with the given mechanism,
+// the method is never selected without a mechanism.
+func WithGSSAPI(mech gssapi.Mechanism) Option {
+	return func(o *options) {
+		o.gssapiMech = mech
+	}
+}
+
+// WithRegistry makes the methods registered in the registry available for selection.
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithLockout guards the USERNAME/PASSWORD method against brute-force, locked out clients are rejected
+// before their credentials are validated.
+func WithLockout(g *lockout.Guard) Option {
+	return func(o *options) {
+		o.lockout = g
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 
 	"github.com/ardikabs/socks5/pkg/auth/credentials"
 	"github.com/ardikabs/socks5/pkg/types"
@@ -18,13 +19,25 @@ type AuthPayload map[string]interface{}
 type AuthContext struct {
 	Method  types.AuthMethod
 	Payload AuthPayload
+
+	// Identity is the identity of the authenticated user, it is nil for anonymous clients.
+	Identity *credentials.Identity
+
+	// Wrap wraps the client connection once the authentication completes,
+	// it is set by methods that encapsulate the rest of the session, such as GSSAPI.
+	Wrap func(net.Conn) net.Conn
 }
 
 type Authenticator interface {
 	Authenticate(ctx context.Context, req io.Reader, rep io.Writer) (*AuthContext, error)
 }
 
-func Parse(rw io.ReadWriter, enabledAuthMethods []types.AuthMethod, cs credentials.Storer) (Authenticator, error) {
+func Parse(rw io.ReadWriter, enabledAuthMethods []types.AuthMethod, cs credentials.Storer, opts ...Option) (Authenticator, error) {
+	o := new(options)
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	nMethods := []byte{0}
 
 	if _, err := rw.Read(nMethods); err != nil {
@@ -37,7 +50,7 @@ func Parse(rw io.ReadWriter, enabledAuthMethods []types.AuthMethod, cs credentia
 		return nil, fmt.Errorf("failed to fetch SOCKS auth methods: %v", err)
 	}
 
-	chosen, authn := factory(enabledAuthMethods, offeredMethods, cs)
+	chosen, authn := factory(enabledAuthMethods, offeredMethods, cs, o)
 	if _, err := rw.Write([]byte{types.VERSION, byte(chosen)}); err != nil {
 		return nil, err
 	}
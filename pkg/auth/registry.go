@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+var (
+	ErrMethodRegistered = fmt.Errorf("authentication method already registered")
+	ErrMethodReserved   = fmt.Errorf("authentication method is reserved")
+	ErrNoAuthContext    = fmt.Errorf("authentication method returned no authentication context")
+)
+
+// Constructor creates the Authenticator for a client that negotiated the method.
+type Constructor func() Authenticator
+
+// Registry maps authentication method codes to authenticator constructors,
+// allowing custom methods such as the ones in the private range (0x80-0xFE).
+// Registered methods take precedence over the built-in ones.
+type Registry struct {
+	mu           sync.RWMutex
+	constructors map[types.AuthMethod]Constructor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		constructors: make(map[types.AuthMethod]Constructor),
+	}
+}
+
+// Register adds the constructor for the method, a method can only be registered once.
+func (r *Registry) Register(method types.AuthMethod, c Constructor) error {
+	if method == types.AuthNoAcceptableMethod {
+		return fmt.Errorf("%w: %s", ErrMethodReserved, method)
+	}
+
+	if c == nil {
+		return fmt.Errorf("nil constructor for authentication method: %s", method)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.constructors[method]; exists {
+		return fmt.Errorf("%w: %s", ErrMethodRegistered, method)
+	}
+
+	r.constructors[method] = c
+	return nil
+}
+
+// MustRegister is like Register but panics if the method can't be registered.
+func (r *Registry) MustRegister(method types.AuthMethod, c Constructor) {
+	if err := r.Register(method, c); err != nil {
+		panic(err)
+	}
+}
+
+// registeredAuthenticator fails the authentication when a registered authenticator
+// returns neither an AuthContext nor an error, rather than leaving the server with a nil context.
+type registeredAuthenticator struct {
+	Authenticator
+	method types.AuthMethod
+}
+
+func (a registeredAuthenticator) Authenticate(ctx context.Context, req io.Reader, rep io.Writer) (*AuthContext, error) {
+	if a.Authenticator == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoAuthContext, a.method)
+	}
+
+	authCtx, err := a.Authenticator.Authenticate(ctx, req, rep)
+	if err == nil && authCtx == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoAuthContext, a.method)
+	}
+
+	return authCtx, err
+}
+
+// Lookup returns the constructor of the method, if registered.
+func (r *Registry) Lookup(method types.AuthMethod) (Constructor, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, ok := r.constructors[method]
+	return c, ok
+}
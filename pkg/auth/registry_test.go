@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+type staticAuthenticator struct {
+	method types.AuthMethod
+}
+
+func (a *staticAuthenticator) Authenticate(_ context.Context, req io.Reader, rep io.Writer) (*AuthContext, error) {
+	return &AuthContext{Method: a.method}, nil
+}
+
+type nilAuthenticator struct{}
+
+func (nilAuthenticator) Authenticate(context.Context, io.Reader, io.Writer) (*AuthContext, error) {
+	return nil, nil
+}
+
+func TestRegistry(t *testing.T) {
+	privateMethod := types.AuthMethod(0x80)
+
+	r := NewRegistry()
+	require.NoError(t, r.Register(privateMethod, func() Authenticator {
+		return &staticAuthenticator{method: privateMethod}
+	}))
+
+	t.Run("should not register the same method twice", func(t *testing.T) {
+		err := r.Register(privateMethod, func() Authenticator { return &guestAuthenticator{} })
+		require.ErrorIs(t, err, ErrMethodRegistered)
+	})
+
+	t.Run("should not register 'NO ACCEPTABLE METHODS'", func(t *testing.T) {
+		err := r.Register(types.AuthNoAcceptableMethod, func() Authenticator { return &guestAuthenticator{} })
+		require.ErrorIs(t, err, ErrMethodReserved)
+	})
+
+	t.Run("parse registered private method", func(t *testing.T) {
+		rw := bytes.NewBuffer(nil)
+		rw.Write([]byte{0x02, 0x00, byte(privateMethod)})
+
+		authn, err := Parse(rw, []types.AuthMethod{privateMethod, types.AuthNoAuthRequired}, nil, WithRegistry(r))
+		require.NoError(t, err)
+		require.Equal(t, []byte{types.VERSION, byte(privateMethod)}, rw.Bytes())
+
+		authCtx, err := authn.Authenticate(context.TODO(), rw, rw)
+		require.NoError(t, err)
+		require.Equal(t, privateMethod, authCtx.Method)
+	})
+
+	t.Run("registered method returning no context fails the authentication", func(t *testing.T) {
+		nilMethod := types.AuthMethod(0x81)
+		require.NoError(t, r.Register(nilMethod, func() Authenticator { return nilAuthenticator{} }))
+
+		rw := bytes.NewBuffer(nil)
+		rw.Write([]byte{0x01, byte(nilMethod)})
+
+		authn, err := Parse(rw, []types.AuthMethod{nilMethod}, nil, WithRegistry(r))
+		require.NoError(t, err)
+
+		authCtx, err := authn.Authenticate(context.TODO(), rw, rw)
+		require.ErrorIs(t, err, ErrNoAuthContext)
+		require.Nil(t, authCtx)
+	})
+
+	t.Run("registered method is not selected unless enabled", func(t *testing.T) {
+		rw := bytes.NewBuffer(nil)
+		rw.Write([]byte{0x01, byte(privateMethod)})
+
+		authn, err := Parse(rw, []types.AuthMethod{types.AuthNoAuthRequired}, nil, WithRegistry(r))
+		require.NoError(t, err)
+		require.IsType(t, new(notAcceptableAuthenticator), authn)
+	})
+}
@@ -0,0 +1,235 @@
+// Package client implements a SOCKS5 client that dials out through a SOCKS5 server.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/types"
+	"golang.org/x/net/proxy"
+)
+
+var (
+	ErrNoAcceptableMethod = errors.New("SOCKS server accepted none of the offered authentication methods")
+	ErrAuthFailed         = errors.New("SOCKS server rejected the credentials")
+	ErrUnsupportedNetwork = errors.New("unsupported network")
+)
+
+var (
+	_ proxy.Dialer        = (*Dialer)(nil)
+	_ proxy.ContextDialer = (*Dialer)(nil)
+)
+
+// DialFunc is a function that dials the SOCKS server.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dialer dials the target address through a SOCKS5 server, using the CONNECT command.
+type Dialer struct {
+	address  string
+	username string
+	password string
+	dial     DialFunc
+}
+
+// New creates a Dialer for the SOCKS5 server listening on the given address.
+func New(address string, opts ...Option) *Dialer {
+	d := &Dialer{
+		address: address,
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address through the SOCKS5 server,
+// the returned connection is ready to carry the application traffic.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedNetwork, network)
+	}
+
+	dstAddr, err := types.ParseAddress(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target address: %v", err)
+	}
+
+	conn, err := d.dial(ctx, "tcp", d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial SOCKS server: %v", err)
+	}
+
+	if _, err := d.Handshake(ctx, conn, types.CommandConnect, dstAddr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// Handshake negotiates the authentication method and sends the request over an established connection,
+// it returns the BND.ADDR the server replied with.
+func (d *Dialer) Handshake(ctx context.Context, conn net.Conn, cmd types.CommandID, dstAddr *types.Address) (*types.Address, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unblock the handshake when the context is cancelled, the deadline is only cleared
+	// once the callback has returned so it can't be set again afterwards
+	cancelled := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+		close(cancelled)
+	})
+	defer func() {
+		if !stop() {
+			<-cancelled
+		}
+		conn.SetDeadline(time.Time{})
+	}()
+
+	bindAddr, err := d.handshake(conn, cmd, dstAddr)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return bindAddr, err
+}
+
+func (d *Dialer) handshake(conn net.Conn, cmd types.CommandID, dstAddr *types.Address) (*types.Address, error) {
+	// DST.ADDR carries the length of a domain name in a single byte
+	if len(dstAddr.DomainName) > 255 {
+		return nil, errors.New("domain name must not exceed 255 bytes")
+	}
+
+	if err := d.negotiate(conn); err != nil {
+		return nil, err
+	}
+
+	// Request
+	// +----+-----+-------+------+----------+----------+
+	// |VER | CMD |  RSV  | ATYP | DST.ADDR | DST.PORT |
+	// +----+-----+-------+------+----------+----------+
+	// | 1  |  1  | X'00' |  1   | Variable |    2     |
+	// +----+-----+-------+------+----------+----------+
+	req := append([]byte{types.VERSION, byte(cmd), 0x00}, dstAddr.Bytes()...)
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("failed to send SOCKS request: %v", err)
+	}
+
+	return ReadReply(conn)
+}
+
+func (d *Dialer) negotiate(conn net.Conn) error {
+	methods := []byte{byte(types.AuthNoAuthRequired)}
+	if d.username != "" {
+		methods = append(methods, byte(types.AuthUserPass))
+	}
+
+	msg := append([]byte{types.VERSION, byte(len(methods))}, methods...)
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send SOCKS auth methods: %v", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS method selection: %v", err)
+	}
+
+	if reply[0] != types.VERSION {
+		return fmt.Errorf("%w: %d", types.ErrUnsupportedVersion, reply[0])
+	}
+
+	switch method := types.AuthMethod(reply[1]); method {
+	case types.AuthNoAuthRequired:
+		return nil
+	case types.AuthUserPass:
+		if d.username == "" {
+			return fmt.Errorf("SOCKS server selected a method that was not offered: %s", method)
+		}
+
+		return d.authenticate(conn)
+	case types.AuthNoAcceptableMethod:
+		return ErrNoAcceptableMethod
+	default:
+		return fmt.Errorf("SOCKS server selected a method that was not offered: %s", method)
+	}
+}
+
+func (d *Dialer) authenticate(conn net.Conn) error {
+	// Reference: https://datatracker.ietf.org/doc/html/rfc1929
+	// +----+------+----------+------+----------+
+	// |VER | ULEN |  UNAME   | PLEN |  PASSWD  |
+	// +----+------+----------+------+----------+
+	// | 1  |  1   | 1 to 255 |  1   | 1 to 255 |
+	// +----+------+----------+------+----------+
+	if len(d.username) > 255 || len(d.password) > 255 {
+		return errors.New("username and password must not exceed 255 bytes")
+	}
+
+	msg := make([]byte, 0, 3+len(d.username)+len(d.password))
+	msg = append(msg, types.UserPassVersion, byte(len(d.username)))
+	msg = append(msg, d.username...)
+	msg = append(msg, byte(len(d.password)))
+	msg = append(msg, d.password...)
+
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send user/pass auth: %v", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read user/pass auth reply: %v", err)
+	}
+
+	if reply[0] != types.UserPassVersion {
+		return fmt.Errorf("%w: %d", types.ErrUnsupportedUserPassAuthVersion, reply[0])
+	}
+
+	if reply[1] != 0x00 {
+		return ErrAuthFailed
+	}
+
+	return nil
+}
+
+// ReadReply reads the SOCKS5 reply, a failure reply code is returned as *types.ReplyError.
+// +----+-----+-------+------+----------+----------+
+// |VER | REP |  RSV  | ATYP | BND.ADDR | BND.PORT |
+// +----+-----+-------+------+----------+----------+
+// | 1  |  1  | X'00' |  1   | Variable |    2     |
+// +----+-----+-------+------+----------+----------+
+func ReadReply(r io.Reader) (*types.Address, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read SOCKS reply: %v", err)
+	}
+
+	if header[0] != types.VERSION {
+		return nil, fmt.Errorf("%w: %d", types.ErrUnsupportedVersion, header[0])
+	}
+
+	if code := types.ReplyCode(header[1]); code != types.ReplySucceeded {
+		return nil, &types.ReplyError{Code: code}
+	}
+
+	return types.NewAddress(r)
+}
@@ -0,0 +1,126 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5"
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialer(t *testing.T) {
+	// Create dummy HTTP server
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	// Create SOCKS5 server
+	srvAddr := "127.0.0.1:20086"
+	srv, err := socks5.New(socks5.ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		UserPassMaps:       map[string]string{"user": "password"},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	t.Run("plugs into http.Transport", func(t *testing.T) {
+		d := client.New(srvAddr, client.WithUserPass("user", "password"))
+		httpClient := &http.Client{Transport: &http.Transport{DialContext: d.DialContext}}
+
+		resp, err := httpClient.Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(body))
+	})
+
+	t.Run("domain name target", func(t *testing.T) {
+		_, port, err := net.SplitHostPort(origin.Listener.Addr().String())
+		require.NoError(t, err)
+
+		d := client.New(srvAddr, client.WithUserPass("user", "password"))
+		conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("domain name too long", func(t *testing.T) {
+		d := client.New(srvAddr, client.WithUserPass("user", "password"))
+		_, err := d.Dial("tcp", net.JoinHostPort(strings.Repeat("a", 256), "80"))
+		require.ErrorContains(t, err, "domain name must not exceed 255 bytes")
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		d := client.New(srvAddr, client.WithUserPass("user", "badpassword"))
+		_, err := d.Dial("tcp", origin.Listener.Addr().String())
+		require.ErrorIs(t, err, client.ErrAuthFailed)
+	})
+
+	t.Run("no acceptable method", func(t *testing.T) {
+		d := client.New(srvAddr)
+		_, err := d.Dial("tcp", origin.Listener.Addr().String())
+		require.ErrorIs(t, err, client.ErrNoAcceptableMethod)
+	})
+
+	t.Run("connection refused", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedAddr := l.Addr().String()
+		l.Close()
+
+		d := client.New(srvAddr, client.WithUserPass("user", "password"))
+		_, err = d.Dial("tcp", closedAddr)
+
+		var repErr *types.ReplyError
+		require.ErrorAs(t, err, &repErr)
+		require.Equal(t, types.ReplyConnRefused, repErr.Code)
+	})
+
+	t.Run("handshake cancelled", func(t *testing.T) {
+		// A server that never answers the negotiation
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			_, _ = io.Copy(io.Discard, conn)
+		}()
+
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+
+		d := client.New(l.Addr().String())
+		_, err = d.Handshake(ctx, conn, types.CommandConnect, &types.Address{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+		require.ErrorIs(t, err, context.Canceled)
+
+		// The deadline set to unblock the handshake is cleared once it returns
+		_, err = conn.Write([]byte{types.VERSION})
+		require.NoError(t, err)
+	})
+}
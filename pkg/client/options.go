@@ -0,0 +1,22 @@
+package client
+
+type Option func(*Dialer)
+
+// WithUserPass authenticates with the USERNAME/PASSWORD method (RFC 1929).
+func WithUserPass(username, password string) Option {
+	return func(d *Dialer) {
+		d.username = username
+		d.password = password
+	}
+}
+
+// WithDialFunc sets the function used to reach the SOCKS server, such as another proxy.
+func WithDialFunc(dial DialFunc) Option {
+	return func(d *Dialer) {
+		if dial == nil {
+			return
+		}
+
+		d.dial = dial
+	}
+}
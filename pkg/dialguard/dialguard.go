@@ -0,0 +1,139 @@
+// Package dialguard keeps outbound connections away from special-purpose addresses, such as loopback,
+// private networks and cloud metadata endpoints, to prevent the proxy from being used for SSRF.
+//
+// The address is checked as it is being connected to, after any resolution, so a domain name
+// resolving to a public address at first and to a special-purpose one later (DNS rebinding) is still refused.
+package dialguard
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"github.com/ardikabs/socks5/pkg/request"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+var (
+	ErrBlocked = fmt.Errorf("destination is a special-purpose address")
+)
+
+// DefaultBlocked are the special-purpose ranges refused by default, from the IANA IPv4 and IPv6
+// special-purpose address registries, along with the ranges embedding an IPv4 address in IPv6.
+var DefaultBlocked = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("10.0.0.0/8"),      // private-use (RFC 1918)
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space (carrier-grade NAT)
+	netip.MustParsePrefix("127.0.0.0/8"),     // loopback
+	netip.MustParsePrefix("169.254.0.0/16"),  // link-local, including the 169.254.169.254 cloud metadata
+	netip.MustParsePrefix("172.16.0.0/12"),   // private-use (RFC 1918)
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation (TEST-NET-1)
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("192.168.0.0/16"),  // private-use (RFC 1918)
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation (TEST-NET-2)
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation (TEST-NET-3)
+	netip.MustParsePrefix("224.0.0.0/4"),     // multicast
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including the limited broadcast
+	netip.MustParsePrefix("::/96"),           // unspecified, loopback and the deprecated IPv4-compatible
+	netip.MustParsePrefix("64:ff9b::/96"),    // IPv4/IPv6 translation (NAT64)
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use IPv4/IPv6 translation
+	netip.MustParsePrefix("100::/64"),        // discard-only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("fc00::/7"),        // unique local
+	netip.MustParsePrefix("fe80::/10"),       // link-local
+	netip.MustParsePrefix("fec0::/10"),       // site-local, deprecated
+	netip.MustParsePrefix("ff00::/8"),        // multicast
+}
+
+// Config is a configuration for the Guard.
+type Config struct {
+	// Allow are the exceptions to the blocked ranges, such as an internal service the clients are meant to reach.
+	Allow []netip.Prefix
+
+	// Block are the ranges refused on top of the DefaultBlocked ones.
+	Block []netip.Prefix
+}
+
+// Guard refuses the connections to the blocked ranges, unless allowed by an exception.
+type Guard struct {
+	allow []netip.Prefix
+	block []netip.Prefix
+}
+
+func New(cfg Config) (*Guard, error) {
+	for _, p := range append(append([]netip.Prefix(nil), cfg.Allow...), cfg.Block...) {
+		if !p.IsValid() {
+			return nil, fmt.Errorf("dialguard: invalid network %q", p)
+		}
+	}
+
+	return &Guard{
+		allow: cfg.Allow,
+		block: append(append([]netip.Prefix(nil), DefaultBlocked...), cfg.Block...),
+	}, nil
+}
+
+// Check returns an error carrying types.ReplyNotAllowed when the address is blocked.
+func (g *Guard) Check(addr netip.Addr) error {
+	addr = addr.Unmap()
+
+	for _, p := range g.allow {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+
+	for _, p := range g.block {
+		if p.Contains(addr) {
+			return &types.ReplyError{
+				Code: types.ReplyNotAllowed,
+				Err:  fmt.Errorf("%w: %s is in %s", ErrBlocked, addr, p),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Control checks the address of the socket right before it connects, see net.Dialer.Control.
+func (g *Guard) Control(network, address string, _ syscall.RawConn) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return &types.ReplyError{Code: types.ReplyNotAllowed, Err: fmt.Errorf("%w: unexpected address %q", ErrBlocked, address)}
+	}
+
+	return g.Check(ap.Addr())
+}
+
+// Wrap guards a dialer whose connections can't be checked with Control before they are made, such as a custom one.
+// The address is checked before dialing when it is an IP address, and the remote address of the connection
+// once established, before it is handed over.
+func (g *Guard) Wrap(dialer request.Dialer) request.Dialer {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if ap, err := netip.ParseAddrPort(address); err == nil {
+			if err := g.Check(ap.Addr()); err != nil {
+				return nil, err
+			}
+		}
+
+		conn, err := dialer(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if ap, err := netip.ParseAddrPort(conn.RemoteAddr().String()); err == nil {
+			if err := g.Check(ap.Addr()); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+
+		return conn, nil
+	}
+}
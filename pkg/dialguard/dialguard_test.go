@@ -0,0 +1,108 @@
+package dialguard
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard_Check(t *testing.T) {
+	g, err := New(Config{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+		Block: []netip.Prefix{netip.MustParsePrefix("198.51.0.0/16")},
+	})
+	require.NoError(t, err)
+
+	for addr, blocked := range map[string]bool{
+		"127.0.0.1":        true,
+		"::1":              true,
+		"::ffff:127.0.0.1": true,
+		"169.254.169.254":  true,
+		"10.2.3.4":         true,
+		"172.20.0.1":       true,
+		"192.168.1.1":      true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"::":               true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"2002:7f00:1::":    true,
+		"198.51.7.7":       true,
+		"10.1.2.3":         false,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+	} {
+		t.Run(addr, func(t *testing.T) {
+			err := g.Check(netip.MustParseAddr(addr))
+			if !blocked {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrBlocked)
+
+			var repErr *types.ReplyError
+			require.ErrorAs(t, err, &repErr)
+			assert.Equal(t, types.ReplyNotAllowed, repErr.Code)
+		})
+	}
+
+	_, err = New(Config{Allow: []netip.Prefix{{}}})
+	require.Error(t, err)
+}
+
+func TestGuard_Dial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	g, err := New(Config{})
+	require.NoError(t, err)
+
+	t.Run("control", func(t *testing.T) {
+		d := net.Dialer{Control: g.Control}
+
+		// The name is resolved by the dialer, the resolved address is the one checked
+		_, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.ErrorIs(t, err, ErrBlocked)
+	})
+
+	t.Run("wrap", func(t *testing.T) {
+		var d net.Dialer
+		dial := g.Wrap(d.DialContext)
+
+		_, err := dial(context.Background(), "tcp", listener.Addr().String())
+		require.ErrorIs(t, err, ErrBlocked)
+
+		// The remote address is checked once connected
+		_, err = dial(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.ErrorIs(t, err, ErrBlocked)
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		g, err := New(Config{Allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32"), netip.MustParsePrefix("::1/128")}})
+		require.NoError(t, err)
+
+		d := net.Dialer{Control: g.Control}
+		conn, err := d.DialContext(context.Background(), "tcp", listener.Addr().String())
+		require.NoError(t, err)
+		conn.Close()
+	})
+}
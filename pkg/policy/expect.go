@@ -0,0 +1,169 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUnmetExpectation = fmt.Errorf("unmet expectation")
+)
+
+// Expectation is a query along with the decision the policy is expected to take on it.
+type Expectation struct {
+	Name  string
+	Query Query
+
+	Action acl.Action
+
+	// RuleID is the rule expected to take the decision, any rule is accepted when it is empty.
+	RuleID string
+
+	// Line is the line of the expectation in its file.
+	Line int
+}
+
+// LoadExpectations reads a file of expectations, as a list of queries along with their expected decision:
+//
+//   - name: alice reaches the wiki
+//     user: alice
+//     groups: [ops]                     # on top of the ones of the policy, optional
+//     client: 192.0.2.7                 # optional
+//     command: connect                  # defaults to connect
+//     destination: wiki.corp.example.com:443
+//     resolved_ip: 10.1.2.3             # optional
+//     time: 2030-01-01T10:00:00+07:00   # defaults to now
+//     expect: allow
+//     rule: ops-internal                # optional
+//
+// The file is validated as strictly as a policy file.
+func LoadExpectations(filename string) ([]Expectation, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: no expectations", filename)
+	}
+
+	d := &decoder{filename: filename}
+
+	var expectations []Expectation
+	d.sequence(doc.Content[0], "", func(n *yaml.Node, field string) {
+		expectations = append(expectations, d.expectation(n, field))
+	})
+
+	if len(d.errs) > 0 {
+		return nil, errors.Join(d.errs...)
+	}
+
+	return expectations, nil
+}
+
+func (d *decoder) expectation(n *yaml.Node, field string) Expectation {
+	e := Expectation{Query: Query{Command: types.CommandConnect}, Line: n.Line}
+
+	var resolvedIP net.IP
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"name":   func(n *yaml.Node, field string) { e.Name = d.str(n, field) },
+		"user":   func(n *yaml.Node, field string) { e.Query.User = d.str(n, field) },
+		"groups": func(n *yaml.Node, field string) { e.Query.Groups = d.groupRefs(n, field, new(references)) },
+		"client": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				d.errorf(n, field, "invalid IP address %q", s)
+			}
+
+			e.Query.Client = addr.Unmap()
+		},
+		"command": func(n *yaml.Node, field string) {
+			if cmd, ok := d.command(n, field); ok {
+				e.Query.Command = cmd
+			}
+		},
+		"destination": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			addr, err := types.ParseAddress(s)
+			if err != nil {
+				d.errorf(n, field, "invalid destination %q, expecting host:port", s)
+				return
+			}
+
+			e.Query.Destination = *addr
+		},
+		"resolved_ip": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			if resolvedIP = net.ParseIP(s); resolvedIP == nil {
+				d.errorf(n, field, "invalid IP address %q", s)
+			}
+		},
+		"time": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				d.errorf(n, field, "invalid time %q, expecting an RFC 3339 timestamp", s)
+			}
+
+			e.Query.Time = t
+		},
+		"expect": func(n *yaml.Node, field string) { e.Action = d.action(n, field) },
+		"rule":   func(n *yaml.Node, field string) { e.RuleID = d.str(n, field) },
+	}, "name", "destination", "expect")
+
+	if resolvedIP != nil {
+		e.Query.Destination.IP = resolvedIP
+	}
+
+	return e
+}
+
+// Test explains the query of the expectation, returning ErrUnmetExpectation when the decision is not the expected one.
+func (p *Policy) Test(e Expectation) (*Explanation, error) {
+	explanation, err := p.Explain(e.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	got := explanation.Decision
+	if got.Action != e.Action || (e.RuleID != "" && got.RuleID != e.RuleID) {
+		want := string(e.Action)
+		if e.RuleID != "" {
+			want = fmt.Sprintf("%s by rule %q", e.Action, e.RuleID)
+		}
+
+		return explanation, fmt.Errorf("%w: expecting %s, got %s by rule %q", ErrUnmetExpectation, want, got.Action, got.RuleID)
+	}
+
+	return explanation, nil
+}
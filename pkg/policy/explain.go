@@ -0,0 +1,176 @@
+package policy
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/ratelimit"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+// Query is a hypothetical request, to explain the decision of the policy on.
+type Query struct {
+	// User is the authenticated user, it is empty for an anonymous client.
+	User string
+
+	// Groups are the groups of the user on top of the ones declared in the policy,
+	// such as the ones reported by the credential store of the server.
+	Groups []string
+
+	Client  netip.Addr
+	Command types.CommandID
+
+	// Destination is the requested destination, along with the IP address its domain name resolves to when known.
+	Destination types.Address
+
+	// Time is when the request is made, it defaults to now.
+	Time time.Time
+}
+
+// Explanation is the decision of the policy on a query, along with the reasoning leading to it.
+type Explanation struct {
+	// Decision is the decision taken, its rule ID is empty when the request is denied at authentication,
+	// or allowed for the lack of access rules.
+	Decision acl.Decision
+
+	// Until is when the schedules of the access rules may change the decision, it is the zero time
+	// when the decision doesn't depend on the time.
+	Until time.Time
+
+	// Egress is the egress an allowed request goes through.
+	Egress string
+
+	Reasoning []string
+}
+
+// ParseCommand parses the name of a command, as in the rules of a policy file, such as 'connect' or 'udp_associate'.
+func ParseCommand(s string) (types.CommandID, error) {
+	cmd, ok := commands[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown command %q, expecting one of connect, bind, udp_associate, resolve or resolve_ptr", s)
+	}
+
+	return cmd, nil
+}
+
+// Explain evaluates the query the way the server evaluates a request, from the authentication of the user
+// to the access rules, the egress and the rate limit applied.
+func (p *Policy) Explain(q Query) (*Explanation, error) {
+	if q.Time.IsZero() {
+		q.Time = time.Now()
+	}
+
+	e := new(Explanation)
+	reason := func(format string, args ...interface{}) {
+		e.Reasoning = append(e.Reasoning, fmt.Sprintf(format, args...))
+	}
+
+	var identity *credentials.Identity
+	switch {
+	case q.User == "":
+		reason("the client is anonymous")
+	case len(p.Users) > 0:
+		u, ok := p.user(q.User)
+		if !ok {
+			reason("user %q is not declared in the policy, the authentication fails", q.User)
+			e.Decision = acl.Decision{Action: acl.ActionDeny}
+			return e, nil
+		}
+
+		if err := u.Account.Check(q.Time); err != nil {
+			reason("the account of user %q is refused at %s, %v", q.User, q.Time.Format(time.RFC3339), err)
+			e.Decision = acl.Decision{Action: acl.ActionDeny}
+			return e, nil
+		}
+
+		identity = &credentials.Identity{UserID: u.Username, Groups: append(append([]string(nil), u.Groups...), q.Groups...)}
+		reason("user %q is declared in the policy, with groups %v", identity.UserID, identity.Groups)
+	default:
+		identity = &credentials.Identity{UserID: q.User, Groups: q.Groups}
+		reason("no users are declared in the policy, user %q is authenticated by the server with groups %v", identity.UserID, identity.Groups)
+	}
+
+	req := acl.Request{Command: q.Command, Client: q.Client, Identity: identity, Destination: q.Destination, Time: q.Time}
+	if len(p.Rules) == 0 && p.Default == "" {
+		reason("no access rules are declared, every request is allowed")
+		e.Decision = acl.Decision{Action: acl.ActionAllow}
+	} else {
+		a, err := acl.New(p.Rules, p.Default)
+		if err != nil {
+			return nil, err
+		}
+
+		decision, reasons := a.Explain(req)
+		e.Decision = decision
+		e.Until = a.Until(req)
+		e.Reasoning = append(e.Reasoning, reasons...)
+	}
+
+	if !e.Decision.Allowed() {
+		return e, nil
+	}
+
+	e.Egress = "default"
+	if identity != nil {
+		for _, eg := range p.Egresses {
+			if containsAny(eg.Users, identity.UserID) || containsAny(eg.Groups, identity.Groups...) {
+				e.Egress = eg.Name
+				break
+			}
+		}
+	}
+	reason("the request goes through egress %q", e.Egress)
+
+	for i, r := range p.RateLimits {
+		if r.Matches(identity) {
+			reason("rate limit #%d applies, %s", i, describeRateLimit(r.Requests, r.Interval, r.Bandwidth))
+			return e, nil
+		}
+	}
+	reason("no rate limit applies")
+
+	return e, nil
+}
+
+func (p *Policy) user(name string) (credentials.User, bool) {
+	for _, u := range p.Users {
+		if u.Username == name {
+			return u, true
+		}
+	}
+
+	return credentials.User{}, false
+}
+
+func describeRateLimit(requests int, interval time.Duration, bandwidth int64) string {
+	var limits []string
+	if requests > 0 {
+		if interval == 0 {
+			interval = ratelimit.DefaultInterval
+		}
+
+		limits = append(limits, fmt.Sprintf("%d requests per %s", requests, interval))
+	}
+
+	if bandwidth > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes per second", bandwidth))
+	}
+
+	return strings.Join(limits, " and ")
+}
+
+func containsAny(list []string, values ...string) bool {
+	for _, l := range list {
+		for _, v := range values {
+			if l == v {
+				return true
+			}
+		}
+	}
+
+	return false
+}
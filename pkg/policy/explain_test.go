@@ -0,0 +1,157 @@
+package policy
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const explainPolicy = `version: 1
+groups:
+  - name: ops
+users:
+  - name: alice
+    password: "` + hash + `"
+    groups: [ops]
+  - name: bob
+    password: "` + hash + `"
+    expires: 2030-01-01
+rules:
+  - id: no-bind
+    action: deny
+    commands: [bind]
+  - id: ops-internal
+    action: allow
+    groups: [ops]
+    networks: [10.0.0.0/8]
+    domains: [.corp.example.com]
+default: deny
+rate_limits:
+  - groups: [ops]
+    requests: 100
+egresses:
+  - name: ops
+    groups: [ops]
+    source_ip: 192.0.2.10
+`
+
+func TestPolicy_Explain(t *testing.T) {
+	p, err := Parse("policy.yaml", []byte(explainPolicy))
+	require.NoError(t, err)
+
+	e, err := p.Explain(Query{
+		User:        "alice",
+		Command:     types.CommandConnect,
+		Destination: types.Address{DomainName: "example.com", IP: net.ParseIP("10.1.2.3"), Port: 443},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &Explanation{
+		Decision: acl.Decision{Action: acl.ActionAllow, RuleID: "ops-internal"},
+		Egress:   "ops",
+		Reasoning: []string{
+			`user "alice" is declared in the policy, with groups [ops]`,
+			`rule "no-bind" does not match, command CONNECT is not one of [BIND]`,
+			`rule "ops-internal" matches, the request is allowed`,
+			`the request goes through egress "ops"`,
+			`rate limit #0 applies, 100 requests per 1m0s`,
+		},
+	}, e)
+
+	e, err = p.Explain(Query{
+		User:        "bob",
+		Command:     types.CommandConnect,
+		Destination: types.Address{DomainName: "wiki.corp.example.com", Port: 443},
+		Time:        time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, acl.Decision{Action: acl.ActionDeny}, e.Decision)
+	assert.Equal(t, []string{`the account of user "bob" is refused at 2031-01-01T00:00:00Z, invalid credentials, account has expired`}, e.Reasoning)
+
+	e, err = p.Explain(Query{User: "carol", Destination: types.Address{DomainName: "wiki.corp.example.com", Port: 443}})
+	require.NoError(t, err)
+	assert.Equal(t, acl.Decision{Action: acl.ActionDeny}, e.Decision)
+
+	// Users of the credential store of the server are taken as authenticated
+	p.Users = nil
+	e, err = p.Explain(Query{User: "carol", Groups: []string{"ops"}, Command: types.CommandConnect, Destination: types.Address{DomainName: "wiki.corp.example.com", Port: 443}})
+	require.NoError(t, err)
+	assert.Equal(t, acl.Decision{Action: acl.ActionAllow, RuleID: "ops-internal"}, e.Decision)
+	assert.Equal(t, `no users are declared in the policy, user "carol" is authenticated by the server with groups [ops]`, e.Reasoning[0])
+}
+
+func TestPolicy_Test(t *testing.T) {
+	p, err := Parse("policy.yaml", []byte(explainPolicy))
+	require.NoError(t, err)
+
+	filename := filepath.Join(t.TempDir(), "expectations.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(`- name: alice reaches the wiki
+  user: alice
+  client: 192.0.2.7
+  destination: wiki.corp.example.com:443
+  time: 2029-12-31T23:00:00Z
+  expect: allow
+  rule: ops-internal
+- name: bob binds
+  user: bob
+  command: bind
+  destination: 10.1.2.3:22
+  expect: deny
+  rule: ops-internal
+- name: anonymous reaches an internal host
+  destination: wiki.corp.example.com:443
+  resolved_ip: 10.1.2.3
+  expect: allow
+`), 0o600))
+
+	expectations, err := LoadExpectations(filename)
+	require.NoError(t, err)
+	require.Len(t, expectations, 3)
+
+	assert.Equal(t, Expectation{
+		Name: "alice reaches the wiki",
+		Query: Query{
+			User:        "alice",
+			Client:      netip.MustParseAddr("192.0.2.7"),
+			Command:     types.CommandConnect,
+			Destination: types.Address{DomainName: "wiki.corp.example.com", Port: 443},
+			Time:        time.Date(2029, 12, 31, 23, 0, 0, 0, time.UTC),
+		},
+		Action: acl.ActionAllow,
+		RuleID: "ops-internal",
+		Line:   1,
+	}, expectations[0])
+	assert.Equal(t, net.ParseIP("10.1.2.3"), expectations[2].Query.Destination.IP)
+
+	_, err = p.Test(expectations[0])
+	require.NoError(t, err)
+
+	_, err = p.Test(expectations[1])
+	require.ErrorIs(t, err, ErrUnmetExpectation)
+	require.ErrorContains(t, err, `expecting deny by rule "ops-internal", got deny by rule "no-bind"`)
+
+	_, err = p.Test(expectations[2])
+	require.ErrorIs(t, err, ErrUnmetExpectation)
+
+	t.Run("invalid", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte(`- name: unknown command
+  command: listen
+  destination: example.com
+  expect: maybe
+  color: blue
+`), 0o600))
+
+		_, err := LoadExpectations(filename)
+		require.ErrorContains(t, err, filename+`:2:12: [0].command: unknown command "listen"`)
+		require.ErrorContains(t, err, filename+`:3:16: [0].destination: invalid destination "example.com"`)
+		require.ErrorContains(t, err, filename+`:4:11: [0].expect: unknown action "maybe"`)
+		require.ErrorContains(t, err, filename+`:5:3: [0].color: unknown field`)
+	})
+}
@@ -0,0 +1,858 @@
+// Package policy reads the users and groups, access rules, rate limits and egresses of the server
+// from a declarative YAML file, or a JSON one, so they can be reviewed as files rather than as code.
+//
+// A policy file looks like the following, every section is optional but the version:
+//
+//	version: 1
+//	groups:
+//	  - name: ops
+//	    description: Operations team
+//	users:
+//	  - name: alice
+//	    password: $2y$10$...        # a hash, in any of the formats of credentials.FileStore
+//	    groups: [ops]
+//	    expires: 2030-01-01
+//	    max_sessions: 2
+//	rules:
+//	  - id: ops-internal
+//	    action: allow
+//	    groups: [ops]
+//	    networks: [10.0.0.0/8]
+//	    ports: [22, 8000-8080]
+//	  - id: contractors-business-hours
+//	    action: allow
+//	    users: [bob]
+//	    schedules:
+//	      - timezone: Asia/Jakarta
+//	        weekdays: [mon-fri]
+//	        hours: ["09:00-12:00", "13:00-17:00"]
+//	        dates: [2030-01-01..2030-06-30]
+//	default: deny
+//	rate_limits:
+//	  - groups: [ops]
+//	    requests: 600
+//	    interval: 1m
+//	    bandwidth: 10MiB
+//	egresses:
+//	  - name: ops
+//	    groups: [ops]
+//	    source_ip: 192.0.2.10
+//
+// The file is validated strictly, unknown fields are refused as well as references to undeclared groups,
+// and to undeclared users when the file declares users. Every error is reported with its line and column.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/ratelimit"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/ardikabs/socks5/pkg/upstream"
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the version of the policy file schema.
+const Version = 1
+
+// Policy is the content of a policy file.
+type Policy struct {
+	// Users are the users authenticated with USERNAME/PASSWORD, a policy without users leaves
+	// the authentication to the credential store of the server.
+	Users []credentials.User
+
+	// Groups are the declared groups, every group the policy refers to must be declared.
+	Groups []string
+
+	Rules   []acl.Rule
+	Default acl.Action
+
+	RateLimits []ratelimit.Rule
+	Egresses   []Egress
+}
+
+// Egress is an outbound path for the identities it matches, see socks5.Egress.
+type Egress struct {
+	Name      string
+	Users     []string
+	Groups    []string
+	SourceIP  net.IP
+	Upstreams []upstream.Proxy
+}
+
+// Error is a validation error, located in the policy file.
+type Error struct {
+	Filename string
+	Line     int
+	Column   int
+
+	// Field is the path of the invalid field, such as 'rules[2].ports[0]'.
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", e.Filename, e.Line, e.Column, e.Field, e.Message)
+}
+
+// Load reads and validates the policy file.
+func Load(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(filename, data)
+}
+
+// Parse validates the policy, the filename is only used to locate the errors.
+// Every validation error is returned at once, joined, each one being an *Error.
+func Parse(filename string, data []byte) (*Policy, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("%s: empty policy", filename)
+	}
+
+	d := &decoder{filename: filename}
+	p := d.policy(doc.Content[0])
+
+	if len(d.errs) > 0 {
+		return nil, errors.Join(d.errs...)
+	}
+
+	return p, nil
+}
+
+// decoder walks the document, collecting every error along with its location.
+type decoder struct {
+	filename string
+	errs     []error
+}
+
+// ref is a reference to a user or a group, kept with its node so undeclared ones can be located.
+type ref struct {
+	name  string
+	node  *yaml.Node
+	field string
+}
+
+type references struct {
+	users  []ref
+	groups []ref
+}
+
+func (d *decoder) errorf(n *yaml.Node, field, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{
+		Filename: d.filename,
+		Line:     n.Line,
+		Column:   n.Column,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (d *decoder) policy(n *yaml.Node) *Policy {
+	p := new(Policy)
+	refs := new(references)
+
+	var (
+		userNames  = make(map[string]struct{})
+		groupNames = make(map[string]struct{})
+		hasVersion bool
+	)
+
+	d.mapping(n, "", map[string]func(*yaml.Node, string){
+		"version": func(n *yaml.Node, field string) {
+			hasVersion = true
+			if v, ok := d.integer(n, field); ok && v != Version {
+				d.errorf(n, field, "unsupported version %d, expecting %d", v, Version)
+			}
+		},
+		"groups": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				var name string
+				d.mapping(n, field, map[string]func(*yaml.Node, string){
+					"name":        func(n *yaml.Node, field string) { name = d.name(n, field, groupNames, "group") },
+					"description": d.ignore,
+				}, "name")
+
+				if name != "" {
+					p.Groups = append(p.Groups, name)
+				}
+			})
+		},
+		"users": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				if u, ok := d.user(n, field, userNames, refs); ok {
+					p.Users = append(p.Users, u)
+				}
+			})
+		},
+		"rules": func(n *yaml.Node, field string) {
+			ids := map[string]struct{}{acl.DefaultRuleID: {}}
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				p.Rules = append(p.Rules, d.rule(n, field, ids, refs))
+			})
+		},
+		"default": func(n *yaml.Node, field string) {
+			p.Default = d.action(n, field)
+		},
+		"rate_limits": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				p.RateLimits = append(p.RateLimits, d.rateLimit(n, field, refs))
+			})
+		},
+		"egresses": func(n *yaml.Node, field string) {
+			names := map[string]struct{}{"default": {}}
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				p.Egresses = append(p.Egresses, d.egress(n, field, names, refs))
+			})
+		},
+	})
+
+	if n.Kind == yaml.MappingNode && !hasVersion {
+		d.errorf(n, "version", "field is required")
+	}
+
+	for _, r := range refs.groups {
+		if _, ok := groupNames[r.name]; !ok {
+			d.errorf(r.node, r.field, "undeclared group %q", r.name)
+		}
+	}
+
+	if len(p.Users) > 0 {
+		for _, r := range refs.users {
+			if _, ok := userNames[r.name]; !ok {
+				d.errorf(r.node, r.field, "undeclared user %q", r.name)
+			}
+		}
+	}
+
+	return p
+}
+
+func (d *decoder) user(n *yaml.Node, field string, names map[string]struct{}, refs *references) (credentials.User, bool) {
+	var u credentials.User
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"name": func(n *yaml.Node, field string) { u.Username = d.name(n, field, names, "user") },
+		"password": func(n *yaml.Node, field string) {
+			if u.Hash = d.str(n, field); u.Hash == "" {
+				return
+			}
+
+			if err := credentials.ValidateHash(u.Hash); err != nil {
+				d.errorf(n, field, "%v, expecting a hash such as bcrypt or argon2id", err)
+			}
+		},
+		"groups": func(n *yaml.Node, field string) { u.Groups = d.groupRefs(n, field, refs) },
+		"expires": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				if t, err = time.Parse(time.DateOnly, s); err != nil {
+					d.errorf(n, field, "invalid expiry %q, expecting an RFC 3339 timestamp or a YYYY-MM-DD date", s)
+				}
+			}
+
+			u.Account.ExpiresAt = t
+		},
+		"disabled": func(n *yaml.Node, field string) { u.Account.Disabled = d.boolean(n, field) },
+		"max_sessions": func(n *yaml.Node, field string) {
+			if v, ok := d.integer(n, field); ok {
+				if v < 0 {
+					d.errorf(n, field, "can't be negative")
+				}
+
+				u.Account.MaxSessions = int(v)
+			}
+		},
+	}, "name", "password")
+
+	return u, u.Username != ""
+}
+
+func (d *decoder) rule(n *yaml.Node, field string, ids map[string]struct{}, refs *references) acl.Rule {
+	var r acl.Rule
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"id":          func(n *yaml.Node, field string) { r.ID = d.name(n, field, ids, "rule ID") },
+		"description": d.ignore,
+		"action":      func(n *yaml.Node, field string) { r.Action = d.action(n, field) },
+		"commands": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				if cmd, ok := d.command(n, field); ok {
+					r.Commands = append(r.Commands, cmd)
+				}
+			})
+		},
+		"users":    func(n *yaml.Node, field string) { r.Users = d.userRefs(n, field, refs) },
+		"groups":   func(n *yaml.Node, field string) { r.Groups = d.groupRefs(n, field, refs) },
+		"clients":  func(n *yaml.Node, field string) { r.Clients = d.prefixes(n, field) },
+		"networks": func(n *yaml.Node, field string) { r.Networks = d.prefixes(n, field) },
+		"domains": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				domain := d.str(n, field)
+				if strings.Trim(domain, ".") == "" {
+					d.errorf(n, field, "empty domain %q", domain)
+					return
+				}
+
+				if _, err := path.Match(domain, ""); err != nil {
+					d.errorf(n, field, "invalid domain pattern %q: %v", domain, err)
+					return
+				}
+
+				r.Domains = append(r.Domains, domain)
+			})
+		},
+		"ports": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				if p, ok := d.portRange(n, field); ok {
+					r.Ports = append(r.Ports, p)
+				}
+			})
+		},
+		"schedules": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				r.Schedules = append(r.Schedules, d.schedule(n, field))
+			})
+		},
+	}, "id", "action")
+
+	return r
+}
+
+// schedule decodes the weekdays, hours and dates of a schedule, in its timezone.
+func (d *decoder) schedule(n *yaml.Node, field string) acl.Schedule {
+	s := acl.Schedule{Location: time.UTC}
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"timezone": func(n *yaml.Node, field string) {
+			name := d.str(n, field)
+			if name == "" {
+				return
+			}
+
+			loc, err := time.LoadLocation(name)
+			if err != nil {
+				d.errorf(n, field, "unknown timezone %q, expecting an IANA name such as Asia/Jakarta", name)
+				return
+			}
+
+			s.Location = loc
+		},
+		"weekdays": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				s.Weekdays = append(s.Weekdays, d.weekdays(n, field)...)
+			})
+		},
+		"hours": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				if h, ok := d.timeRange(n, field); ok {
+					s.Hours = append(s.Hours, h)
+				}
+			})
+		},
+		"dates": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				if r, ok := d.dateRange(n, field); ok {
+					s.Dates = append(s.Dates, r)
+				}
+			})
+		},
+	}, "timezone")
+
+	if resolve(n).Kind == yaml.MappingNode && len(s.Weekdays) == 0 && len(s.Hours) == 0 && len(s.Dates) == 0 {
+		d.errorf(n, field, "empty schedule, expecting weekdays, hours or dates")
+	}
+
+	return s
+}
+
+func (d *decoder) rateLimit(n *yaml.Node, field string, refs *references) ratelimit.Rule {
+	var r ratelimit.Rule
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"description": d.ignore,
+		"users":       func(n *yaml.Node, field string) { r.Users = d.userRefs(n, field, refs) },
+		"groups":      func(n *yaml.Node, field string) { r.Groups = d.groupRefs(n, field, refs) },
+		"requests": func(n *yaml.Node, field string) {
+			if v, ok := d.integer(n, field); ok {
+				if v <= 0 {
+					d.errorf(n, field, "must be positive")
+				}
+
+				r.Requests = int(v)
+			}
+		},
+		"interval": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			interval, err := time.ParseDuration(s)
+			if err != nil || interval <= 0 {
+				d.errorf(n, field, "invalid interval %q, expecting a positive duration such as 1m", s)
+			}
+
+			r.Interval = interval
+		},
+		"bandwidth": func(n *yaml.Node, field string) { r.Bandwidth = d.bandwidth(n, field) },
+	})
+
+	if resolve(n).Kind == yaml.MappingNode {
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			d.errorf(n, field, "either users or groups are required")
+		}
+
+		if r.Requests == 0 && r.Bandwidth == 0 {
+			d.errorf(n, field, "either requests or bandwidth is required")
+		}
+	}
+
+	return r
+}
+
+func (d *decoder) egress(n *yaml.Node, field string, names map[string]struct{}, refs *references) Egress {
+	var e Egress
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"name":        func(n *yaml.Node, field string) { e.Name = d.name(n, field, names, "egress") },
+		"description": d.ignore,
+		"users":       func(n *yaml.Node, field string) { e.Users = d.userRefs(n, field, refs) },
+		"groups":      func(n *yaml.Node, field string) { e.Groups = d.groupRefs(n, field, refs) },
+		"source_ip": func(n *yaml.Node, field string) {
+			s := d.str(n, field)
+			if s == "" {
+				return
+			}
+
+			if e.SourceIP = net.ParseIP(s); e.SourceIP == nil {
+				d.errorf(n, field, "invalid IP address %q", s)
+			}
+		},
+		"upstreams": func(n *yaml.Node, field string) {
+			d.sequence(n, field, func(n *yaml.Node, field string) {
+				e.Upstreams = append(e.Upstreams, d.upstream(n, field))
+			})
+		},
+	}, "name")
+
+	if resolve(n).Kind == yaml.MappingNode && len(e.Users) == 0 && len(e.Groups) == 0 {
+		d.errorf(n, field, "either users or groups are required")
+	}
+
+	return e
+}
+
+func (d *decoder) upstream(n *yaml.Node, field string) upstream.Proxy {
+	var p upstream.Proxy
+
+	d.mapping(n, field, map[string]func(*yaml.Node, string){
+		"type": func(n *yaml.Node, field string) {
+			switch p.Type = upstream.Type(d.str(n, field)); p.Type {
+			case upstream.TypeSOCKS5, upstream.TypeSOCKS4A, upstream.TypeHTTP, "":
+			default:
+				d.errorf(n, field, "unknown type %q, expecting one of socks5, socks4a or http", p.Type)
+			}
+		},
+		"address": func(n *yaml.Node, field string) {
+			if p.Address = d.str(n, field); p.Address == "" {
+				return
+			}
+
+			if _, _, err := net.SplitHostPort(p.Address); err != nil {
+				d.errorf(n, field, "invalid address %q, expecting host:port", p.Address)
+			}
+		},
+		"username": func(n *yaml.Node, field string) { p.Username = d.str(n, field) },
+		"password": func(n *yaml.Node, field string) { p.Password = d.str(n, field) },
+	}, "type", "address")
+
+	return p
+}
+
+// mapping decodes the fields of a mapping node, refusing unknown, duplicate and missing required fields.
+func (d *decoder) mapping(n *yaml.Node, field string, fields map[string]func(*yaml.Node, string), required ...string) {
+	n = resolve(n)
+	if n.Kind != yaml.MappingNode {
+		d.errorf(n, orRoot(field), "expecting a mapping")
+		return
+	}
+
+	seen := make(map[string]struct{}, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+
+		name := joinField(field, key.Value)
+		if _, ok := seen[key.Value]; ok {
+			d.errorf(key, name, "duplicate field")
+			continue
+		}
+		seen[key.Value] = struct{}{}
+
+		decode, ok := fields[key.Value]
+		if !ok {
+			d.errorf(key, name, "unknown field")
+			continue
+		}
+
+		decode(value, name)
+	}
+
+	for _, r := range required {
+		if _, ok := seen[r]; !ok {
+			d.errorf(n, joinField(field, r), "field is required")
+		}
+	}
+}
+
+func (d *decoder) sequence(n *yaml.Node, field string, each func(*yaml.Node, string)) {
+	n = resolve(n)
+	if n.Kind != yaml.SequenceNode {
+		d.errorf(n, orRoot(field), "expecting a list")
+		return
+	}
+
+	for i, item := range n.Content {
+		each(item, fmt.Sprintf("%s[%d]", field, i))
+	}
+}
+
+func (d *decoder) ignore(*yaml.Node, string) {}
+
+// scalar returns the value of a scalar node of one of the given tags.
+func (d *decoder) scalar(n *yaml.Node, field, expecting string, tags ...string) (string, bool) {
+	n = resolve(n)
+	if n.Kind == yaml.ScalarNode {
+		for _, tag := range tags {
+			if n.ShortTag() == tag {
+				return n.Value, true
+			}
+		}
+	}
+
+	d.errorf(n, field, "expecting %s", expecting)
+	return "", false
+}
+
+func (d *decoder) str(n *yaml.Node, field string) string {
+	s, _ := d.scalar(n, field, "a string", "!!str", "!!int", "!!float", "!!timestamp")
+	return s
+}
+
+func (d *decoder) integer(n *yaml.Node, field string) (int64, bool) {
+	s, ok := d.scalar(n, field, "an integer", "!!int")
+	if !ok {
+		return 0, false
+	}
+
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		d.errorf(n, field, "invalid integer %q", s)
+		return 0, false
+	}
+
+	return v, true
+}
+
+func (d *decoder) boolean(n *yaml.Node, field string) bool {
+	s, _ := d.scalar(n, field, "true or false", "!!bool")
+	v, _ := strconv.ParseBool(s)
+	return v
+}
+
+// name decodes a required name, refusing duplicates of the names seen so far.
+func (d *decoder) name(n *yaml.Node, field string, names map[string]struct{}, kind string) string {
+	name := d.str(n, field)
+	if name == "" {
+		d.errorf(n, field, "empty %s", kind)
+		return ""
+	}
+
+	if _, ok := names[name]; ok {
+		d.errorf(n, field, "duplicate %s %q", kind, name)
+		return ""
+	}
+
+	names[name] = struct{}{}
+	return name
+}
+
+func (d *decoder) userRefs(n *yaml.Node, field string, refs *references) []string {
+	var users []string
+	d.sequence(n, field, func(n *yaml.Node, field string) {
+		if u := d.str(n, field); u != "" {
+			users = append(users, u)
+			refs.users = append(refs.users, ref{name: u, node: n, field: field})
+		}
+	})
+
+	return users
+}
+
+func (d *decoder) groupRefs(n *yaml.Node, field string, refs *references) []string {
+	var groups []string
+	d.sequence(n, field, func(n *yaml.Node, field string) {
+		if g := d.str(n, field); g != "" {
+			groups = append(groups, g)
+			refs.groups = append(refs.groups, ref{name: g, node: n, field: field})
+		}
+	})
+
+	return groups
+}
+
+func (d *decoder) action(n *yaml.Node, field string) acl.Action {
+	switch a := acl.Action(d.str(n, field)); a {
+	case acl.ActionAllow, acl.ActionDeny:
+		return a
+	default:
+		d.errorf(n, field, "unknown action %q, expecting allow or deny", a)
+		return ""
+	}
+}
+
+// commands are the names of the commands in the rules.
+var commands = map[string]types.CommandID{
+	"connect":       types.CommandConnect,
+	"bind":          types.CommandBIND,
+	"udp_associate": types.CommandUDPAssoc,
+	"resolve":       types.CommandResolve,
+	"resolve_ptr":   types.CommandResolvePTR,
+}
+
+func (d *decoder) command(n *yaml.Node, field string) (types.CommandID, bool) {
+	cmd, err := ParseCommand(d.str(n, field))
+	if err != nil {
+		d.errorf(n, field, "%v", err)
+		return 0, false
+	}
+
+	return cmd, true
+}
+
+// prefixes decodes a list of networks, a single IP address being a network of its own.
+func (d *decoder) prefixes(n *yaml.Node, field string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	d.sequence(n, field, func(n *yaml.Node, field string) {
+		s := d.str(n, field)
+		if s == "" {
+			return
+		}
+
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(s)
+			if aerr != nil {
+				d.errorf(n, field, "invalid network %q, expecting a CIDR or an IP address", s)
+				return
+			}
+
+			p = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+
+		prefixes = append(prefixes, p.Masked())
+	})
+
+	return prefixes
+}
+
+// portRange decodes a port, or an inclusive range of ports as 'from-to'.
+func (d *decoder) portRange(n *yaml.Node, field string) (acl.PortRange, bool) {
+	s := d.str(n, field)
+	if s == "" {
+		return acl.PortRange{}, false
+	}
+
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+
+	f, ferr := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+	t, terr := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+	if ferr != nil || terr != nil || f > t {
+		d.errorf(n, field, "invalid port range %q, expecting a port or from-to", s)
+		return acl.PortRange{}, false
+	}
+
+	return acl.PortRange{From: uint16(f), To: uint16(t)}, true
+}
+
+// weekdayNames are the names of the days of the week, in full or abbreviated.
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// weekdays decodes a day of the week, or an inclusive range of days as 'from-to' such as mon-fri or sat-sun.
+func (d *decoder) weekdays(n *yaml.Node, field string) []time.Weekday {
+	s := d.str(n, field)
+	if s == "" {
+		return nil
+	}
+
+	from, to, isRange := strings.Cut(strings.ToLower(s), "-")
+	if !isRange {
+		to = from
+	}
+
+	f, fok := weekdayNames[strings.TrimSpace(from)]
+	t, tok := weekdayNames[strings.TrimSpace(to)]
+	if !fok || !tok {
+		d.errorf(n, field, "invalid weekday %q, expecting a day such as mon or monday, or from-to such as mon-fri", s)
+		return nil
+	}
+
+	days := []time.Weekday{f}
+	for day := f; day != t; {
+		day = (day + 1) % 7
+		days = append(days, day)
+	}
+
+	return days
+}
+
+// timeRange decodes a range of the wall clock as 'from-to', such as 09:00-17:00 or 22:00-24:00.
+func (d *decoder) timeRange(n *yaml.Node, field string) (acl.TimeRange, bool) {
+	s := d.str(n, field)
+	if s == "" {
+		return acl.TimeRange{}, false
+	}
+
+	from, to, _ := strings.Cut(s, "-")
+	f, fok := parseClock(from)
+	t, tok := parseClock(to)
+	if !fok || !tok || f >= t {
+		d.errorf(n, field, "invalid time range %q, expecting from-to within a day such as 09:00-17:00", s)
+		return acl.TimeRange{}, false
+	}
+
+	return acl.TimeRange{From: f, To: t}, true
+}
+
+// parseClock parses a time of the day as HH:MM, from 00:00 to 24:00.
+func parseClock(s string) (time.Duration, bool) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok || len(minutes) != 2 {
+		return 0, false
+	}
+
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, false
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, true
+}
+
+// dateRange decodes a date, or an inclusive range of dates as 'from..to'.
+func (d *decoder) dateRange(n *yaml.Node, field string) (acl.DateRange, bool) {
+	s := d.str(n, field)
+	if s == "" {
+		return acl.DateRange{}, false
+	}
+
+	from, to, isRange := strings.Cut(s, "..")
+	if !isRange {
+		to = from
+	}
+
+	f, ferr := time.Parse(time.DateOnly, strings.TrimSpace(from))
+	t, terr := time.Parse(time.DateOnly, strings.TrimSpace(to))
+	if ferr != nil || terr != nil || t.Before(f) {
+		d.errorf(n, field, "invalid date range %q, expecting a YYYY-MM-DD date or from..to", s)
+		return acl.DateRange{}, false
+	}
+
+	return acl.DateRange{From: f, To: t}, true
+}
+
+// byteUnits are the units of the bandwidth, decimal and binary.
+var byteUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+}
+
+// bandwidth decodes bytes per second, as an integer optionally followed by a unit such as 512KiB or 10MB/s.
+func (d *decoder) bandwidth(n *yaml.Node, field string) int64 {
+	s := d.str(n, field)
+	if s == "" {
+		return 0
+	}
+
+	value := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	digits := strings.TrimRightFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+
+	v, err := strconv.ParseInt(digits, 10, 64)
+	unit, ok := byteUnits[strings.TrimSpace(value[len(digits):])]
+	if err != nil || !ok || v <= 0 {
+		d.errorf(n, field, "invalid bandwidth %q, expecting bytes per second such as 1048576, 512KiB or 10MB", s)
+		return 0
+	}
+
+	if v > math.MaxInt64/unit {
+		d.errorf(n, field, "bandwidth %q is too large", s)
+		return 0
+	}
+
+	return v * unit
+}
+
+// resolve follows the aliases to the node they refer to.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+
+	return n
+}
+
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+
+	return field + "." + name
+}
+
+func orRoot(field string) string {
+	if field == "" {
+		return "(root)"
+	}
+
+	return field
+}
@@ -0,0 +1,287 @@
+package policy
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/ratelimit"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/ardikabs/socks5/pkg/upstream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const hash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+func TestParse(t *testing.T) {
+	want := &Policy{
+		Users: []credentials.User{
+			{Username: "alice", Hash: hash, Groups: []string{"ops"}, Account: credentials.Account{ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), MaxSessions: 2}},
+			{Username: "bob", Hash: hash, Account: credentials.Account{Disabled: true}},
+		},
+		Groups: []string{"ops"},
+		Rules: []acl.Rule{
+			{ID: "no-bind", Action: acl.ActionDeny, Commands: []types.CommandID{types.CommandBIND, types.CommandUDPAssoc}},
+			{
+				ID: "ops-internal", Action: acl.ActionAllow, Groups: []string{"ops"}, Users: []string{"bob"},
+				Clients:  []netip.Prefix{netip.MustParsePrefix("192.0.2.7/32")},
+				Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+				Domains:  []string{".corp.example.com"},
+				Ports:    []acl.PortRange{{From: 22, To: 22}, {From: 8000, To: 8080}},
+			},
+		},
+		Default: acl.ActionDeny,
+		RateLimits: []ratelimit.Rule{
+			{Groups: []string{"ops"}, Requests: 600, Interval: time.Minute, Bandwidth: 10 << 20},
+		},
+		Egresses: []Egress{
+			{Name: "ops", Groups: []string{"ops"}, SourceIP: net.ParseIP("192.0.2.10")},
+			{Name: "bob", Users: []string{"bob"}, Upstreams: []upstream.Proxy{{Type: upstream.TypeSOCKS5, Address: "proxy.example.com:1080", Username: "u", Password: "p"}}},
+		},
+	}
+
+	t.Run("YAML", func(t *testing.T) {
+		p, err := Parse("policy.yaml", []byte(`
+version: 1
+groups:
+  - name: ops
+    description: Operations team
+users:
+  - name: alice
+    password: "`+hash+`"
+    groups: [ops]
+    expires: 2030-01-01
+    max_sessions: 2
+  - name: bob
+    password: "`+hash+`"
+    disabled: true
+rules:
+  - id: no-bind
+    action: deny
+    commands: [bind, udp_associate]
+  - id: ops-internal
+    description: Internal services
+    action: allow
+    groups: [ops]
+    users: [bob]
+    clients: [192.0.2.7]
+    networks: [10.1.2.3/8]
+    domains: [.corp.example.com]
+    ports: [22, 8000-8080]
+default: deny
+rate_limits:
+  - groups: [ops]
+    requests: 600
+    interval: 1m
+    bandwidth: 10MiB/s
+egresses:
+  - name: ops
+    groups: [ops]
+    source_ip: 192.0.2.10
+  - name: bob
+    users: [bob]
+    upstreams:
+      - {type: socks5, address: "proxy.example.com:1080", username: u, password: p}
+`))
+		require.NoError(t, err)
+		assert.Equal(t, want, p)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		p, err := Parse("policy.json", []byte(`{
+  "version": 1,
+  "groups": [{"name": "ops"}],
+  "users": [
+    {"name": "alice", "password": "`+hash+`", "groups": ["ops"], "expires": "2030-01-01T00:00:00Z", "max_sessions": 2},
+    {"name": "bob", "password": "`+hash+`", "disabled": true}
+  ],
+  "rules": [
+    {"id": "no-bind", "action": "deny", "commands": ["bind", "udp_associate"]},
+    {"id": "ops-internal", "action": "allow", "groups": ["ops"], "users": ["bob"], "clients": ["192.0.2.7/32"],
+     "networks": ["10.0.0.0/8"], "domains": [".corp.example.com"], "ports": [22, "8000-8080"]}
+  ],
+  "default": "deny",
+  "rate_limits": [{"groups": ["ops"], "requests": 600, "interval": "1m", "bandwidth": 10485760}],
+  "egresses": [
+    {"name": "ops", "groups": ["ops"], "source_ip": "192.0.2.10"},
+    {"name": "bob", "users": ["bob"], "upstreams": [{"type": "socks5", "address": "proxy.example.com:1080", "username": "u", "password": "p"}]}
+  ]
+}`))
+		require.NoError(t, err)
+		assert.Equal(t, want, p)
+	})
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := Parse("policy.yaml", []byte(`version: 2
+users:
+  - name: alice
+    password: secret
+    groups: [ops, dev]
+  - name: alice
+    password: "`+hash+`"
+    max_sessions: -1
+rules:
+  - id: default
+    action: drop
+    commands: [listen]
+    users: [carol]
+    networks: [10.0.0.0/33]
+    domains: ["[a-"]
+    ports: [443-80, http]
+  - action: allow
+    protocol: tcp
+rate_limits:
+  - requests: 10
+    interval: soon
+    bandwidth: 1 TB
+egresses:
+  - name: default
+    source_ip: 192.0.2
+    upstreams:
+      - {type: ftp, address: proxy.example.com}
+groups:
+  - name: ops
+  - name: ops
+`))
+	require.Error(t, err)
+
+	var got []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var perr *Error
+		require.True(t, errors.As(e, &perr), e.Error())
+		got = append(got, e.Error())
+	}
+
+	for _, want := range []string{
+		`policy.yaml:1:10: version: unsupported version 2, expecting 1`,
+		`policy.yaml:4:15: users[0].password: unsupported password hash format, expecting a hash such as bcrypt or argon2id`,
+		`policy.yaml:6:11: users[1].name: duplicate user "alice"`,
+		`policy.yaml:8:19: users[1].max_sessions: can't be negative`,
+		`policy.yaml:10:9: rules[0].id: duplicate rule ID "default"`,
+		`policy.yaml:11:13: rules[0].action: unknown action "drop", expecting allow or deny`,
+		`policy.yaml:12:16: rules[0].commands[0]: unknown command "listen"`,
+		`policy.yaml:14:16: rules[0].networks[0]: invalid network "10.0.0.0/33"`,
+		`policy.yaml:15:15: rules[0].domains[0]: invalid domain pattern "[a-"`,
+		`policy.yaml:16:13: rules[0].ports[0]: invalid port range "443-80"`,
+		`policy.yaml:16:21: rules[0].ports[1]: invalid port range "http"`,
+		`policy.yaml:18:5: rules[1].protocol: unknown field`,
+		`policy.yaml:17:5: rules[1].id: field is required`,
+		`policy.yaml:20:5: rate_limits[0]: either users or groups are required`,
+		`policy.yaml:21:15: rate_limits[0].interval: invalid interval "soon"`,
+		`policy.yaml:22:16: rate_limits[0].bandwidth: invalid bandwidth "1 TB"`,
+		`policy.yaml:24:11: egresses[0].name: duplicate egress "default"`,
+		`policy.yaml:24:5: egresses[0]: either users or groups are required`,
+		`policy.yaml:25:16: egresses[0].source_ip: invalid IP address "192.0.2"`,
+		`policy.yaml:27:16: egresses[0].upstreams[0].type: unknown type "ftp"`,
+		`policy.yaml:27:30: egresses[0].upstreams[0].address: invalid address "proxy.example.com"`,
+		`policy.yaml:30:11: groups[1].name: duplicate group "ops"`,
+		`policy.yaml:5:19: users[0].groups[1]: undeclared group "dev"`,
+		`policy.yaml:13:13: rules[0].users[0]: undeclared user "carol"`,
+	} {
+		assert.True(t, containsPrefix(got, want), "missing error %q in:\n%s", want, strings.Join(got, "\n"))
+	}
+
+	for name, doc := range map[string]string{
+		"syntax":             "version: [1",
+		"empty":              "",
+		"missing version":    "rules: []",
+		"not a mapping":      "- version: 1",
+		"wrong type":         "version: 1\nrules: {}",
+		"undeclared ops":     "version: 1\nrules: [{id: a, action: allow, groups: [ops]}]",
+		"bandwidth overflow": "version: 1\ngroups: [{name: ops}]\nrate_limits: [{groups: [ops], bandwidth: 9000000000GiB}]",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse("policy.yaml", []byte(doc))
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("aliases", func(t *testing.T) {
+		_, err := Parse("policy.yaml", []byte(`version: 1
+rate_limits: [&limit {requests: 10}, *limit]
+egresses: [&egress {name: a, source_ip: 192.0.2.1}, *egress]
+`))
+		require.Error(t, err)
+
+		// An alias is checked the same as the node it refers to
+		for _, want := range []string{
+			`policy.yaml:2:15: rate_limits[0]: either users or groups are required`,
+			`policy.yaml:2:38: rate_limits[1]: either users or groups are required`,
+			`policy.yaml:3:12: egresses[0]: either users or groups are required`,
+			`policy.yaml:3:53: egresses[1]: either users or groups are required`,
+		} {
+			assert.ErrorContains(t, err, want)
+		}
+	})
+}
+
+func TestParse_Schedules(t *testing.T) {
+	p, err := Parse("policy.yaml", []byte(`
+version: 1
+rules:
+  - id: business-hours
+    action: allow
+    schedules:
+      - timezone: Asia/Jakarta
+        weekdays: [mon-wed, friday, sat-sun]
+        hours: ["09:00-12:00", "13:00-24:00"]
+        dates: [2030-01-01..2030-06-30, 2030-12-25]
+`))
+	require.NoError(t, err)
+	require.Len(t, p.Rules[0].Schedules, 1)
+
+	s := p.Rules[0].Schedules[0]
+	assert.Equal(t, "Asia/Jakarta", s.Location.String())
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Friday, time.Saturday, time.Sunday}, s.Weekdays)
+	assert.Equal(t, []acl.TimeRange{{From: 9 * time.Hour, To: 12 * time.Hour}, {From: 13 * time.Hour, To: 24 * time.Hour}}, s.Hours)
+	assert.Equal(t, []acl.DateRange{
+		{From: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2030, 6, 30, 0, 0, 0, 0, time.UTC)},
+		{From: time.Date(2030, 12, 25, 0, 0, 0, 0, time.UTC), To: time.Date(2030, 12, 25, 0, 0, 0, 0, time.UTC)},
+	}, s.Dates)
+
+	_, err = Parse("policy.yaml", []byte(`version: 1
+rules:
+  - id: business-hours
+    action: allow
+    schedules:
+      - timezone: Mars/Olympus_Mons
+        weekdays: [weekend]
+        hours: ["17:00-09:00", "9-17", "23:00-24:30"]
+        dates: [2030-06-30..2030-01-01, 2030-02-30]
+      - timezone: UTC
+      - weekdays: [mon]
+`))
+	require.Error(t, err)
+
+	got := strings.Split(err.Error(), "\n")
+	for _, want := range []string{
+		`policy.yaml:6:19: rules[0].schedules[0].timezone: unknown timezone "Mars/Olympus_Mons"`,
+		`policy.yaml:7:20: rules[0].schedules[0].weekdays[0]: invalid weekday "weekend"`,
+		`policy.yaml:8:17: rules[0].schedules[0].hours[0]: invalid time range "17:00-09:00"`,
+		`policy.yaml:8:32: rules[0].schedules[0].hours[1]: invalid time range "9-17"`,
+		`policy.yaml:8:40: rules[0].schedules[0].hours[2]: invalid time range "23:00-24:30"`,
+		`policy.yaml:9:17: rules[0].schedules[0].dates[0]: invalid date range "2030-06-30..2030-01-01"`,
+		`policy.yaml:9:41: rules[0].schedules[0].dates[1]: invalid date range "2030-02-30"`,
+		`policy.yaml:10:9: rules[0].schedules[1]: empty schedule, expecting weekdays, hours or dates`,
+		`policy.yaml:11:9: rules[0].schedules[2].timezone: field is required`,
+	} {
+		assert.True(t, containsPrefix(got, want), "missing error %q in:\n%s", want, strings.Join(got, "\n"))
+	}
+}
+
+func containsPrefix(errs []string, prefix string) bool {
+	for _, e := range errs {
+		if strings.HasPrefix(e, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,290 @@
+// Package ratelimit caps the requests and the bandwidth of the authenticated identities.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrRateLimited = fmt.Errorf("rate limit exceeded")
+	ErrInvalidRule = fmt.Errorf("invalid rate limit")
+)
+
+// DefaultInterval is the interval of the Requests when none is given.
+const DefaultInterval = time.Minute
+
+// budgetIdleTimeout is how long the budget of an identity is kept unused, at least, before being evicted.
+// It is kept for the whole interval of its requests, the time it takes to be full again.
+const budgetIdleTimeout = 10 * time.Minute
+
+// Rule limits the identities it matches, by user ID or by group.
+// Every identity matching the rule gets budgets of its own, they are not shared with the rest of the group.
+type Rule struct {
+	Users  []string
+	Groups []string
+
+	// Requests caps the requests of an identity per Interval, zero is unlimited.
+	Requests int
+	Interval time.Duration
+
+	// Bandwidth caps the bytes per second an identity sends and receives, each direction on its own,
+	// across all of its connections. Zero is unlimited.
+	Bandwidth int64
+}
+
+// Limiter applies the first rule matching an identity, anonymous clients and the identities matching
+// no rule are not limited.
+type Limiter struct {
+	rules []Rule
+
+	mu        sync.Mutex
+	budgets   map[budgetKey]*budget
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type budgetKey struct {
+	rule   int
+	userID string
+}
+
+type budget struct {
+	requests *rate.Limiter
+	read     *rate.Limiter
+	write    *rate.Limiter
+
+	// idleAfter is how long the budget is kept unused, lastUsed is in Unix nanoseconds.
+	idleAfter time.Duration
+	lastUsed  atomic.Int64
+	evicted   atomic.Bool
+}
+
+func (b *budget) touch(now time.Time) {
+	b.lastUsed.Store(now.UnixNano())
+}
+
+func New(rules []Rule) (*Limiter, error) {
+	for i, r := range rules {
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			return nil, fmt.Errorf("%w: rule #%d: either users or groups are required", ErrInvalidRule, i)
+		}
+
+		if r.Requests < 0 || r.Interval < 0 || r.Bandwidth < 0 {
+			return nil, fmt.Errorf("%w: rule #%d: limits can't be negative", ErrInvalidRule, i)
+		}
+
+		if r.Requests == 0 && r.Bandwidth == 0 {
+			return nil, fmt.Errorf("%w: rule #%d: either requests or bandwidth is required", ErrInvalidRule, i)
+		}
+
+		if r.Requests > 0 && intervalOf(r) < time.Duration(r.Requests) {
+			return nil, fmt.Errorf("%w: rule #%d: more than a request per nanosecond", ErrInvalidRule, i)
+		}
+	}
+
+	return &Limiter{rules: rules, budgets: make(map[budgetKey]*budget), now: time.Now}, nil
+}
+
+// Allow takes a request off the budget of the identity, it returns ErrRateLimited once the budget is spent.
+func (l *Limiter) Allow(identity *credentials.Identity) error {
+	b := l.budgetOf(identity)
+	if b == nil || b.requests == nil {
+		return nil
+	}
+
+	if !b.requests.Allow() {
+		return fmt.Errorf("%w, user %q is out of requests", ErrRateLimited, identity.UserID)
+	}
+
+	return nil
+}
+
+// Conn throttles the connection to the bandwidth of the identity, it is returned as-is when unlimited.
+func (l *Limiter) Conn(identity *credentials.Identity, conn net.Conn) net.Conn {
+	b := l.budgetOf(identity)
+	if b == nil || b.read == nil {
+		return conn
+	}
+
+	c := &throttledConn{Conn: conn, limiter: l, identity: identity}
+	c.budget.Store(b)
+	return c
+}
+
+func (l *Limiter) budgetOf(identity *credentials.Identity) *budget {
+	if l == nil || identity == nil {
+		return nil
+	}
+
+	i, ok := l.match(identity)
+	if !ok {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= budgetIdleTimeout {
+		l.sweep(now)
+	}
+
+	key := budgetKey{rule: i, userID: identity.UserID}
+	if b, ok := l.budgets[key]; ok {
+		b.touch(now)
+		return b
+	}
+
+	r := l.rules[i]
+	b := &budget{idleAfter: budgetIdleTimeout}
+	b.touch(now)
+
+	if r.Requests > 0 {
+		interval := intervalOf(r)
+		b.requests = rate.NewLimiter(rate.Limit(float64(r.Requests)/interval.Seconds()), r.Requests)
+		b.idleAfter = max(b.idleAfter, interval)
+	}
+
+	if r.Bandwidth > 0 {
+		b.read = rate.NewLimiter(rate.Limit(r.Bandwidth), burstOf(r.Bandwidth))
+		b.write = rate.NewLimiter(rate.Limit(r.Bandwidth), burstOf(r.Bandwidth))
+	}
+
+	l.budgets[key] = b
+	return b
+}
+
+// sweep evicts the budgets unused for long enough to be full again, the identities they belong to start over
+// with new ones. It is called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.budgets {
+		if now.Sub(time.Unix(0, b.lastUsed.Load())) >= b.idleAfter {
+			b.evicted.Store(true)
+			delete(l.budgets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
+
+func intervalOf(r Rule) time.Duration {
+	if r.Interval == 0 {
+		return DefaultInterval
+	}
+
+	return r.Interval
+}
+
+func (l *Limiter) match(identity *credentials.Identity) (int, bool) {
+	for i, r := range l.rules {
+		if r.Matches(identity) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// Matches reports whether the user ID or any of the groups of the identity is in the rule.
+func (r Rule) Matches(identity *credentials.Identity) bool {
+	if identity == nil {
+		return false
+	}
+
+	for _, u := range r.Users {
+		if u == identity.UserID {
+			return true
+		}
+	}
+
+	for _, g := range r.Groups {
+		for _, ig := range identity.Groups {
+			if g == ig {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// burstOf is a second worth of bandwidth, capped so a single read or write is never too large a chunk.
+func burstOf(bandwidth int64) int {
+	const maxBurst = 1 << 20
+	if bandwidth > maxBurst {
+		return maxBurst
+	}
+
+	return int(bandwidth)
+}
+
+// throttledConn waits for the bandwidth of every chunk it reads and writes.
+type throttledConn struct {
+	net.Conn
+	limiter  *Limiter
+	identity *credentials.Identity
+	budget   atomic.Pointer[budget]
+}
+
+// current returns the budget of the identity, the connection moves to a new one once its budget is evicted
+// so it keeps sharing the bandwidth with the other connections of the identity.
+func (c *throttledConn) current() *budget {
+	b := c.budget.Load()
+	if b.evicted.Load() {
+		b = c.limiter.budgetOf(c.identity)
+		c.budget.Store(b)
+		return b
+	}
+
+	b.touch(c.limiter.now())
+	return b
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	read := c.current().read
+	if len(b) > read.Burst() {
+		b = b[:read.Burst()]
+	}
+
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if werr := read.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		write := c.current().write
+		chunk := b
+		if len(chunk) > write.Burst() {
+			chunk = chunk[:write.Burst()]
+		}
+
+		if err := write.WaitN(context.Background(), len(chunk)); err != nil {
+			return written, err
+		}
+
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		b = b[n:]
+	}
+
+	return written, nil
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	l, err := New([]Rule{
+		{Users: []string{"alice"}, Requests: 2, Interval: time.Hour},
+		{Groups: []string{"scrapers"}, Requests: 1, Interval: time.Hour},
+	})
+	require.NoError(t, err)
+
+	alice := &credentials.Identity{UserID: "alice", Groups: []string{"scrapers"}}
+	require.NoError(t, l.Allow(alice))
+	require.NoError(t, l.Allow(alice))
+	require.ErrorIs(t, l.Allow(alice), ErrRateLimited)
+
+	// Every member of the group has a budget of its own
+	require.NoError(t, l.Allow(&credentials.Identity{UserID: "bob", Groups: []string{"scrapers"}}))
+	require.NoError(t, l.Allow(&credentials.Identity{UserID: "carol", Groups: []string{"scrapers"}}))
+	require.ErrorIs(t, l.Allow(&credentials.Identity{UserID: "carol", Groups: []string{"scrapers"}}), ErrRateLimited)
+
+	require.NoError(t, l.Allow(&credentials.Identity{UserID: "dave"}))
+	require.NoError(t, l.Allow(nil))
+
+	var nilLimiter *Limiter
+	require.NoError(t, nilLimiter.Allow(alice))
+}
+
+func TestLimiter_Conn(t *testing.T) {
+	const bandwidth = 100 << 10
+
+	l, err := New([]Rule{{Users: []string{"alice"}, Bandwidth: bandwidth}})
+	require.NoError(t, err)
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	alice := &credentials.Identity{UserID: "alice"}
+	throttled := l.Conn(alice, server)
+	defer throttled.Close()
+
+	require.Equal(t, server, l.Conn(&credentials.Identity{UserID: "bob"}, server))
+
+	go io.Copy(io.Discard, client)
+
+	// The first second worth of bandwidth is a burst, the rest is paced
+	start := time.Now()
+	n, err := throttled.Write(make([]byte, bandwidth*3/2))
+	require.NoError(t, err)
+	assert.Equal(t, bandwidth*3/2, n)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestLimiter_Evict(t *testing.T) {
+	l, err := New([]Rule{{Users: []string{"alice", "bob"}, Requests: 1, Interval: time.Hour, Bandwidth: 1 << 10}})
+	require.NoError(t, err)
+
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	alice := &credentials.Identity{UserID: "alice"}
+	require.NoError(t, l.Allow(alice))
+	require.ErrorIs(t, l.Allow(alice), ErrRateLimited)
+
+	_, server := net.Pipe()
+	throttled := l.Conn(alice, server).(*throttledConn)
+	evicted := throttled.budget.Load()
+
+	// The budget is kept until its interval is over, when it would be full again
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, l.Allow(&credentials.Identity{UserID: "bob"}))
+	require.Len(t, l.budgets, 2)
+
+	now = now.Add(time.Hour)
+	require.NoError(t, l.Allow(&credentials.Identity{UserID: "bob"}))
+	require.Len(t, l.budgets, 1)
+
+	// The connections of an evicted budget share the new one of the identity
+	require.NoError(t, l.Allow(alice))
+	require.True(t, evicted.evicted.Load())
+	require.Same(t, l.budgets[budgetKey{userID: "alice"}], throttled.current())
+}
+
+func TestNew(t *testing.T) {
+	for name, rule := range map[string]Rule{
+		"no identity":    {Requests: 1},
+		"no limit":       {Users: []string{"alice"}},
+		"negative limit": {Users: []string{"alice"}, Bandwidth: -1},
+		"infinite rate":  {Users: []string{"alice"}, Requests: 10, Interval: 5 * time.Nanosecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := New([]Rule{rule})
+			require.ErrorIs(t, err, ErrInvalidRule)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/tool/proxy"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+// DefaultBindTimeout is how long a BIND request waits for the inbound connection.
+const DefaultBindTimeout = 2 * time.Minute
+
+func (req *Request) handleBind(ctx context.Context, clientConn net.Conn) error {
+	log := contexts.GetLogger(ctx).WithValues("command", "bind")
+
+	// The expected peer is known before the first reply, so a failed lookup is the only reply the client gets
+	expectedIP, err := req.expectedPeerIP(ctx)
+	if err != nil {
+		if err := req.replier(clientConn, types.ReplyHostUnreach, req.address); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return err
+	}
+
+	// The listening socket is opened on the same interface the client used to reach the server.
+	localAddr := clientConn.LocalAddr().(*net.TCPAddr)
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localAddr.IP})
+	if err != nil {
+		if err := req.replier(clientConn, types.ReplyGeneralFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("failed to open listening socket: %v", err)
+	}
+	defer listener.Close()
+
+	listenAddr := listener.Addr().(*net.TCPAddr)
+	bindAddr := &types.Address{
+		IP:   listenAddr.IP,
+		Port: listenAddr.Port,
+	}
+
+	// Servers behind NAT advertise their external address instead of the local one.
+	if req.bindIP != nil {
+		bindAddr.IP = req.bindIP
+	}
+
+	log = log.WithValues("bindAddr", bindAddr.String())
+
+	// First reply, tells the client where the application server should connect to
+	log.V(2).Info("sending first reply", "replyCode", types.ReplySucceeded.String())
+	if err := req.replier(clientConn, types.ReplySucceeded, bindAddr); err != nil {
+		return fmt.Errorf("failed to send reply: %v", err)
+	}
+
+	// Close the listener when the session is cancelled, so Accept doesn't outlive it.
+	acceptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-acceptCtx.Done()
+		listener.Close()
+	}()
+
+	if err := listener.SetDeadline(time.Now().Add(req.bindTimeout)); err != nil {
+		return fmt.Errorf("failed to set accept deadline: %v", err)
+	}
+
+	var targetConn net.Conn
+	for {
+		conn, err := listener.AcceptTCP()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				if err := req.replier(clientConn, types.ReplyTTLExpired, nil); err != nil {
+					return fmt.Errorf("failed to send reply: %v", err)
+				}
+
+				return fmt.Errorf("no inbound connection within %s", req.bindTimeout)
+			}
+
+			if err := req.replier(clientConn, types.ReplyGeneralFailure, nil); err != nil {
+				return fmt.Errorf("failed to send reply: %v", err)
+			}
+
+			return fmt.Errorf("failed to accept inbound connection: %v", err)
+		}
+
+		peerAddr := conn.RemoteAddr().(*net.TCPAddr)
+		if expectedIP != nil && !expectedIP.Equal(peerAddr.IP) {
+			log.Info("rejecting inbound connection from unexpected peer", "peer", peerAddr, "expected", expectedIP)
+			conn.Close()
+			continue
+		}
+
+		targetConn = conn
+		break
+	}
+	defer targetConn.Close()
+
+	// Only a single inbound connection is accepted per BIND request.
+	listener.Close()
+
+	peerAddr := targetConn.RemoteAddr().(*net.TCPAddr)
+	remoteAddr := &types.Address{
+		IP:   peerAddr.IP,
+		Port: peerAddr.Port,
+	}
+
+	// Second reply, tells the client who connected to the listening socket
+	log.V(2).Info("sending second reply", "peerAddr", remoteAddr.String(), "replyCode", types.ReplySucceeded.String())
+	if err := req.replier(clientConn, types.ReplySucceeded, remoteAddr); err != nil {
+		return fmt.Errorf("failed to send reply: %v", err)
+	}
+
+	log.Info("start proxying", "src", clientConn.RemoteAddr(), "dst", targetConn.RemoteAddr())
+
+	return proxy.StartContext(ctx, clientConn, targetConn)
+}
+
+// expectedPeerIP returns the IP the inbound connection must originate from,
+// or nil when the peer check is disabled or the client didn't specify DST.ADDR.
+func (req *Request) expectedPeerIP(ctx context.Context) (net.IP, error) {
+	if !req.bindCheckPeer || req.address == nil {
+		return nil, nil
+	}
+
+	if req.address.DomainName != "" {
+		ip, err := req.resolver.Resolve(ctx, req.address.DomainName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve domain name: %s, %v", req.address.DomainName, err)
+		}
+
+		return ip, nil
+	}
+
+	if req.address.IP == nil || req.address.IP.IsUnspecified() {
+		return nil, nil
+	}
+
+	return req.address.IP, nil
+}
@@ -1,9 +1,21 @@
 package request
 
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+)
+
 type Option func(*Request) error
 
 func WithResolver(r DomainResolver) Option {
 	return func(req *Request) error {
+		if r == nil {
+			return nil
+		}
+
 		req.resolver = r
 		return nil
 	}
@@ -19,3 +31,81 @@ func WithDialer(d Dialer) Option {
 		return nil
 	}
 }
+
+// WithBindTimeout sets how long a BIND request waits for the inbound connection.
+func WithBindTimeout(timeout time.Duration) Option {
+	return func(req *Request) error {
+		if timeout <= 0 {
+			return nil
+		}
+
+		req.bindTimeout = timeout
+		return nil
+	}
+}
+
+// WithBindAddress sets the IP address advertised in the BIND replies,
+// useful when the server sits behind NAT.
+func WithBindAddress(ip net.IP) Option {
+	return func(req *Request) error {
+		req.bindIP = ip
+		return nil
+	}
+}
+
+// WithBindPeerCheck makes BIND only accept the inbound connection coming from the requested DST.ADDR.
+func WithBindPeerCheck(enabled bool) Option {
+	return func(req *Request) error {
+		req.bindCheckPeer = enabled
+		return nil
+	}
+}
+
+// WithResolveCommands enables the Tor RESOLVE and RESOLVE_PTR extension commands.
+func WithResolveCommands(enabled bool) Option {
+	return func(req *Request) error {
+		req.resolveCommands = enabled
+		return nil
+	}
+}
+
+// WithRemoteResolve leaves the target domain name resolution of CONNECT to the dialer,
+// such as the last hop of an upstream proxy chain.
+func WithRemoteResolve(enabled bool) Option {
+	return WithRemoteResolveFunc(func(context.Context) bool { return enabled })
+}
+
+// WithRemoteResolveFunc decides with the context of the request whether the target domain name resolution
+// of CONNECT is left to the dialer, such as when it depends on the identity the request is authenticated with.
+func WithRemoteResolveFunc(fn func(ctx context.Context) bool) Option {
+	return func(req *Request) error {
+		req.remoteResolve = fn
+		return nil
+	}
+}
+
+// WithUDPAssociateFunc decides with the context of the request whether UDP ASSOCIATE is supported,
+// such as when the dialer of the authenticated identity only carries TCP. Supported unless set.
+func WithUDPAssociateFunc(fn func(ctx context.Context) bool) Option {
+	return func(req *Request) error {
+		req.udpAssociate = fn
+		return nil
+	}
+}
+
+// WithACL evaluates the access rules before the request is handled, see Request.Authorize.
+func WithACL(a *acl.ACL) Option {
+	return func(req *Request) error {
+		req.acl = a
+		return nil
+	}
+}
+
+// WithScheduleCutOff terminates the requests once the schedules of the access rules no longer allow them,
+// such as at the end of the business hours of the rule allowing them. See Request.CutOff.
+func WithScheduleCutOff(enabled bool) Option {
+	return func(req *Request) error {
+		req.scheduleCutOff = enabled
+		return nil
+	}
+}
@@ -2,17 +2,27 @@ package request
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ardikabs/socks5/pkg/acl"
 	"github.com/ardikabs/socks5/pkg/resolver"
 	"github.com/ardikabs/socks5/pkg/tool/contexts"
 	"github.com/ardikabs/socks5/pkg/tool/proxy"
 	"github.com/ardikabs/socks5/pkg/types"
 )
 
+var (
+	ErrNotAllowed      = fmt.Errorf("request not allowed by the access rules")
+	ErrOutsideSchedule = fmt.Errorf("request no longer allowed by the schedules of the access rules")
+	ErrTooManyTargets  = fmt.Errorf("too many UDP destinations, up to %d per association", maxUDPTargets)
+)
+
 var (
 	DefaultResolver = resolver.BaseResolver{}
 	DefaultDialer   = func(ctx context.Context, network, address string) (net.Conn, error) {
@@ -35,15 +45,35 @@ type DomainResolver interface {
 	Resolve(ctx context.Context, domain string) (net.IP, error)
 }
 
+// ReverseResolver is an interface that resolves the IP address back to a domain name
+type ReverseResolver interface {
+	ReverseResolve(ctx context.Context, ip net.IP) (string, error)
+}
+
 // Request represents a SOCKS request.
 type Request struct {
 	cmder    Cmder
 	dialer   Dialer
 	replier  Replier
 	resolver DomainResolver
+	acl      *acl.ACL
+
+	// scheduleCutOff terminates the request once the schedules of the access rules no longer allow it,
+	// the authorized request being matched again every time they may change the decision.
+	scheduleCutOff bool
+	authorized     *acl.Request
+
+	bindIP        net.IP
+	bindTimeout   time.Duration
+	bindCheckPeer bool
+
+	resolveCommands bool
+	remoteResolve   func(ctx context.Context) bool
+	udpAssociate    func(ctx context.Context) bool
 
 	cmdID   types.CommandID
 	address *types.Address
+	userID  string
 }
 
 func Parse(r io.Reader, replier Replier, opts ...Option) (*Request, error) {
@@ -56,16 +86,9 @@ func Parse(r io.Reader, replier Replier, opts ...Option) (*Request, error) {
 		return nil, fmt.Errorf("%w: %d", types.ErrUnsupportedVersion, header[0])
 	}
 
-	req := &Request{
-		replier:  replier,
-		dialer:   DefaultDialer,
-		resolver: DefaultResolver,
-	}
-
-	for _, opt := range opts {
-		if err := opt(req); err != nil {
-			return nil, err
-		}
+	req, err := newRequest(replier, opts...)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := req.parseCommand(header[1]); err != nil {
@@ -79,10 +102,59 @@ func Parse(r io.Reader, replier Replier, opts ...Option) (*Request, error) {
 	return req, nil
 }
 
+// New creates a request for protocols that carry the command and destination
+// outside of the SOCKS request format, such as HTTP CONNECT.
+func New(cmdID types.CommandID, address *types.Address, replier Replier, opts ...Option) (*Request, error) {
+	req, err := newRequest(replier, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.parseCommand(byte(cmdID)); err != nil {
+		return nil, err
+	}
+
+	req.address = address
+	return req, nil
+}
+
+func newRequest(replier Replier, opts ...Option) (*Request, error) {
+	req := &Request{
+		replier:     replier,
+		dialer:      DefaultDialer,
+		resolver:    DefaultResolver,
+		bindTimeout: DefaultBindTimeout,
+	}
+
+	for _, opt := range opts {
+		if err := opt(req); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
 func (req *Request) parseCommand(cmd byte) error {
 	switch req.cmdID = types.CommandID(cmd); req.cmdID {
 	case types.CommandConnect:
 		req.cmder = req.handleConnect
+	case types.CommandBIND:
+		req.cmder = req.handleBind
+	case types.CommandUDPAssoc:
+		req.cmder = req.handleUDPAssociate
+	case types.CommandResolve:
+		if !req.resolveCommands {
+			return fmt.Errorf("%w: %d", types.ErrUnsupportedCommand, cmd)
+		}
+
+		req.cmder = req.handleResolve
+	case types.CommandResolvePTR:
+		if !req.resolveCommands {
+			return fmt.Errorf("%w: %d", types.ErrUnsupportedCommand, cmd)
+		}
+
+		req.cmder = req.handleResolvePTR
 	default:
 		return fmt.Errorf("%w: %d", types.ErrUnsupportedCommand, cmd)
 	}
@@ -104,6 +176,11 @@ func (req *Request) GetCommand() types.CommandID {
 	return req.cmdID
 }
 
+// GetUserID returns the USERID field of a SOCKS4 request.
+func (req *Request) GetUserID() string {
+	return req.userID
+}
+
 func (req *Request) GetAddress() types.Address {
 	if req.address == nil {
 		return *types.NilAddress
@@ -112,16 +189,156 @@ func (req *Request) GetAddress() types.Address {
 	return *req.address
 }
 
-// Handle processes the SOCKS request.
+// Handle processes the SOCKS request, once allowed by the access rules.
 func (req *Request) Handle(ctx context.Context, clientConn net.Conn) error {
-	return req.cmder(ctx, clientConn)
+	if err := req.Authorize(ctx, clientConn); err != nil {
+		return err
+	}
+
+	ctx, cancel := req.CutOff(ctx)
+	defer cancel()
+
+	if err := req.cmder(ctx, clientConn); err != nil {
+		if cause := context.Cause(ctx); errors.Is(cause, ErrOutsideSchedule) {
+			return cause
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// CutOff returns a context ending once the schedules of the access rules no longer allow the authorized request,
+// under WithScheduleCutOff. The request is matched again every time the schedules may change the decision,
+// and goes on as long as it is allowed. Otherwise, the context is returned as-is.
+func (req *Request) CutOff(ctx context.Context) (context.Context, context.CancelFunc) {
+	if !req.scheduleCutOff || req.authorized == nil {
+		return ctx, func() {}
+	}
+
+	until := req.acl.Until(*req.authorized)
+	if until.IsZero() {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	var (
+		mu      sync.Mutex
+		timer   *time.Timer
+		stopped bool
+		check   func()
+	)
+
+	check = func() {
+		areq := *req.authorized
+		areq.Time = time.Now()
+		if !req.authorize(ctx, areq).Allowed() {
+			cancel(fmt.Errorf("%w, at %s", ErrOutsideSchedule, areq.Time.Format(time.RFC3339)))
+			return
+		}
+
+		next := req.acl.Until(areq)
+		if next.IsZero() {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !stopped {
+			timer = time.AfterFunc(time.Until(next), check)
+		}
+	}
+
+	contexts.GetLogger(ctx).V(1).Info("request will be matched again once its schedule changes", "until", until)
+
+	mu.Lock()
+	timer = time.AfterFunc(time.Until(until), check)
+	mu.Unlock()
+
+	return ctx, func() {
+		mu.Lock()
+		stopped = true
+		timer.Stop()
+		mu.Unlock()
+
+		cancel(nil)
+	}
+}
+
+// Authorize evaluates the access rules for the request, replying ReplyNotAllowed to the client when it is denied.
+// The destination of CONNECT is resolved beforehand, unless left to the dialer, so its IP address is matched too.
+// UDP ASSOCIATE is evaluated for every destination of its datagrams instead.
+func (req *Request) Authorize(ctx context.Context, clientConn net.Conn) error {
+	if req.acl == nil || req.cmdID == types.CommandUDPAssoc {
+		return nil
+	}
+
+	if req.cmdID == types.CommandConnect && req.address.DomainName != "" && req.address.IP == nil && !req.resolvesRemotely(ctx) {
+		// The IP address the rules are matched against is the one dialed, a failed resolution isn't tried again
+		ip, err := req.resolver.Resolve(ctx, req.address.DomainName)
+		if err != nil {
+			if err := req.replier(clientConn, types.ReplyHostUnreach, req.address); err != nil {
+				return fmt.Errorf("failed to send reply: %v", err)
+			}
+
+			return fmt.Errorf("failed to resolve domain name: %s, %v", req.address.DomainName, err)
+		}
+
+		req.address.IP = ip
+	}
+
+	areq := req.aclRequest(ctx, clientConn.RemoteAddr(), req.address)
+	if req.authorize(ctx, areq).Allowed() {
+		req.authorized = &areq
+		return nil
+	}
+
+	if err := req.replier(clientConn, types.ReplyNotAllowed, req.address); err != nil {
+		return fmt.Errorf("failed to send reply: %v", err)
+	}
+
+	return fmt.Errorf("%w: %s", ErrNotAllowed, req.address.String())
+}
+
+// resolvesRemotely reports whether the domain name of CONNECT is left to the dialer.
+func (req *Request) resolvesRemotely(ctx context.Context) bool {
+	return req.remoteResolve != nil && req.remoteResolve(ctx)
+}
+
+// aclRequest is the request the access rules match for the destination, at the current time.
+func (req *Request) aclRequest(ctx context.Context, client net.Addr, dst *types.Address) acl.Request {
+	return acl.Request{
+		Command:     req.cmdID,
+		Client:      acl.ClientAddr(client),
+		Identity:    contexts.GetIdentity(ctx),
+		Destination: *dst,
+		Time:        time.Now(),
+	}
+}
+
+// authorize evaluates the access rules for the request, logging the decision.
+func (req *Request) authorize(ctx context.Context, areq acl.Request) acl.Decision {
+	d := req.acl.Evaluate(areq)
+
+	contexts.GetLogger(ctx).Info("access decision",
+		"command", req.cmdID.String(),
+		"destination", areq.Destination.String(),
+		"action", string(d.Action),
+		"rule", d.RuleID,
+	)
+
+	return d
 }
 
 func (req *Request) handleConnect(ctx context.Context, clientConn net.Conn) error {
 	log := contexts.GetLogger(ctx).WithValues("command", "connect")
 
-	// Attempt to connect to the target address
-	if req.address.DomainName != "" {
+	// Attempt to connect to the target address, the IP address already authorized is dialed as-is,
+	// the domain name is left as-is when the resolution is delegated to the upstream proxy
+	if req.address.DomainName != "" && req.address.IP == nil && !req.resolvesRemotely(ctx) {
 		log = log.WithValues("remoteDomain", req.address.DomainName)
 		log.V(1).Info("resolving domain name")
 
@@ -144,7 +361,15 @@ func (req *Request) handleConnect(ctx context.Context, clientConn net.Conn) erro
 	log.V(1).Info("dialing remote address")
 	targetConn, err := req.dialer(ctx, "tcp", dstAddress)
 	if err != nil {
+		var repErr *types.ReplyError
+
 		switch {
+		case errors.As(err, &repErr):
+			if err := req.replier(clientConn, repErr.Code, req.address); err != nil {
+				return fmt.Errorf("failed to send reply: %v", err)
+			}
+
+			return fmt.Errorf("%w: %s", err, dstAddress)
 		case strings.Contains(err.Error(), "refused"):
 			if err := req.replier(clientConn, types.ReplyConnRefused, req.address); err != nil {
 				return fmt.Errorf("failed to send reply: %v", err)
@@ -159,6 +384,10 @@ func (req *Request) handleConnect(ctx context.Context, clientConn net.Conn) erro
 			return fmt.Errorf("%w: %s", err, dstAddress)
 		}
 
+		if err := req.replier(clientConn, types.ReplyGeneralFailure, req.address); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
 		return fmt.Errorf("failed to connect to target address: %s, %v", dstAddress, err)
 	}
 	defer targetConn.Close()
@@ -177,5 +406,5 @@ func (req *Request) handleConnect(ctx context.Context, clientConn net.Conn) erro
 	log.Info("start proxying", "src", clientConn.RemoteAddr(), "dst", targetConn.RemoteAddr())
 
 	// Start proxying connection between the client and the target host
-	return proxy.Start(clientConn, targetConn)
+	return proxy.StartContext(ctx, clientConn, targetConn)
 }
@@ -0,0 +1,77 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+// handleResolve resolves the requested domain name and replies with the IP address in BND.ADDR.
+func (req *Request) handleResolve(ctx context.Context, clientConn net.Conn) error {
+	log := contexts.GetLogger(ctx).WithValues("command", "resolve")
+
+	if req.address.DomainName == "" {
+		// Nothing to resolve, the client already has the IP address
+		return req.replier(clientConn, types.ReplySucceeded, &types.Address{IP: req.address.IP})
+	}
+
+	log = log.WithValues("remoteDomain", req.address.DomainName)
+	log.V(1).Info("resolving domain name")
+
+	ip, err := req.resolver.Resolve(ctx, req.address.DomainName)
+	if err != nil {
+		if err := req.replier(clientConn, types.ReplyHostUnreach, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("failed to resolve domain name: %s, %v", req.address.DomainName, err)
+	}
+
+	bindAddr := &types.Address{IP: ip}
+
+	log.V(2).Info("sending reply", "bindAddr", bindAddr.String(), "replyCode", types.ReplySucceeded.String())
+	return req.replier(clientConn, types.ReplySucceeded, bindAddr)
+}
+
+// handleResolvePTR resolves the requested IP address and replies with the domain name in BND.ADDR.
+func (req *Request) handleResolvePTR(ctx context.Context, clientConn net.Conn) error {
+	log := contexts.GetLogger(ctx).WithValues("command", "resolve_ptr")
+
+	rr, ok := req.resolver.(ReverseResolver)
+	if !ok {
+		if err := req.replier(clientConn, types.ReplyCommandNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("%w: resolver doesn't support reverse lookup", types.ErrUnsupportedCommand)
+	}
+
+	if req.address.IP == nil {
+		if err := req.replier(clientConn, types.ReplyAddrNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("%w: RESOLVE_PTR requires an IP address", types.ErrUnsupportedAddressType)
+	}
+
+	log = log.WithValues("remoteIP", req.address.IP.String())
+	log.V(1).Info("resolving IP address")
+
+	name, err := rr.ReverseResolve(ctx, req.address.IP)
+	if err != nil {
+		if err := req.replier(clientConn, types.ReplyHostUnreach, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("failed to resolve IP address: %s, %v", req.address.IP, err)
+	}
+
+	bindAddr := &types.Address{DomainName: strings.TrimSuffix(name, ".")}
+
+	log.V(2).Info("sending reply", "bindAddr", bindAddr.String(), "replyCode", types.ReplySucceeded.String())
+	return req.replier(clientConn, types.ReplySucceeded, bindAddr)
+}
@@ -0,0 +1,88 @@
+package request
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+// maxSOCKS4FieldLength bounds the null-terminated USERID and domain name fields.
+const maxSOCKS4FieldLength = 255
+
+// ParseV4 parses a SOCKS4 or SOCKS4a request, the version byte is expected to be consumed already.
+//
+// Reference: https://www.openssh.com/txt/socks4.protocol, https://www.openssh.com/txt/socks4a.protocol
+// +----+----+----+----+----+----+----+----+----+----+....+----+
+// | VN | CD | DSTPORT |      DSTIP        | USERID       |NULL|
+// +----+----+----+----+----+----+----+----+----+----+....+----+
+// | 1  | 1  |    2    |         4         |   variable   | 1  |
+// +----+----+----+----+----+----+----+----+----+----+....+----+
+//
+// SOCKS4a sets DSTIP to 0.0.0.x (x non-zero) and appends the null-terminated domain name after USERID.
+func ParseV4(r io.Reader, replier Replier, opts ...Option) (*Request, error) {
+	header := make([]byte, 7)
+	if _, err := io.ReadAtLeast(r, header, len(header)); err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrRequestHeaderParseFailed, err)
+	}
+
+	req, err := newRequest(replier, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.cmdID = types.CommandID(header[0]); req.cmdID {
+	case types.CommandConnect:
+		req.cmder = req.handleConnect
+	case types.CommandBIND:
+		req.cmder = req.handleBind
+	default:
+		return nil, fmt.Errorf("%w: %d", types.ErrUnsupportedCommand, header[0])
+	}
+
+	req.address = &types.Address{
+		Port: int(header[1])<<8 | int(header[2]),
+		IP:   net.IPv4(header[3], header[4], header[5], header[6]).To4(),
+	}
+
+	userID, err := readNullTerminated(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch USERID: %v", err)
+	}
+	req.userID = userID
+
+	// SOCKS4a, the client couldn't resolve the destination and sends the domain name instead
+	if ip := req.address.IP; ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readNullTerminated(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch domain name: %v", err)
+		}
+
+		req.address.IP = nil
+		req.address.DomainName = domain
+	}
+
+	return req, nil
+}
+
+// readNullTerminated reads a string up to the NULL byte, byte by byte so nothing past it is consumed.
+func readNullTerminated(r io.Reader) (string, error) {
+	buf := make([]byte, 0, 32)
+	b := []byte{0}
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+
+		if b[0] == 0 {
+			return string(buf), nil
+		}
+
+		if len(buf) == maxSOCKS4FieldLength {
+			return "", fmt.Errorf("field exceeds %d bytes", maxSOCKS4FieldLength)
+		}
+
+		buf = append(buf, b[0])
+	}
+}
@@ -0,0 +1,410 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/go-logr/logr"
+)
+
+const (
+	// maxDatagramSize is the largest UDP payload the relay is able to carry.
+	maxDatagramSize = 64 * 1024
+
+	// maxUDPTargets caps the destinations of an association, each of them holding an outbound socket.
+	maxUDPTargets = 256
+
+	// udpTargetIdleTimeout is how long the socket of a destination is kept without traffic either way.
+	udpTargetIdleTimeout = 2 * time.Minute
+
+	// udpResolveTTL is how long the address a domain name resolves to is reused by an association.
+	udpResolveTTL = time.Minute
+)
+
+func (req *Request) handleUDPAssociate(ctx context.Context, clientConn net.Conn) error {
+	log := contexts.GetLogger(ctx).WithValues("command", "udp associate")
+
+	if req.udpAssociate != nil && !req.udpAssociate(ctx) {
+		if err := req.replier(clientConn, types.ReplyCommandNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("UDP ASSOCIATE not supported by the dialer")
+	}
+
+	// The relay listens on the same interface the client used to reach the server,
+	// so the BND.ADDR in the reply is reachable from the client.
+	localAddr := clientConn.LocalAddr().(*net.TCPAddr)
+	relayConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localAddr.IP})
+	if err != nil {
+		if err := req.replier(clientConn, types.ReplyGeneralFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %v", err)
+		}
+
+		return fmt.Errorf("failed to open UDP relay: %v", err)
+	}
+	defer relayConn.Close()
+
+	relayAddr := relayConn.LocalAddr().(*net.UDPAddr)
+	bindAddr := &types.Address{
+		IP:   relayAddr.IP,
+		Port: relayAddr.Port,
+	}
+
+	log.V(2).Info("sending reply", "bindAddr", bindAddr.String(), "replyCode", types.ReplySucceeded.String())
+	if err := req.replier(clientConn, types.ReplySucceeded, bindAddr); err != nil {
+		return fmt.Errorf("failed to send reply: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A UDP association terminates when the TCP connection that the UDP ASSOCIATE
+	// request arrived on terminates.
+	go func() {
+		_, _ = io.Copy(io.Discard, clientConn)
+		cancel()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		relayConn.Close()
+	}()
+
+	relay := &udpRelay{
+		conn:     relayConn,
+		dialer:   req.dialer,
+		resolver: req.resolver,
+		log:      log,
+		targets:  make(map[string]*udpTarget),
+		resolved: make(map[string]udpResolved),
+	}
+
+	if req.acl != nil {
+		relay.decisions = make(map[string]udpDecision)
+		relay.authorize = func(ctx context.Context, dst *types.Address) udpDecision {
+			areq := req.aclRequest(ctx, clientConn.RemoteAddr(), dst)
+			return udpDecision{allowed: req.authorize(ctx, areq).Allowed(), until: req.acl.Until(areq)}
+		}
+	}
+
+	// The client may announce the address it will send datagrams from,
+	// otherwise only its TCP source IP is known upfront.
+	relay.clientIP = clientConn.RemoteAddr().(*net.TCPAddr).IP
+	if req.address != nil && req.address.IP != nil && !req.address.IP.IsUnspecified() {
+		relay.clientIP = req.address.IP
+	}
+	if req.address != nil {
+		relay.clientPort = req.address.Port
+	}
+
+	log.Info("start relaying", "src", clientConn.RemoteAddr(), "relay", relayAddr)
+	defer relay.close()
+
+	return relay.serve(ctx)
+}
+
+// udpRelay relays datagrams between a single SOCKS client and its targets.
+type udpRelay struct {
+	conn     *net.UDPConn
+	dialer   Dialer
+	resolver DomainResolver
+	log      logr.Logger
+
+	clientIP   net.IP
+	clientPort int
+	clientAddr *net.UDPAddr
+
+	// authorize evaluates the access rules for a destination, it is nil without access rules.
+	authorize func(ctx context.Context, dst *types.Address) udpDecision
+
+	// targets and decisions are keyed by the destination as requested, along with its resolved IP address,
+	// so the domain names sharing an IP address don't share a socket the access rules may close.
+	// Both are bounded by maxUDPTargets, and forgotten once the destination is idle.
+	// The destinations being dialed are counted in dialing, which reserves their slots.
+	mu        sync.Mutex
+	targets   map[string]*udpTarget
+	decisions map[string]udpDecision
+	dialing   int
+
+	// resolved caches the addresses of the domain names, rather than resolving them for every datagram.
+	resolved map[string]udpResolved
+}
+
+// udpTarget is the outbound socket of a destination, along with the last time it carried a datagram.
+type udpTarget struct {
+	conn     net.Conn
+	lastUsed atomic.Int64
+}
+
+func (t *udpTarget) touch() {
+	t.lastUsed.Store(time.Now().UnixNano())
+}
+
+func (t *udpTarget) idle() bool {
+	return time.Since(time.Unix(0, t.lastUsed.Load())) >= udpTargetIdleTimeout
+}
+
+// udpResolved is the address a domain name resolved to, until it is resolved again.
+type udpResolved struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// udpDecision is the decision of the access rules on a destination, until their schedules may change it.
+type udpDecision struct {
+	allowed bool
+	until   time.Time
+}
+
+func (r *udpRelay) serve(ctx context.Context) error {
+	buf := make([]byte, maxDatagramSize)
+
+	for {
+		n, srcAddr, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to read from UDP relay: %v", err)
+		}
+
+		if !r.acceptFrom(srcAddr) {
+			r.log.V(1).Info("dropping datagram from unexpected source", "src", srcAddr)
+			continue
+		}
+
+		dgram, err := types.ParseDatagram(buf[:n])
+		if err != nil {
+			r.log.V(1).Info("dropping malformed datagram", "src", srcAddr, "error", err.Error())
+			continue
+		}
+
+		// Fragmentation is optional, datagrams with non-zero FRAG are dropped.
+		if dgram.Frag != 0 {
+			r.log.V(1).Info("dropping fragmented datagram", "src", srcAddr, "frag", dgram.Frag)
+			continue
+		}
+
+		targetConn, err := r.target(ctx, dgram.Address)
+		if err != nil {
+			r.log.V(1).Info("dropping datagram, target unavailable", "dst", dgram.Address.String(), "error", err.Error())
+			continue
+		}
+
+		if _, err := targetConn.Write(dgram.Data); err != nil {
+			r.log.V(1).Info("failed to forward datagram", "dst", dgram.Address.String(), "error", err.Error())
+		}
+	}
+}
+
+// acceptFrom reports whether a datagram from src belongs to the associated client.
+// The first accepted datagram pins the client's UDP source address.
+func (r *udpRelay) acceptFrom(src *net.UDPAddr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.clientAddr != nil {
+		return r.clientAddr.IP.Equal(src.IP) && r.clientAddr.Port == src.Port
+	}
+
+	if !r.clientIP.Equal(src.IP) {
+		return false
+	}
+
+	if r.clientPort != 0 && r.clientPort != src.Port {
+		return false
+	}
+
+	r.clientAddr = src
+	return true
+}
+
+// target returns the outbound socket for the given destination, dialing it on first use.
+func (r *udpRelay) target(ctx context.Context, addr *types.Address) (net.Conn, error) {
+	if addr.DomainName != "" {
+		ip, err := r.resolve(ctx, addr.DomainName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve domain name: %s, %v", addr.DomainName, err)
+		}
+
+		addr.IP = ip
+	}
+
+	key := addr.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.authorize != nil {
+		// The decision is taken once per destination, rather than logged for every datagram,
+		// and taken again once the schedules of the access rules may change it
+		d, ok := r.decisions[key]
+		if !ok || (!d.until.IsZero() && !time.Now().Before(d.until)) {
+			if !ok && len(r.decisions) >= maxUDPTargets {
+				r.sweepDecisions()
+			}
+
+			d = r.authorize(ctx, addr)
+			r.decisions[key] = d
+		}
+
+		if !d.allowed {
+			// The replies of a destination no longer allowed are not relayed either
+			if t, ok := r.targets[key]; ok {
+				delete(r.targets, key)
+				t.conn.Close()
+			}
+
+			return nil, fmt.Errorf("%w: %s", ErrNotAllowed, key)
+		}
+	}
+
+	if t, ok := r.targets[key]; ok {
+		t.touch()
+		return t.conn, nil
+	}
+
+	if len(r.targets)+r.dialing >= maxUDPTargets {
+		return nil, ErrTooManyTargets
+	}
+
+	// The slot is reserved while dialing without the lock, so the other destinations are not held up
+	r.dialing++
+	r.mu.Unlock()
+	conn, err := r.dialer(ctx, "udp", addr.Address())
+	r.mu.Lock()
+	r.dialing--
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Another datagram to the same destination may have dialed it meanwhile
+	if t, ok := r.targets[key]; ok {
+		conn.Close()
+		t.touch()
+		return t.conn, nil
+	}
+
+	t := &udpTarget{conn: conn}
+	t.touch()
+
+	r.targets[key] = t
+	go r.relayBack(key, t, addr)
+
+	return conn, nil
+}
+
+// resolve returns the address of the domain name, resolving it again once udpResolveTTL has elapsed.
+func (r *udpRelay) resolve(ctx context.Context, domain string) (net.IP, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	res, ok := r.resolved[domain]
+	r.mu.Unlock()
+
+	if ok && now.Before(res.expires) {
+		return res.ip, nil
+	}
+
+	ip, err := r.resolver.Resolve(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.resolved[domain]; !ok && len(r.resolved) >= maxUDPTargets {
+		for name, res := range r.resolved {
+			if !now.Before(res.expires) {
+				delete(r.resolved, name)
+			}
+		}
+
+		if len(r.resolved) >= maxUDPTargets {
+			clear(r.resolved)
+		}
+	}
+
+	r.resolved[domain] = udpResolved{ip: ip, expires: now.Add(udpResolveTTL)}
+	return ip, nil
+}
+
+// sweepDecisions drops the decisions on the destinations without a socket, such as the denied ones,
+// they are taken again on their next datagram. It is called with the lock held.
+func (r *udpRelay) sweepDecisions() {
+	for key := range r.decisions {
+		if _, ok := r.targets[key]; !ok {
+			delete(r.decisions, key)
+		}
+	}
+}
+
+// relayBack encapsulates datagrams received from a target and sends them to the client,
+// until the target is closed or idle.
+func (r *udpRelay) relayBack(key string, t *udpTarget, addr *types.Address) {
+	defer r.forget(key, t)
+
+	buf := make([]byte, maxDatagramSize)
+	src := &types.Address{IP: addr.IP, Port: addr.Port}
+
+	for {
+		_ = t.conn.SetReadDeadline(time.Now().Add(udpTargetIdleTimeout))
+
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			// The deadline is pushed back while datagrams are still sent to the target
+			if errors.Is(err, os.ErrDeadlineExceeded) && !t.idle() {
+				continue
+			}
+
+			return
+		}
+
+		t.touch()
+
+		r.mu.Lock()
+		clientAddr := r.clientAddr
+		r.mu.Unlock()
+
+		dgram := types.Datagram{Address: src, Data: buf[:n]}
+		if _, err := r.conn.WriteToUDP(dgram.Bytes(), clientAddr); err != nil {
+			r.log.V(1).Info("failed to relay datagram to client", "src", src.String(), "error", err.Error())
+		}
+	}
+}
+
+// forget drops a target socket that is idle or no longer readable, it is re-dialed on the next datagram.
+func (r *udpRelay) forget(key string, t *udpTarget) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.targets[key] == t {
+		delete(r.targets, key)
+		delete(r.decisions, key)
+	}
+
+	t.conn.Close()
+}
+
+func (r *udpRelay) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, t := range r.targets {
+		t.conn.Close()
+		delete(r.targets, key)
+	}
+}
@@ -2,6 +2,7 @@ package resolver
 
 import (
 	"context"
+	"fmt"
 	"net"
 )
 
@@ -15,3 +16,16 @@ func (d BaseResolver) Resolve(ctx context.Context, domain string) (net.IP, error
 
 	return addr.IP, nil
 }
+
+func (d BaseResolver) ReverseResolve(ctx context.Context, ip net.IP) (string, error) {
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+	if err != nil {
+		return "", err
+	}
+
+	if len(names) == 0 {
+		return "", fmt.Errorf("no PTR record found for %s", ip)
+	}
+
+	return names[0], nil
+}
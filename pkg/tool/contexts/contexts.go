@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
 	"github.com/go-logr/logr"
 )
 
@@ -33,6 +34,16 @@ func GetAuth(ctx context.Context) *auth.AuthContext {
 	return authContext
 }
 
+// GetIdentity returns the identity of the authenticated user, it is nil for anonymous clients.
+func GetIdentity(ctx context.Context) *credentials.Identity {
+	authContext := GetAuth(ctx)
+	if authContext == nil {
+		return nil
+	}
+
+	return authContext.Identity
+}
+
 func GetConnID(ctx context.Context) string {
 	v := ctx.Value(connIDKey{})
 	if v == nil {
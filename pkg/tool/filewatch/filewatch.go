@@ -0,0 +1,78 @@
+// Package filewatch notifies about changes of a file, with inotify and polling as a fallback.
+package filewatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultInterval is the polling interval used when none is given.
+const DefaultInterval = 10 * time.Second
+
+// Watch calls onChange every time the file changes, until the context is done.
+//
+// The parent directory is watched rather than the file itself, so editors and tools that
+// replace the file by renaming a new one over it are detected. The file is also polled
+// at the given interval, for filesystems where inotify isn't available (NFS, some containers).
+func Watch(ctx context.Context, filename string, interval time.Duration, onChange func()) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	var events <-chan fsnotify.Event
+	if w, err := fsnotify.NewWatcher(); err == nil {
+		defer w.Close()
+
+		if err := w.Add(filepath.Dir(filename)); err == nil {
+			events = w.Events
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := stat(filename)
+	target := filepath.Clean(filename)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+
+			if filepath.Clean(ev.Name) != target || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+
+			last = stat(filename)
+			onChange()
+		case <-ticker.C:
+			if current := stat(filename); current != last {
+				last = current
+				onChange()
+			}
+		}
+	}
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+func stat(filename string) fileState {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{size: fi.Size(), modTime: fi.ModTime()}
+}
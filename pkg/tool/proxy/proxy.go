@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"io"
 
 	"golang.org/x/sync/errgroup"
@@ -17,6 +18,17 @@ func Start(src, dst io.ReadWriter) error {
 	return g.Wait()
 }
 
+// StartContext is like Start, but closes both ends once the context is done, terminating the tunnel.
+func StartContext(ctx context.Context, src, dst io.ReadWriteCloser) error {
+	stop := context.AfterFunc(ctx, func() {
+		src.Close()
+		dst.Close()
+	})
+	defer stop()
+
+	return Start(src, dst)
+}
+
 func proxy(src io.Reader, dst io.Writer) error {
 	_, err := io.Copy(dst, src)
 	return err
@@ -23,7 +23,7 @@ type Address struct {
 
 func NewAddress(r io.Reader) (*Address, error) {
 	atype := make([]byte, 1)
-	if _, err := r.Read(atype); err != nil {
+	if _, err := io.ReadFull(r, atype); err != nil {
 		return nil, fmt.Errorf("failed to fetch SOCKS address type: %v", err)
 	}
 
@@ -32,19 +32,19 @@ func NewAddress(r io.Reader) (*Address, error) {
 	switch AddressType(atype[0]) {
 	case AddressIPv4:
 		ip := make([]byte, 4)
-		if _, err := r.Read(ip); err != nil {
+		if _, err := io.ReadFull(r, ip); err != nil {
 			return nil, fmt.Errorf("failed to fetch IPv4 address: %v", err)
 		}
 		address.IP = net.IP(ip)
 
 	case AddressDomainName:
 		domainLength := make([]byte, 1)
-		if _, err := r.Read(domainLength); err != nil {
-			return nil, fmt.Errorf("failed to fetch IPv4 address: %v", err)
+		if _, err := io.ReadFull(r, domainLength); err != nil {
+			return nil, fmt.Errorf("failed to fetch domain name length: %v", err)
 		}
 
 		domain := make([]byte, int(domainLength[0]))
-		if _, err := io.ReadAtLeast(r, domain, int(domainLength[0])); err != nil {
+		if _, err := io.ReadFull(r, domain); err != nil {
 			return nil, fmt.Errorf("failed to fetch domain name: %v", err)
 		}
 
@@ -52,7 +52,7 @@ func NewAddress(r io.Reader) (*Address, error) {
 
 	case AddressIPv6:
 		ip := make([]byte, 16)
-		if _, err := r.Read(ip); err != nil {
+		if _, err := io.ReadFull(r, ip); err != nil {
 			return nil, fmt.Errorf("failed to fetch IPv6 address: %v", err)
 		}
 		address.IP = net.IP(ip)
@@ -61,7 +61,7 @@ func NewAddress(r io.Reader) (*Address, error) {
 	}
 
 	port := make([]byte, 2)
-	if _, err := r.Read(port); err != nil {
+	if _, err := io.ReadFull(r, port); err != nil {
 		return nil, fmt.Errorf("failed to fetch port: %v", err)
 	}
 
@@ -69,6 +69,28 @@ func NewAddress(r io.Reader) (*Address, error) {
 	return address, nil
 }
 
+// ParseAddress parses "host:port", the host is either an IP address or a domain name.
+func ParseAddress(hostport string) (*Address, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %v", port, err)
+	}
+
+	addr := &Address{Port: int(portNum)}
+	if ip := net.ParseIP(host); ip != nil {
+		addr.IP = ip
+	} else {
+		addr.DomainName = host
+	}
+
+	return addr, nil
+}
+
 func (a Address) Bytes() []byte {
 	var (
 		atype uint8
@@ -0,0 +1,59 @@
+package types
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Datagram represents a UDP datagram relayed through a UDP ASSOCIATE.
+//
+// Reference: https://datatracker.ietf.org/doc/html/rfc1928#section-7
+// +----+------+------+----------+----------+----------+
+// |RSV | FRAG | ATYP | DST.ADDR | DST.PORT |   DATA   |
+// +----+------+------+----------+----------+----------+
+// | 2  |  1   |  1   | Variable |    2     | Variable |
+// +----+------+------+----------+----------+----------+
+type Datagram struct {
+	Frag    uint8
+	Address *Address
+	Data    []byte
+}
+
+// ParseDatagram decodes the SOCKS UDP request header and its payload.
+func ParseDatagram(b []byte) (*Datagram, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("%w: datagram too short (%d bytes)", ErrDatagramParseFailed, len(b))
+	}
+
+	if b[0] != 0 || b[1] != 0 {
+		return nil, fmt.Errorf("%w: non-zero reserved field", ErrDatagramParseFailed)
+	}
+
+	r := bytes.NewReader(b[3:])
+	addr, err := NewAddress(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDatagramParseFailed, err)
+	}
+
+	return &Datagram{
+		Frag:    b[2],
+		Address: addr,
+		Data:    b[len(b)-r.Len():],
+	}, nil
+}
+
+// Bytes encodes the datagram with its SOCKS UDP request header.
+func (d Datagram) Bytes() []byte {
+	addr := d.Address
+	if addr == nil {
+		addr = NilAddress
+	}
+
+	addrBytes := addr.Bytes()
+
+	msg := make([]byte, 3+len(addrBytes)+len(d.Data))
+	msg[2] = d.Frag
+	copy(msg[3:], addrBytes)
+	copy(msg[3+len(addrBytes):], d.Data)
+	return msg
+}
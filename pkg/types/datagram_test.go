@@ -0,0 +1,38 @@
+package types
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDatagram(t *testing.T) {
+	dgram, err := ParseDatagram([]byte{0x00, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, 0x04, 0x38, 'h', 'i'})
+	require.NoError(t, err)
+	require.True(t, net.IPv4(127, 0, 0, 1).Equal(dgram.Address.IP))
+	require.Equal(t, 1080, dgram.Address.Port)
+	require.Equal(t, []byte("hi"), dgram.Data)
+
+	dgram, err = ParseDatagram([]byte{0x00, 0x00, 0x00, 0x03, 0x03, 'f', 'o', 'o', 0x00, 0x35})
+	require.NoError(t, err)
+	require.Equal(t, "foo", dgram.Address.DomainName)
+	require.Equal(t, 53, dgram.Address.Port)
+	require.Empty(t, dgram.Data)
+
+	for name, b := range map[string][]byte{
+		"too short":          {0x00, 0x00, 0x00},
+		"reserved field":     {0x00, 0x01, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, 0x04, 0x38},
+		"truncated IPv4":     {0x00, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00},
+		"truncated IPv6":     {0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"truncated domain":   {0x00, 0x00, 0x00, 0x03, 0x03, 'f', 'o'},
+		"truncated port":     {0x00, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, 0x04},
+		"missing port":       {0x00, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01},
+		"unsupported family": {0x00, 0x00, 0x00, 0x02, 0x7F, 0x00, 0x00, 0x01, 0x04, 0x38},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseDatagram(b)
+			require.ErrorIs(t, err, ErrDatagramParseFailed)
+		})
+	}
+}
@@ -8,4 +8,23 @@ var (
 	ErrUnsupportedUserPassAuthVersion = fmt.Errorf("unsupported user/pass auth version")
 	ErrUnsupportedCommand             = fmt.Errorf("unsupported command")
 	ErrUnsupportedAddressType         = fmt.Errorf("unsupported address type")
+	ErrDatagramParseFailed            = fmt.Errorf("failed to parse SOCKS UDP datagram")
 )
+
+// ReplyError is an error carrying the reply code to send to, or received from, a SOCKS peer.
+type ReplyError struct {
+	Code ReplyCode
+	Err  error
+}
+
+func (e *ReplyError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Code, e.Err)
+	}
+
+	return e.Code.String()
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
@@ -5,6 +5,12 @@ import "fmt"
 // SOCKS version, which is 5
 const VERSION = uint8(5)
 
+// SOCKS version 4, also used by SOCKS4a
+const VERSION4 = uint8(4)
+
+// USERNAME/PASSWORD sub-negotiation version, which is 1
+const UserPassVersion = uint8(1)
+
 const (
 	// Authentication methods
 	AuthNoAuthRequired     = AuthMethod(0)   // 0x00
@@ -24,10 +30,14 @@ func (a AuthMethod) String() string {
 	case AuthUserPass:
 		return fmt.Sprintf("USERNAME/PASSWORD (%d)", a)
 	case AuthNoAcceptableMethod:
-		fallthrough
-	default:
 		return fmt.Sprintf("NOT ACCEPTABLE METHODS (%d)", a)
 	}
+
+	if a >= 0x80 {
+		return fmt.Sprintf("PRIVATE METHOD (%d)", a)
+	}
+
+	return fmt.Sprintf("IANA ASSIGNED (%d)", a)
 }
 
 const (
@@ -97,6 +107,11 @@ const (
 	CommandConnect  = CommandID(1) // 0x01
 	CommandBIND     = CommandID(2) // 0x02
 	CommandUDPAssoc = CommandID(3) // 0x03
+
+	// Tor extension command codes
+	// Reference: https://spec.torproject.org/socks-extensions.html
+	CommandResolve    = CommandID(240) // 0xF0
+	CommandResolvePTR = CommandID(241) // 0xF1
 )
 
 type CommandID uint8
@@ -109,6 +124,35 @@ func (c CommandID) String() string {
 		return "BIND"
 	case CommandUDPAssoc:
 		return "UDP ASSOCIATE"
+	case CommandResolve:
+		return "RESOLVE"
+	case CommandResolvePTR:
+		return "RESOLVE_PTR"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	// SOCKS4 reply codes
+	ReplyV4Granted          = ReplyCodeV4(90) // 0x5A
+	ReplyV4Rejected         = ReplyCodeV4(91) // 0x5B
+	ReplyV4IdentUnreachable = ReplyCodeV4(92) // 0x5C
+	ReplyV4IdentMismatch    = ReplyCodeV4(93) // 0x5D
+)
+
+type ReplyCodeV4 uint8
+
+func (r ReplyCodeV4) String() string {
+	switch r {
+	case ReplyV4Granted:
+		return "request granted"
+	case ReplyV4Rejected:
+		return "request rejected or failed"
+	case ReplyV4IdentUnreachable:
+		return "identd unreachable"
+	case ReplyV4IdentMismatch:
+		return "user-id mismatch"
 	default:
 		return "unknown"
 	}
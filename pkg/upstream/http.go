@@ -0,0 +1,84 @@
+package upstream
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+func httpConnect(ctx context.Context, conn net.Conn, target *types.Address, username, password string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	hostport := target.Address()
+
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: hostport},
+		Host:   hostport,
+		Header: make(http.Header),
+	}
+
+	if username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := connectReq.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send HTTP CONNECT request: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &types.ReplyError{
+			Code: replyCodeOf(resp.StatusCode),
+			Err:  fmt.Errorf("HTTP CONNECT: %s", resp.Status),
+		}
+	}
+
+	// Keep whatever the proxy sent past the response headers
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+
+	return conn, nil
+}
+
+func replyCodeOf(status int) types.ReplyCode {
+	switch status {
+	case http.StatusForbidden, http.StatusProxyAuthRequired, http.StatusUnauthorized:
+		return types.ReplyNotAllowed
+	case http.StatusGatewayTimeout:
+		return types.ReplyTTLExpired
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return types.ReplyHostUnreach
+	default:
+		return types.ReplyGeneralFailure
+	}
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
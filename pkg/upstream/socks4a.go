@@ -0,0 +1,65 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+func socks4aHandshake(ctx context.Context, conn net.Conn, target *types.Address, userID string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	// +----+----+----+----+----+----+----+----+----+----+....+----+
+	// | VN | CD | DSTPORT |      DSTIP        | USERID       |NULL|
+	// +----+----+----+----+----+----+----+----+----+----+....+----+
+	msg := []byte{types.VERSION4, byte(types.CommandConnect), uint8(target.Port >> 8), uint8(target.Port & 0xff)}
+
+	switch ip := target.IP.To4(); {
+	case target.DomainName != "":
+		// SOCKS4a, the proxy resolves the domain name sent after USERID
+		msg = append(msg, 0, 0, 0, 1)
+	case ip != nil:
+		msg = append(msg, ip...)
+	default:
+		return &types.ReplyError{
+			Code: types.ReplyAddrNotSupported,
+			Err:  fmt.Errorf("SOCKS4a doesn't support IPv6 address: %s", target.IP),
+		}
+	}
+
+	msg = append(msg, userID...)
+	msg = append(msg, 0)
+
+	if target.DomainName != "" {
+		msg = append(msg, target.DomainName...)
+		msg = append(msg, 0)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send SOCKS4a request: %v", err)
+	}
+
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS4a reply: %v", err)
+	}
+
+	switch code := types.ReplyCodeV4(reply[1]); code {
+	case types.ReplyV4Granted:
+		return nil
+	case types.ReplyV4IdentUnreachable, types.ReplyV4IdentMismatch:
+		return &types.ReplyError{Code: types.ReplyNotAllowed, Err: fmt.Errorf("SOCKS4a: %s", code)}
+	default:
+		// SOCKS4 has a single failure code, the cause is unknown
+		return &types.ReplyError{Code: types.ReplyGeneralFailure, Err: fmt.Errorf("SOCKS4a: %s", code)}
+	}
+}
@@ -0,0 +1,151 @@
+// Package upstream dials outbound connections through a chain of upstream proxies.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/request"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+var (
+	ErrUnsupportedNetwork = errors.New("unsupported network for upstream proxies")
+	ErrInvalidProxy       = errors.New("invalid upstream proxy")
+)
+
+// Type is the protocol spoken with an upstream proxy.
+type Type string
+
+const (
+	TypeSOCKS5  = Type("socks5")
+	TypeSOCKS4A = Type("socks4a")
+	TypeHTTP    = Type("http")
+)
+
+// Proxy is a single hop of the chain.
+type Proxy struct {
+	Type    Type
+	Address string
+
+	// Username and Password are used for SOCKS5 USERNAME/PASSWORD authentication,
+	// and for HTTP Proxy-Authorization Basic. SOCKS4a sends Username as the USERID.
+	Username string
+	Password string
+}
+
+func (p Proxy) validate() error {
+	switch p.Type {
+	case TypeSOCKS5, TypeSOCKS4A, TypeHTTP:
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidProxy, p.Type)
+	}
+
+	if _, _, err := net.SplitHostPort(p.Address); err != nil {
+		return fmt.Errorf("%w: %s, %v", ErrInvalidProxy, p.Address, err)
+	}
+
+	return nil
+}
+
+// handshake asks the proxy, over an established connection, to connect to the target address.
+func (p Proxy) handshake(ctx context.Context, conn net.Conn, target *types.Address) (net.Conn, error) {
+	switch p.Type {
+	case TypeSOCKS5:
+		var opts []client.Option
+		if p.Username != "" {
+			opts = append(opts, client.WithUserPass(p.Username, p.Password))
+		}
+
+		_, err := client.New(p.Address, opts...).Handshake(ctx, conn, types.CommandConnect, target)
+		return conn, err
+	case TypeSOCKS4A:
+		return conn, socks4aHandshake(ctx, conn, target, p.Username)
+	case TypeHTTP:
+		return httpConnect(ctx, conn, target, p.Username, p.Password)
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidProxy, p.Type)
+	}
+}
+
+// NewDialer returns a dialer that connects to the target through the ordered chain of proxies,
+// the first proxy is reached with the forward dialer.
+//
+// A failure at any hop is returned as *types.ReplyError, carrying the reply code for the SOCKS client.
+// Target domain names are passed as-is to the last hop, so resolution is left to it.
+func NewDialer(hops []Proxy, forward request.Dialer) (request.Dialer, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("%w: empty chain", ErrInvalidProxy)
+	}
+
+	for _, hop := range hops {
+		if err := hop.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if forward == nil {
+		forward = request.DefaultDialer
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedNetwork, network)
+		}
+
+		target, err := types.ParseAddress(address)
+		if err != nil {
+			return nil, &types.ReplyError{Code: types.ReplyAddrNotSupported, Err: err}
+		}
+
+		conn, err := forward(ctx, "tcp", hops[0].Address)
+		if err != nil {
+			return nil, &types.ReplyError{
+				Code: types.ReplyGeneralFailure,
+				Err:  fmt.Errorf("failed to reach upstream hop 1 (%s): %v", hops[0].Address, err),
+			}
+		}
+
+		for i, hop := range hops {
+			next := target
+			if i+1 < len(hops) {
+				// Intermediate hops are asked to connect to the next proxy
+				next, err = types.ParseAddress(hops[i+1].Address)
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+			}
+
+			hopConn, err := hop.handshake(ctx, conn, next)
+			if err != nil {
+				conn.Close()
+				return nil, hopError(i, hop, err)
+			}
+
+			conn = hopConn
+		}
+
+		return conn, nil
+	}, nil
+}
+
+// hopError keeps the reply code of the hop, failures that carry no reply code are general failures.
+func hopError(i int, hop Proxy, err error) error {
+	code := types.ReplyGeneralFailure
+
+	var repErr *types.ReplyError
+	if errors.As(err, &repErr) {
+		code = repErr.Code
+	}
+
+	return &types.ReplyError{
+		Code: code,
+		Err:  fmt.Errorf("upstream hop %d (%s %s): %w", i+1, hop.Type, hop.Address, err),
+	}
+}
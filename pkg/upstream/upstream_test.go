@@ -0,0 +1,107 @@
+package upstream_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5"
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/ardikabs/socks5/pkg/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, address string, cfg socks5.ServerConfig) {
+	srv, err := socks5.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(srv.Shutdown)
+
+	go func() { srv.ListenAndServe(address) }()
+}
+
+func TestNewDialer(t *testing.T) {
+	// Create dummy HTTP server
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer origin.Close()
+
+	// Create the upstream proxies, one per protocol
+	startServer(t, "127.0.0.1:20087", socks5.ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		UserPassMaps:       map[string]string{"user": "password"},
+	})
+	startServer(t, "127.0.0.1:20088", socks5.ServerConfig{
+		EnableSOCKS4: true,
+	})
+	startServer(t, "127.0.0.1:20089", socks5.ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		EnableHTTP:         true,
+	})
+
+	time.Sleep(20 * time.Millisecond)
+
+	hops := []upstream.Proxy{
+		{Type: upstream.TypeSOCKS5, Address: "127.0.0.1:20087", Username: "user", Password: "password"},
+		{Type: upstream.TypeSOCKS4A, Address: "127.0.0.1:20088", Username: "user"},
+		{Type: upstream.TypeHTTP, Address: "127.0.0.1:20089"},
+	}
+
+	t.Run("chain through every protocol", func(t *testing.T) {
+		d, err := upstream.NewDialer(hops, nil)
+		require.NoError(t, err)
+
+		httpClient := &http.Client{Transport: &http.Transport{DialContext: d}}
+		resp, err := httpClient.Get(origin.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(body))
+	})
+
+	t.Run("domain name is resolved by the last hop", func(t *testing.T) {
+		_, port, err := net.SplitHostPort(origin.Listener.Addr().String())
+		require.NoError(t, err)
+
+		d, err := upstream.NewDialer(hops, nil)
+		require.NoError(t, err)
+
+		conn, err := d(context.Background(), "tcp", net.JoinHostPort("localhost", port))
+		require.NoError(t, err)
+		conn.Close()
+	})
+
+	t.Run("invalid proxy type", func(t *testing.T) {
+		_, err := upstream.NewDialer([]upstream.Proxy{{Type: "socks6", Address: "127.0.0.1:1080"}}, nil)
+		require.ErrorIs(t, err, upstream.ErrInvalidProxy)
+	})
+
+	t.Run("hop failure is replied to the client", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		closedAddr := l.Addr().String()
+		l.Close()
+
+		startServer(t, "127.0.0.1:20090", socks5.ServerConfig{
+			EnabledAuthMethods:    []types.AuthMethod{types.AuthNoAuthRequired},
+			Upstreams:             hops[:1],
+			UpstreamRemoteResolve: true,
+		})
+
+		time.Sleep(20 * time.Millisecond)
+
+		_, err = client.New("127.0.0.1:20090").Dial("tcp", closedAddr)
+
+		var repErr *types.ReplyError
+		require.ErrorAs(t, err, &repErr)
+		require.Equal(t, types.ReplyConnRefused, repErr.Code)
+	})
+}
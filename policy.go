@@ -0,0 +1,191 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/policy"
+	"github.com/ardikabs/socks5/pkg/ratelimit"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/tool/filewatch"
+)
+
+// ruleset is the part of the configuration a policy reload replaces as a whole,
+// the sessions keep the ruleset they started with.
+type ruleset struct {
+	acl     *acl.ACL
+	limiter *ratelimit.Limiter
+
+	// users are the users of the policy file, when the server authenticates against them.
+	users *credentials.UserStore
+
+	defaultEgress *egress
+	egresses      []*egress
+}
+
+type rulesetKey struct{}
+
+func (s *Server) newRuleset(rules []acl.Rule, defaultAction acl.Action, limits []ratelimit.Rule, egresses []Egress) (*ruleset, error) {
+	rs := new(ruleset)
+
+	if len(rules) > 0 || defaultAction != "" {
+		a, err := acl.New(rules, defaultAction)
+		if err != nil {
+			return nil, err
+		}
+
+		rs.acl = a
+	}
+
+	if len(limits) > 0 {
+		l, err := ratelimit.New(limits)
+		if err != nil {
+			return nil, err
+		}
+
+		rs.limiter = l
+	}
+
+	if err := s.setupEgresses(rs, egresses); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// loadPolicy builds a ruleset out of the policy file.
+func (s *Server) loadPolicy() (*ruleset, error) {
+	p, err := policy.Load(s.cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	egresses := make([]Egress, 0, len(p.Egresses))
+	for _, e := range p.Egresses {
+		egresses = append(egresses, Egress(e))
+	}
+
+	rs, err := s.newRuleset(p.Rules, p.Default, p.RateLimits, egresses)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.cfg.PolicyFile, err)
+	}
+
+	if len(p.Users) > 0 {
+		if _, ok := s.cfg.CredentialStore.(*policyStore); !ok {
+			return nil, fmt.Errorf("%s: users are declared, but the server has a credential store of its own", s.cfg.PolicyFile)
+		}
+
+		users, err := credentials.NewUserStore(p.Users)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.cfg.PolicyFile, err)
+		}
+
+		rs.users = users
+	}
+
+	return rs, nil
+}
+
+// setupPolicy loads the policy file, if any, or the ruleset of the server configuration otherwise.
+func (s *Server) setupPolicy() error {
+	if s.cfg.PolicyFile == "" {
+		rs, err := s.newRuleset(s.cfg.AccessRules, s.cfg.AccessDefault, s.cfg.RateLimits, s.cfg.Egresses)
+		if err != nil {
+			return err
+		}
+
+		s.ruleset.Store(rs)
+		return nil
+	}
+
+	if len(s.cfg.AccessRules) > 0 || s.cfg.AccessDefault != "" || len(s.cfg.RateLimits) > 0 || len(s.cfg.Egresses) > 0 {
+		return errors.New("policy file is mutually exclusive with the access rules, rate limits and egresses of the configuration")
+	}
+
+	if s.cfg.CredentialStore == nil {
+		s.cfg.CredentialStore = &policyStore{s: s}
+	}
+
+	rs, err := s.loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	s.ruleset.Store(rs)
+	s.reloadables = append(s.reloadables, reloadable{name: "policy", reload: s.reloadPolicy})
+	s.watchables = append(s.watchables, watchable{name: "policy", watch: func(ctx context.Context, onReload func(error)) {
+		filewatch.Watch(ctx, s.cfg.PolicyFile, s.cfg.PolicyFilePollInterval, func() {
+			onReload(s.reloadPolicy())
+		})
+	}})
+
+	return nil
+}
+
+// reloadPolicy swaps the ruleset for the one of the policy file, the current one is kept if the file fails validation.
+// The live sessions are left untouched, unless their account is revoked and TerminateRevokedSessions is set.
+func (s *Server) reloadPolicy() error {
+	rs, err := s.loadPolicy()
+	if err != nil {
+		return err
+	}
+
+	old := s.ruleset.Swap(rs)
+	for _, e := range append(old.egresses, old.defaultEgress) {
+		e.transport.CloseIdleConnections()
+	}
+
+	s.checkSessions()
+	return nil
+}
+
+// withRuleset pins the current ruleset in the context, for the session to keep it until its end.
+func (s *Server) withRuleset(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rulesetKey{}, s.ruleset.Load())
+}
+
+// rulesetOf returns the ruleset pinned in the context, or the current one.
+func (s *Server) rulesetOf(ctx context.Context) *ruleset {
+	if rs, ok := ctx.Value(rulesetKey{}).(*ruleset); ok {
+		return rs
+	}
+
+	return s.ruleset.Load()
+}
+
+// limitConn throttles the connection to the bandwidth of the identity authenticated in the context.
+func (s *Server) limitConn(ctx context.Context, conn net.Conn) net.Conn {
+	return s.rulesetOf(ctx).limiter.Conn(contexts.GetIdentity(ctx), conn)
+}
+
+// policyStore authenticates against the users of the current policy.
+type policyStore struct {
+	s *Server
+}
+
+func (p *policyStore) Validate(params credentials.Parameters) error {
+	_, err := p.Identify(context.Background(), nil, params)
+	return err
+}
+
+func (p *policyStore) Identify(ctx context.Context, remoteAddr net.Addr, params credentials.Parameters) (*credentials.Identity, error) {
+	users := p.s.ruleset.Load().users
+	if users == nil {
+		return nil, fmt.Errorf("%w, no users are declared in the policy", credentials.ErrInvalidCredentials)
+	}
+
+	return users.Identify(ctx, remoteAddr, params)
+}
+
+func (p *policyStore) Account(userID string) (credentials.Account, bool) {
+	users := p.s.ruleset.Load().users
+	if users == nil {
+		return credentials.Account{}, false
+	}
+
+	return users.Account(userID)
+}
@@ -0,0 +1,147 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Policy(t *testing.T) {
+	// Create dummy server, echoing every connection until the client goes away
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	dummyPort := strconv.Itoa(dummyListener.Addr().(*net.TCPAddr).Port)
+
+	const hash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+	writePolicy := func(t *testing.T, filename, allowed string) {
+		policy := `version: 1
+groups:
+  - name: ops
+users:
+  - name: alice
+    password: "` + hash + `"
+    groups: [ops]
+  - name: bob
+    password: "` + hash + `"
+rules:
+  - id: dummy
+    action: allow
+    ` + allowed + `
+    networks: [127.0.0.0/8]
+    ports: [` + dummyPort + `]
+rate_limits:
+  - users: [bob]
+    requests: 1
+    interval: 1h
+`
+		require.NoError(t, os.WriteFile(filename, []byte(policy), 0o600))
+	}
+
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	writePolicy(t, filename, "groups: [ops]\n    users: [bob]")
+
+	srvAddr := "127.0.0.1:20099"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods:     []types.AuthMethod{types.AuthUserPass},
+		PolicyFile:             filename,
+		PolicyFilePollInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	dial := func(username string) (net.Conn, error) {
+		return client.New(srvAddr, client.WithUserPass(username, "secret")).DialContext(context.Background(), "tcp", dummyListener.Addr().String())
+	}
+
+	requireNotAllowed := func(t *testing.T, err error) {
+		var repErr *types.ReplyError
+		require.ErrorAs(t, err, &repErr)
+		require.Equal(t, types.ReplyNotAllowed, repErr.Code)
+	}
+
+	echo := func(t *testing.T, conn net.Conn) {
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		out := make([]byte, 4)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(out))
+	}
+
+	live, err := dial("alice")
+	require.NoError(t, err)
+	defer live.Close()
+	echo(t, live)
+
+	t.Run("rate limit", func(t *testing.T) {
+		conn, err := dial("bob")
+		require.NoError(t, err)
+		conn.Close()
+
+		_, err = dial("bob")
+		requireNotAllowed(t, err)
+	})
+
+	t.Run("reload applies to new sessions only", func(t *testing.T) {
+		writePolicy(t, filename, "users: [bob]")
+
+		require.Eventually(t, func() bool {
+			return srv.ruleset.Load().acl.Evaluate(acl.Request{}).RuleID == acl.DefaultRuleID
+		}, time.Second, 10*time.Millisecond)
+
+		_, err := dial("alice")
+		requireNotAllowed(t, err)
+
+		// The session started under the previous policy is kept
+		echo(t, live)
+	})
+
+	t.Run("invalid policy is refused", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filename, []byte("version: 1\nrules: [{id: dummy, action: drop}]\n"), 0o600))
+		require.ErrorContains(t, srv.reloadPolicy(), filename+":2:29: rules[0].action: unknown action")
+
+		_, err := dial("alice")
+		requireNotAllowed(t, err)
+	})
+
+	t.Run("mutually exclusive", func(t *testing.T) {
+		_, err := New(ServerConfig{PolicyFile: filename, AccessDefault: acl.ActionAllow})
+		require.Error(t, err)
+
+		writePolicy(t, filename, "users: [bob]")
+		_, err = New(ServerConfig{PolicyFile: filename, UserPassMaps: map[string]string{"alice": "secret"}})
+		require.ErrorContains(t, err, "credential store of its own")
+	})
+}
@@ -0,0 +1,61 @@
+package socks5
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-logr/logr"
+)
+
+// reloadable is a component the server reloads on SIGHUP.
+type reloadable struct {
+	name   string
+	reload func() error
+}
+
+// watchable is a component that reloads itself when its source changes.
+type watchable struct {
+	name  string
+	watch func(ctx context.Context, onReload func(error))
+}
+
+// handleReloads reloads the components on SIGHUP and starts their watchers, until the context is done.
+func (s *Server) handleReloads(ctx context.Context) {
+	log := s.cfg.Logger.WithName("reload")
+
+	for _, w := range s.watchables {
+		go w.watch(ctx, func(err error) {
+			logReload(log, w.name, "file change", err)
+		})
+	}
+
+	if len(s.reloadables) == 0 {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			for _, r := range s.reloadables {
+				logReload(log, r.name, "SIGHUP", r.reload())
+			}
+		}
+	}
+}
+
+func logReload(log logr.Logger, name, trigger string, err error) {
+	if err != nil {
+		log.Error(err, "failed to reload, keeping the previous state", "component", name, "trigger", trigger)
+		return
+	}
+
+	log.Info("reloaded successfully", "component", name, "trigger", trigger)
+}
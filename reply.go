@@ -1,7 +1,9 @@
 package socks5
 
 import (
+	"fmt"
 	"io"
+	"net/http"
 
 	"github.com/ardikabs/socks5/pkg/types"
 )
@@ -21,3 +23,58 @@ func SendReply(w io.Writer, replyCode types.ReplyCode, addr *types.Address) erro
 	_, err := w.Write(msg)
 	return err
 }
+
+// SendReplyV4 sends a SOCKS4 reply, mapping the SOCKS5 reply code onto granted or rejected.
+func SendReplyV4(w io.Writer, replyCode types.ReplyCode, addr *types.Address) error {
+	if replyCode == types.ReplySucceeded {
+		return sendReplyV4(w, types.ReplyV4Granted, addr)
+	}
+
+	return sendReplyV4(w, types.ReplyV4Rejected, addr)
+}
+
+func sendReplyV4(w io.Writer, replyCode types.ReplyCodeV4, addr *types.Address) error {
+	// +----+----+----+----+----+----+----+----+
+	// | VN | CD | DSTPORT |      DSTIP        |
+	// +----+----+----+----+----+----+----+----+
+	// | 1  | 1  |    2    |         4         |
+	// +----+----+----+----+----+----+----+----+
+	msg := make([]byte, 8)
+	msg[1] = uint8(replyCode)
+
+	if addr != nil {
+		msg[2] = uint8(addr.Port >> 8)
+		msg[3] = uint8(addr.Port & 0xff)
+
+		// SOCKS4 is IPv4 only, other address types are replied as zero address
+		if ip := addr.IP.To4(); ip != nil {
+			copy(msg[4:], ip)
+		}
+	}
+
+	_, err := w.Write(msg)
+	return err
+}
+
+// SendReplyHTTP sends the response of an HTTP CONNECT request, mapping the SOCKS reply code onto an HTTP status.
+func SendReplyHTTP(w io.Writer, replyCode types.ReplyCode, _ *types.Address) error {
+	if replyCode == types.ReplySucceeded {
+		_, err := io.WriteString(w, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return err
+	}
+
+	var status int
+	switch replyCode {
+	case types.ReplyNotAllowed:
+		status = http.StatusForbidden
+	case types.ReplyTTLExpired:
+		status = http.StatusGatewayTimeout
+	case types.ReplyCommandNotSupported, types.ReplyAddrNotSupported:
+		status = http.StatusBadRequest
+	default:
+		status = http.StatusBadGateway
+	}
+
+	_, err := fmt.Fprintf(w, "HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", status, http.StatusText(status))
+	return err
+}
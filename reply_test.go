@@ -2,6 +2,7 @@ package socks5
 
 import (
 	"bytes"
+	"net"
 	"testing"
 
 	"github.com/ardikabs/socks5/pkg/types"
@@ -23,3 +24,13 @@ func TestSendReply(t *testing.T) {
 
 	require.Equal(t, want, buf.Bytes())
 }
+
+func TestSendReplyV4(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, SendReplyV4(buf, types.ReplySucceeded, &types.Address{IP: net.IPv4(127, 0, 0, 1), Port: 1080}))
+	require.Equal(t, []byte{0x00, 0x5A, 0x04, 0x38, 0x7F, 0x00, 0x00, 0x01}, buf.Bytes())
+
+	buf.Reset()
+	require.NoError(t, SendReplyV4(buf, types.ReplyConnRefused, nil))
+	require.Equal(t, []byte{0x00, 0x5B, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, buf.Bytes())
+}
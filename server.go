@@ -3,12 +3,17 @@ package socks5
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"os"
+	"sync/atomic"
 
 	"github.com/ardikabs/socks5/pkg/auth"
 	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/auth/lockout"
+	"github.com/ardikabs/socks5/pkg/dialguard"
 	"github.com/ardikabs/socks5/pkg/request"
 	"github.com/ardikabs/socks5/pkg/tool/contexts"
 	"github.com/ardikabs/socks5/pkg/types"
@@ -19,6 +24,16 @@ import (
 type Server struct {
 	cfg ServerConfig
 
+	lockout *lockout.Guard
+	tls     *tlsStore
+
+	dialGuard *dialguard.Guard
+	ruleset   atomic.Pointer[ruleset]
+	sessions  sessions
+
+	reloadables []reloadable
+	watchables  []watchable
+
 	shutdownFn func()
 }
 
@@ -33,9 +48,26 @@ func New(cfg ServerConfig) (*Server, error) {
 		cfg.EnabledAuthMethods = []types.AuthMethod{types.AuthNoAuthRequired, types.AuthUserPass}
 	}
 
+	for i, n := range cfg.NetworkAuthMethods {
+		if len(n.Networks) == 0 || len(n.Methods) == 0 {
+			return nil, fmt.Errorf("network auth methods #%d: both networks and methods are required", i)
+		}
+
+		for _, prefix := range n.Networks {
+			if !prefix.IsValid() {
+				return nil, fmt.Errorf("network auth methods #%d: invalid network %q", i, prefix)
+			}
+		}
+	}
+
 	if cfg.CredentialStore == nil {
 		if cfg.UserPassFilename != "" {
-			cs, err := credentials.NewFileStore(cfg.UserPassFilename)
+			opts := []credentials.FileOption{credentials.WithPollInterval(cfg.UserPassFilePollInterval)}
+			if cfg.UserPassFilePlaintext {
+				opts = append(opts, credentials.WithPlaintext())
+			}
+
+			cs, err := credentials.NewFileStore(cfg.UserPassFilename, opts...)
 			if err != nil {
 				return nil, err
 			}
@@ -47,9 +79,73 @@ func New(cfg ServerConfig) (*Server, error) {
 
 	}
 
-	return &Server{
-		cfg: cfg,
-	}, nil
+	if cfg.Resolver == nil {
+		cfg.Resolver = request.DefaultResolver
+	}
+
+	s := &Server{
+		cfg:      cfg,
+		sessions: sessions{byUser: make(map[string]map[*session]struct{})},
+	}
+
+	if cfg.UserPassLockout != nil {
+		lc := *cfg.UserPassLockout
+		if lc.Logger.IsZero() {
+			lc.Logger = cfg.Logger.WithName("lockout")
+		}
+
+		s.lockout = lockout.New(lc)
+	}
+
+	if cfg.TLS != nil {
+		t, err := newTLSStore(*cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		s.tls = t
+		s.reloadables = append(s.reloadables, reloadable{name: "tls", reload: t.Reload})
+		s.watchables = append(s.watchables, watchable{name: "tls", watch: t.Watch})
+	}
+
+	if cfg.DialGuard != nil {
+		g, err := dialguard.New(*cfg.DialGuard)
+		if err != nil {
+			return nil, err
+		}
+
+		s.dialGuard = g
+	}
+
+	if err := s.setupPolicy(); err != nil {
+		return nil, err
+	}
+
+	// The accounts of the live sessions are checked again every time the credentials reload
+	if r, ok := cfg.CredentialStore.(credentials.Reloader); ok {
+		s.reloadables = append(s.reloadables, reloadable{name: "credentials", reload: func() error {
+			if err := r.Reload(); err != nil {
+				return err
+			}
+
+			s.checkSessions()
+			return nil
+		}})
+	}
+
+	if w, ok := cfg.CredentialStore.(credentials.Watcher); ok {
+		s.watchables = append(s.watchables, watchable{name: "credentials", watch: func(ctx context.Context, onReload func(error)) {
+			w.Watch(ctx, func(err error) {
+				if err == nil {
+					s.checkSessions()
+				}
+
+				onReload(err)
+			})
+		}})
+	}
+
+	return s, nil
 }
 
 func (s *Server) Shutdown() {
@@ -67,12 +163,22 @@ func (s *Server) ListenAndServe(address string) error {
 	}
 	defer listener.Close()
 
+	if s.tls != nil {
+		listener = s.tls.Listener(listener)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	s.shutdownFn = func() {
 		cancel()
 		listener.Close()
 	}
 
+	go s.handleReloads(ctx)
+
+	if s.lockout != nil {
+		go s.lockout.Run(ctx)
+	}
+
 	return s.serve(ctx, listener)
 }
 
@@ -87,12 +193,57 @@ func (s *Server) serve(ctx context.Context, l net.Listener) error {
 	}
 }
 
+// authMethods returns the authentication methods enabled for the client address, in order of preference.
+func (s *Server) authMethods(remote net.Addr) []types.AuthMethod {
+	if len(s.cfg.NetworkAuthMethods) == 0 {
+		return s.cfg.EnabledAuthMethods
+	}
+
+	ap, err := netip.ParseAddrPort(remote.String())
+	if err != nil {
+		return s.cfg.EnabledAuthMethods
+	}
+
+	addr := ap.Addr().Unmap()
+	for _, n := range s.cfg.NetworkAuthMethods {
+		for _, prefix := range n.Networks {
+			if prefix.Contains(addr) {
+				return n.Methods
+			}
+		}
+	}
+
+	return s.cfg.EnabledAuthMethods
+}
+
+func (s *Server) requestOptions(ctx context.Context) []request.Option {
+	rs := s.rulesetOf(ctx)
+
+	return []request.Option{
+		request.WithDialer(s.dial),
+		request.WithResolver(s.cfg.Resolver),
+		request.WithBindTimeout(s.cfg.BindAcceptTimeout),
+		request.WithBindAddress(s.cfg.BindAdvertiseIP),
+		request.WithBindPeerCheck(s.cfg.BindCheckPeer),
+		request.WithResolveCommands(s.cfg.EnableResolveCommands),
+		request.WithRemoteResolveFunc(s.resolvesRemotely),
+		request.WithUDPAssociateFunc(s.relaysUDP),
+		request.WithACL(rs.acl),
+		request.WithScheduleCutOff(s.cfg.TerminateOutsideSchedule),
+	}
+}
+
 func (s *Server) handleConn(baseCtx context.Context, conn net.Conn) {
 	defer conn.Close()
 
 	connID := uuid.New().String()
 	log := s.cfg.Logger.WithName("handleConn").WithValues("connID", connID)
-	ctx := contexts.New(baseCtx, connID, log)
+	ctx := s.withRuleset(contexts.New(baseCtx, connID, log))
+
+	if err := s.handshake(ctx, conn); err != nil {
+		log.Error(err, "failed to complete TLS handshake, closing ...", "phase", "inititation")
+		return
+	}
 
 	version := []byte{0}
 	if _, err := conn.Read(version); err != nil {
@@ -100,14 +251,35 @@ func (s *Server) handleConn(baseCtx context.Context, conn net.Conn) {
 		return
 	}
 
-	// initial check for SOCKS version
-	if version[0] != types.VERSION {
+	switch version[0] {
+	case types.VERSION:
+		s.handleSOCKS5(ctx, conn)
+	case types.VERSION4:
+		if !s.cfg.EnableSOCKS4 {
+			log.Info("SOCKS4 is disabled, closing ...", "phase", "inititation", "version", version[0])
+			return
+		}
+
+		s.handleSOCKS4(ctx, conn)
+	default:
+		if s.cfg.EnableHTTP && isHTTPMethodStart(version[0]) {
+			s.handleHTTP(ctx, conn, version[0])
+			return
+		}
+
 		log.Info("unsupported SOCKS version, closing ...", "phase", "inititation", "version", version[0])
-		return
 	}
+}
+
+func (s *Server) handleSOCKS5(ctx context.Context, conn net.Conn) {
+	log := contexts.GetLogger(ctx)
 
 	// parsing SOCKS authentication
-	authn, err := auth.Parse(conn, s.cfg.EnabledAuthMethods, s.cfg.CredentialStore)
+	authn, err := auth.Parse(conn, s.authMethods(conn.RemoteAddr()), s.cfg.CredentialStore,
+		auth.WithGSSAPI(s.cfg.GSSAPIMechanism),
+		auth.WithRegistry(s.cfg.AuthRegistry),
+		auth.WithLockout(s.lockout),
+	)
 	if err != nil {
 		log.Error(err, "failed to parse SOCKS authentication methods", "phase", "method selection")
 		return
@@ -120,8 +292,15 @@ func (s *Server) handleConn(baseCtx context.Context, conn net.Conn) {
 		return
 	}
 
+	s.identifyClientCertificate(conn, authCtx)
+
+	// the rest of the session is encapsulated by the authentication method, such as GSSAPI
+	if authCtx.Wrap != nil {
+		conn = authCtx.Wrap(conn)
+	}
+
 	// parsing SOCKS request
-	req, err := request.Parse(conn, SendReply, request.WithDialer(s.cfg.Dialer))
+	req, err := request.Parse(conn, SendReply, s.requestOptions(ctx)...)
 	if err != nil {
 		log = log.WithValues("phase", "request parsing")
 
@@ -144,8 +323,18 @@ func (s *Server) handleConn(baseCtx context.Context, conn net.Conn) {
 	}
 
 	// handling SOCKS request
-	reqCtx := contexts.WithAuth(ctx, authCtx)
-	if err := req.Handle(reqCtx, conn); err != nil {
+	reqCtx, release, err := s.startSession(contexts.WithAuth(ctx, authCtx), authCtx)
+	if err != nil {
+		if repErr := SendReply(conn, types.ReplyNotAllowed, nil); repErr != nil {
+			log.Error(repErr, "failed to send SOCKS reply")
+		}
+
+		log.Error(err, "failed to start session", "phase", "session")
+		return
+	}
+	defer release()
+
+	if err := req.Handle(reqCtx, s.limitConn(reqCtx, conn)); err != nil {
 		log.Error(err, "failed to handle SOCKS request", "phase", "request handling")
 		return
 	}
@@ -2,12 +2,26 @@ package socks5
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ardikabs/socks5/pkg/acl"
+	"github.com/ardikabs/socks5/pkg/client"
+	"github.com/ardikabs/socks5/pkg/dialguard"
+	"github.com/ardikabs/socks5/pkg/request"
 	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/go-logr/logr/funcr"
 	"github.com/stretchr/testify/require"
 )
 
@@ -90,3 +104,759 @@ func TestServer_Connect(t *testing.T) {
 
 	require.Equal(t, wants, out)
 }
+
+func TestServer_UDPAssociate(t *testing.T) {
+	// Create dummy UDP echo server
+	echoConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer echoConn.Close()
+
+	echoAddr := echoConn.LocalAddr().(*net.UDPAddr)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echoConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			echoConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	// Create SOCKS5 server
+	srvAddr := "127.0.0.1:20081"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Act as client, to associate UDP relay with the SOCKS5 server
+	conn, err := net.Dial("tcp", srvAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := bytes.NewBuffer(nil)
+	// Initial negotiation
+	req.Write([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired)})
+	// Request
+	req.Write([]byte{types.VERSION, byte(types.CommandUDPAssoc), 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+
+	out := make([]byte, 12)
+	_, err = io.ReadAtLeast(conn, out, len(out))
+	require.NoError(t, err)
+	require.Equal(t, []byte{types.VERSION, byte(types.AuthNoAuthRequired)}, out[:2])
+	require.Equal(t, []byte{types.VERSION, byte(types.ReplySucceeded), 0x00, byte(types.AddressIPv4)}, out[2:6])
+
+	relayAddr := &net.UDPAddr{
+		IP:   net.IP(out[6:10]),
+		Port: int(out[10])<<8 | int(out[11]),
+	}
+
+	udpConn, err := net.DialUDP("udp", nil, relayAddr)
+	require.NoError(t, err)
+	defer udpConn.Close()
+
+	dgram := types.Datagram{
+		Address: &types.Address{IP: echoAddr.IP, Port: echoAddr.Port},
+		Data:    []byte("dummy payload"),
+	}
+
+	_, err = udpConn.Write(dgram.Bytes())
+	require.NoError(t, err)
+
+	require.NoError(t, udpConn.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 1024)
+	n, err := udpConn.Read(buf)
+	require.NoError(t, err)
+
+	got, err := types.ParseDatagram(buf[:n])
+	require.NoError(t, err)
+	require.True(t, echoAddr.IP.Equal(got.Address.IP))
+	require.Equal(t, echoAddr.Port, got.Address.Port)
+	require.Equal(t, "dummy payload", string(got.Data))
+
+	// Closing the controlling TCP connection tears the association down
+	conn.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = udpConn.Write(dgram.Bytes())
+	if err == nil {
+		require.NoError(t, udpConn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, err = udpConn.Read(buf)
+	}
+	require.Error(t, err)
+}
+
+func TestServer_UDPAssociate_ResolveOnce(t *testing.T) {
+	echoConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer echoConn.Close()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := echoConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			echoConn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	resolver := &countingResolver{DomainResolver: stubResolver{"echo.test": net.IPv4(127, 0, 0, 1)}}
+
+	srvAddr := "127.0.0.1:20101"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		Resolver:           resolver,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", srvAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired), types.VERSION, byte(types.CommandUDPAssoc), 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	require.NoError(t, err)
+
+	out := make([]byte, 12)
+	_, err = io.ReadAtLeast(conn, out, len(out))
+	require.NoError(t, err)
+	require.Equal(t, byte(types.ReplySucceeded), out[3])
+
+	udpConn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IP(out[6:10]), Port: int(out[10])<<8 | int(out[11])})
+	require.NoError(t, err)
+	defer udpConn.Close()
+
+	dgram := types.Datagram{
+		Address: &types.Address{DomainName: "echo.test", Port: echoConn.LocalAddr().(*net.UDPAddr).Port},
+		Data:    []byte("ping"),
+	}
+
+	// The domain name is resolved on the first datagram only
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		_, err = udpConn.Write(dgram.Bytes())
+		require.NoError(t, err)
+
+		require.NoError(t, udpConn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := udpConn.Read(buf)
+		require.NoError(t, err)
+
+		got, err := types.ParseDatagram(buf[:n])
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(got.Data))
+	}
+
+	require.EqualValues(t, 1, resolver.calls.Load())
+}
+
+type countingResolver struct {
+	request.DomainResolver
+	calls atomic.Int32
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, domain string) (net.IP, error) {
+	r.calls.Add(1)
+	return r.DomainResolver.Resolve(ctx, domain)
+}
+
+func TestServer_Bind(t *testing.T) {
+	// Create SOCKS5 server
+	srvAddr := "127.0.0.1:20082"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		BindCheckPeer:      true,
+		Resolver:           stubResolver{},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Act as client, to request an inbound connection from the SOCKS5 server
+	conn, err := net.Dial("tcp", srvAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := bytes.NewBuffer(nil)
+	// Initial negotiation
+	req.Write([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired)})
+	// Request, expecting the inbound connection from 127.0.0.1
+	req.Write([]byte{types.VERSION, byte(types.CommandBIND), 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, 0x00, 0x00})
+
+	_, err = conn.Write(req.Bytes())
+	require.NoError(t, err)
+
+	out := make([]byte, 12)
+	_, err = io.ReadAtLeast(conn, out, len(out))
+	require.NoError(t, err)
+	require.Equal(t, []byte{types.VERSION, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01}, out[2:10])
+
+	listenAddr := &net.TCPAddr{
+		IP:   net.IP(out[6:10]),
+		Port: int(out[10])<<8 | int(out[11]),
+	}
+
+	// Act as the application server, connecting to the listening socket
+	peerConn, err := net.DialTCP("tcp", nil, listenAddr)
+	require.NoError(t, err)
+	defer peerConn.Close()
+
+	peerAddr := peerConn.LocalAddr().(*net.TCPAddr)
+
+	out = make([]byte, 10)
+	_, err = io.ReadAtLeast(conn, out, len(out))
+	require.NoError(t, err)
+	require.Equal(t, []byte{
+		types.VERSION, 0x00, 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, uint8(peerAddr.Port >> 8), uint8(peerAddr.Port & 0xFF),
+	}, out)
+
+	// Traffic is spliced between the client and the application server
+	_, err = peerConn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	out = make([]byte, 4)
+	_, err = io.ReadAtLeast(conn, out, len(out))
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(out))
+
+	// An expected peer that can't be resolved is the only reply, no listening socket is advertised first
+	conn, err = net.Dial("tcp", srvAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired), types.VERSION, byte(types.CommandBIND), 0x00, 0x03, 0x04, 'n', 'o', 'n', 'e', 0x00, 0x00})
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	out, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, []byte{types.VERSION, byte(types.AuthNoAuthRequired), types.VERSION, byte(types.ReplyHostUnreach), 0x00, 0x03, 0x04, 'n', 'o', 'n', 'e', 0x00, 0x00}, out)
+}
+
+type stubResolver map[string]net.IP
+
+func (r stubResolver) Resolve(_ context.Context, domain string) (net.IP, error) {
+	ip, ok := r[domain]
+	if !ok {
+		return nil, fmt.Errorf("no such host: %s", domain)
+	}
+
+	return ip, nil
+}
+
+func (r stubResolver) ReverseResolve(_ context.Context, ip net.IP) (string, error) {
+	for domain, v := range r {
+		if v.Equal(ip) {
+			return domain + ".", nil
+		}
+	}
+
+	return "", fmt.Errorf("no PTR record found for %s", ip)
+}
+
+func TestServer_Resolve(t *testing.T) {
+	// Create SOCKS5 server
+	srvAddr := "127.0.0.1:20085"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods:    []types.AuthMethod{types.AuthNoAuthRequired},
+		Resolver:              stubResolver{"example.com": net.IPv4(93, 184, 216, 34)},
+		EnableResolveCommands: true,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	resolve := func(t *testing.T, request []byte, replyLength int) []byte {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write(append([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired)}, request...))
+		require.NoError(t, err)
+
+		out := make([]byte, 2+replyLength)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+
+		return out[2:]
+	}
+
+	t.Run("RESOLVE", func(t *testing.T) {
+		req := []byte{types.VERSION, byte(types.CommandResolve), 0x00, 0x03, 11}
+		req = append(req, "example.com"...)
+		req = append(req, 0x00, 0x00)
+
+		require.Equal(t, []byte{types.VERSION, 0x00, 0x00, 0x01, 93, 184, 216, 34, 0x00, 0x00}, resolve(t, req, 10))
+	})
+
+	t.Run("RESOLVE unknown domain", func(t *testing.T) {
+		req := []byte{types.VERSION, byte(types.CommandResolve), 0x00, 0x03, 11}
+		req = append(req, "example.org"...)
+		req = append(req, 0x00, 0x00)
+
+		require.Equal(t, byte(types.ReplyHostUnreach), resolve(t, req, 10)[1])
+	})
+
+	t.Run("RESOLVE_PTR", func(t *testing.T) {
+		req := []byte{types.VERSION, byte(types.CommandResolvePTR), 0x00, 0x01, 93, 184, 216, 34, 0x00, 0x00}
+
+		want := []byte{types.VERSION, 0x00, 0x00, 0x03, 11}
+		want = append(want, "example.com"...)
+		want = append(want, 0x00, 0x00)
+		require.Equal(t, want, resolve(t, req, len(want)))
+	})
+}
+
+func TestServer_NetworkAuthMethods(t *testing.T) {
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthUserPass},
+		NetworkAuthMethods: []NetworkAuthMethods{
+			{Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, Methods: []types.AuthMethod{types.AuthGSSAPI}},
+			{Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, Methods: []types.AuthMethod{types.AuthNoAuthRequired}},
+		},
+		UserPassMaps: map[string]string{"user": "password"},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	t.Run("method lists by client address", func(t *testing.T) {
+		methods := func(ip string) []types.AuthMethod {
+			return srv.authMethods(&net.TCPAddr{IP: net.ParseIP(ip), Port: 40000})
+		}
+
+		require.Equal(t, []types.AuthMethod{types.AuthGSSAPI}, methods("10.1.2.3"))
+		require.Equal(t, []types.AuthMethod{types.AuthNoAuthRequired}, methods("::ffff:127.0.0.1"))
+		require.Equal(t, []types.AuthMethod{types.AuthUserPass}, methods("192.0.2.1"))
+	})
+
+	t.Run("negotiation", func(t *testing.T) {
+		srvAddr := "127.0.0.1:20091"
+		go func() { srv.ListenAndServe(srvAddr) }()
+
+		time.Sleep(20 * time.Millisecond)
+
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte{types.VERSION, 0x02, byte(types.AuthNoAuthRequired), byte(types.AuthUserPass)})
+		require.NoError(t, err)
+
+		out := make([]byte, 2)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, []byte{types.VERSION, byte(types.AuthNoAuthRequired)}, out)
+	})
+
+	_, err = New(ServerConfig{
+		NetworkAuthMethods: []NetworkAuthMethods{{Networks: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}},
+	})
+	require.Error(t, err)
+}
+
+func TestServer_AccessRules(t *testing.T) {
+	// Create dummy servers, only the first one is allowed
+	allowedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer allowedListener.Close()
+
+	deniedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer deniedListener.Close()
+
+	go func() {
+		for {
+			conn, err := allowedListener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte{'o', 'k'})
+			conn.Close()
+		}
+	}()
+
+	allowedPort := uint16(allowedListener.Addr().(*net.TCPAddr).Port)
+
+	var (
+		mu   sync.Mutex
+		logs []string
+	)
+
+	srvAddr := "127.0.0.1:20096"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		EnableHTTP:         true,
+		AccessRules: []acl.Rule{
+			{ID: "dummy", Action: acl.ActionAllow, Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, Ports: []acl.PortRange{{From: allowedPort, To: allowedPort}}},
+		},
+		Logger: funcr.New(func(prefix, args string) {
+			mu.Lock()
+			defer mu.Unlock()
+			logs = append(logs, args)
+		}, funcr.Options{}),
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	decided := func(rule, action string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, l := range logs {
+			if strings.Contains(l, `"rule"="`+rule+`"`) && strings.Contains(l, `"action"="`+action+`"`) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	d := client.New(srvAddr)
+
+	t.Run("allowed", func(t *testing.T) {
+		conn, err := d.Dial("tcp", allowedListener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		out := make([]byte, 2)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(out))
+		require.True(t, decided("dummy", "allow"))
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		_, err := d.Dial("tcp", deniedListener.Addr().String())
+
+		var repErr *types.ReplyError
+		require.ErrorAs(t, err, &repErr)
+		require.Equal(t, types.ReplyNotAllowed, repErr.Code)
+		require.True(t, decided(acl.DefaultRuleID, "deny"))
+	})
+
+	t.Run("forwarded HTTP request is denied", func(t *testing.T) {
+		proxyURL := &url.URL{Scheme: "http", Host: srvAddr}
+		httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+		resp, err := httpClient.Get("http://" + deniedListener.Addr().String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
+func TestServer_AccessRules_Resolve(t *testing.T) {
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte{'o', 'k'})
+			conn.Close()
+		}
+	}()
+
+	_, dummyPort, _ := net.SplitHostPort(dummyListener.Addr().String())
+
+	// The name fails to resolve first, then resolves to a denied IP address
+	resolver := &sequenceResolver{ips: []net.IP{nil, net.IPv4(127, 0, 0, 1)}}
+
+	srvAddr := "127.0.0.1:20102"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		Resolver:           resolver,
+		AccessRules: []acl.Rule{
+			{ID: "loopback", Action: acl.ActionDeny, Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+		},
+		AccessDefault: acl.ActionAllow,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = client.New(srvAddr).Dial("tcp", net.JoinHostPort("flaky.example.com", dummyPort))
+
+	var repErr *types.ReplyError
+	require.ErrorAs(t, err, &repErr)
+	require.Equal(t, types.ReplyHostUnreach, repErr.Code)
+	require.Equal(t, 1, resolver.calls())
+}
+
+func TestServer_AccessRules_ForwardedDial(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer origin.Close()
+
+	_, originPort, _ := net.SplitHostPort(origin.Listener.Addr().String())
+
+	// The name resolves to the allowed IP address of the origin server first, then to a denied one
+	resolver := &sequenceResolver{ips: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)}}
+
+	srvAddr := "127.0.0.1:20103"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		EnableHTTP:         true,
+		Resolver:           resolver,
+		AccessRules: []acl.Rule{
+			{ID: "rebound", Action: acl.ActionDeny, Networks: []netip.Prefix{netip.MustParsePrefix("127.0.0.2/32")}},
+		},
+		AccessDefault: acl.ActionAllow,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	proxyURL := &url.URL{Scheme: "http", Host: srvAddr}
+	httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	host := net.JoinHostPort("rebind.example.com", originPort)
+	resp, err := httpClient.Get("http://" + host)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The IP address authorized is the one dialed, the Host header is left as-is
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, host, string(body))
+	require.Equal(t, 1, resolver.calls())
+}
+
+// sequenceResolver resolves every domain name to the next IP address of the sequence, a nil one failing.
+// The last IP address is repeated once the sequence is over.
+type sequenceResolver struct {
+	mu  sync.Mutex
+	ips []net.IP
+	n   int
+}
+
+func (r *sequenceResolver) Resolve(_ context.Context, domain string) (net.IP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ip := r.ips[min(r.n, len(r.ips)-1)]
+	r.n++
+
+	if ip == nil {
+		return nil, fmt.Errorf("temporary failure resolving %s", domain)
+	}
+
+	return ip, nil
+}
+
+func (r *sequenceResolver) calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.n
+}
+
+func TestServer_AccessSchedules(t *testing.T) {
+	// Create dummy servers, holding every connection open until the client goes away,
+	// the second one is allowed whatever the time
+	echo := func(l net.Listener) {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}
+
+	scheduledListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer scheduledListener.Close()
+	go echo(scheduledListener)
+
+	alwaysListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer alwaysListener.Close()
+	go echo(alwaysListener)
+
+	alwaysPort := uint16(alwaysListener.Addr().(*net.TCPAddr).Port)
+
+	// A timezone where it is noon now, so the window closes shortly whatever the time of the day
+	now := time.Now().UTC()
+	h, m, sec := now.Clock()
+	noon := time.FixedZone("noon", int(12*time.Hour/time.Second)-(h*3600+m*60+sec))
+
+	srvAddr := "127.0.0.1:20100"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		AccessRules: []acl.Rule{
+			{ID: "until-noon", Action: acl.ActionAllow, Schedules: []acl.Schedule{
+				{Location: noon, Hours: []acl.TimeRange{{From: 0, To: 12*time.Hour + 2*time.Second}}},
+			}},
+			{ID: "always", Action: acl.ActionAllow, Ports: []acl.PortRange{{From: alwaysPort, To: alwaysPort}}},
+		},
+		TerminateOutsideSchedule: true,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	d := client.New(srvAddr)
+
+	ping := func(t *testing.T, conn net.Conn) {
+		_, err := conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		out := make([]byte, 4)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(out))
+	}
+
+	scheduled, err := d.Dial("tcp", scheduledListener.Addr().String())
+	require.NoError(t, err)
+	defer scheduled.Close()
+	ping(t, scheduled)
+
+	always, err := d.Dial("tcp", alwaysListener.Addr().String())
+	require.NoError(t, err)
+	defer always.Close()
+	ping(t, always)
+
+	t.Run("connection is cut off at the end of the window", func(t *testing.T) {
+		require.NoError(t, scheduled.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = scheduled.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("connection still allowed by another rule goes on", func(t *testing.T) {
+		require.NoError(t, always.SetDeadline(time.Now().Add(time.Second)))
+		ping(t, always)
+	})
+
+	t.Run("new connections are denied", func(t *testing.T) {
+		_, err := d.Dial("tcp", scheduledListener.Addr().String())
+
+		var repErr *types.ReplyError
+		require.ErrorAs(t, err, &repErr)
+		require.Equal(t, types.ReplyNotAllowed, repErr.Code)
+	})
+}
+
+func TestServer_DialGuard(t *testing.T) {
+	// Create dummy server, on a loopback address blocked by default
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte{'o', 'k'})
+			conn.Close()
+		}
+	}()
+
+	_, dummyPort, _ := net.SplitHostPort(dummyListener.Addr().String())
+
+	newServer := func(t *testing.T, addr string, cfg dialguard.Config) {
+		srv, err := New(ServerConfig{
+			EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+			EnableHTTP:         true,
+			Resolver:           stubResolver{"rebind.example.com": net.IPv4(127, 0, 0, 1)},
+			DialGuard:          &cfg,
+		})
+		require.NoError(t, err)
+		t.Cleanup(srv.Shutdown)
+
+		go func() { srv.ListenAndServe(addr) }()
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Run("blocked", func(t *testing.T) {
+		srvAddr := "127.0.0.1:20097"
+		newServer(t, srvAddr, dialguard.Config{})
+
+		d := client.New(srvAddr)
+		for _, address := range []string{
+			dummyListener.Addr().String(),
+			net.JoinHostPort("rebind.example.com", dummyPort),
+		} {
+			_, err := d.Dial("tcp", address)
+
+			var repErr *types.ReplyError
+			require.ErrorAs(t, err, &repErr)
+			require.Equal(t, types.ReplyNotAllowed, repErr.Code)
+		}
+
+		proxyURL := &url.URL{Scheme: "http", Host: srvAddr}
+		httpClient := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+		resp, err := httpClient.Get("http://" + dummyListener.Addr().String())
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("allowed by exception", func(t *testing.T) {
+		srvAddr := "127.0.0.1:20098"
+		newServer(t, srvAddr, dialguard.Config{Allow: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}})
+
+		conn, err := client.New(srvAddr).Dial("tcp", net.JoinHostPort("rebind.example.com", dummyPort))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		out := make([]byte, 2)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, "ok", string(out))
+	})
+}
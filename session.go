@@ -0,0 +1,130 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+var (
+	ErrSessionLimit = fmt.Errorf("too many concurrent sessions")
+)
+
+// sessions tracks the live sessions of the authenticated users.
+type sessions struct {
+	mu     sync.Mutex
+	byUser map[string]map[*session]struct{}
+}
+
+type session struct {
+	userID string
+	cancel context.CancelCauseFunc
+	timer  *time.Timer
+
+	// fromStore is whether the user was authenticated by the CredentialStore,
+	// which is the only store the account is checked again with.
+	fromStore bool
+}
+
+// startSession registers the session of the authenticated user, refusing it once the user is at its cap.
+// It returns a context which is cancelled when the session is terminated, and a function to release it.
+func (s *Server) startSession(ctx context.Context, authCtx *auth.AuthContext) (context.Context, func(), error) {
+	if authCtx == nil || authCtx.Identity == nil {
+		return ctx, func() {}, nil
+	}
+
+	identity := authCtx.Identity
+
+	s.sessions.mu.Lock()
+	defer s.sessions.mu.Unlock()
+
+	live := s.sessions.byUser[identity.UserID]
+	if identity.MaxSessions > 0 && len(live) >= identity.MaxSessions {
+		return nil, nil, fmt.Errorf("%w, user %q is at its cap of %d", ErrSessionLimit, identity.UserID, identity.MaxSessions)
+	}
+
+	if err := s.rulesetOf(ctx).limiter.Allow(identity); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	sess := &session{userID: identity.UserID, cancel: cancel, fromStore: authCtx.Method == types.AuthUserPass}
+
+	if s.cfg.TerminateRevokedSessions && !identity.ExpiresAt.IsZero() {
+		sess.timer = time.AfterFunc(time.Until(identity.ExpiresAt), func() {
+			s.terminateSession(sess, credentials.ErrAccountExpired)
+		})
+	}
+
+	if live == nil {
+		live = make(map[*session]struct{})
+		s.sessions.byUser[identity.UserID] = live
+	}
+	live[sess] = struct{}{}
+
+	release := func() {
+		if sess.timer != nil {
+			sess.timer.Stop()
+		}
+
+		s.sessions.mu.Lock()
+		defer s.sessions.mu.Unlock()
+
+		delete(live, sess)
+		if len(live) == 0 {
+			delete(s.sessions.byUser, sess.userID)
+		}
+
+		cancel(nil)
+	}
+
+	return ctx, release, nil
+}
+
+// checkSessions terminates the live sessions whose account is no longer valid in the credential store,
+// it is called every time the store reloads.
+func (s *Server) checkSessions() {
+	store, ok := s.cfg.CredentialStore.(credentials.AccountStorer)
+	if !s.cfg.TerminateRevokedSessions || !ok {
+		return
+	}
+
+	type revocation struct {
+		sess  *session
+		cause error
+	}
+
+	s.sessions.mu.Lock()
+	var revoked []revocation
+	for userID, live := range s.sessions.byUser {
+		cause := credentials.ErrAccountNotFound
+		if account, exists := store.Account(userID); exists {
+			cause = account.Check(time.Now())
+		}
+
+		if cause == nil {
+			continue
+		}
+
+		for sess := range live {
+			if sess.fromStore {
+				revoked = append(revoked, revocation{sess: sess, cause: cause})
+			}
+		}
+	}
+	s.sessions.mu.Unlock()
+
+	for _, r := range revoked {
+		s.terminateSession(r.sess, r.cause)
+	}
+}
+
+func (s *Server) terminateSession(sess *session, cause error) {
+	s.cfg.Logger.WithName("sessions").Info("terminating session", "userID", sess.userID, "reason", cause.Error())
+	sess.cancel(cause)
+}
@@ -0,0 +1,128 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	// Create dummy server, holding every connection open until the client goes away
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	dummyAddr := dummyListener.Addr().(*net.TCPAddr)
+
+	const hash = "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+	filename := filepath.Join(t.TempDir(), "users")
+	require.NoError(t, os.WriteFile(filename, []byte("alice:"+hash+":max_sessions=1\n"), 0o600))
+
+	srvAddr := "127.0.0.1:20092"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods:       []types.AuthMethod{types.AuthUserPass},
+		UserPassFilename:         filename,
+		TerminateRevokedSessions: true,
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	connect := func(t *testing.T) (net.Conn, byte) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+
+		req := bytes.NewBuffer(nil)
+		req.Write([]byte{types.VERSION, 0x01, byte(types.AuthUserPass)})
+		req.Write([]byte{0x01, 0x05, 'a', 'l', 'i', 'c', 'e', 0x06, 's', 'e', 'c', 'r', 'e', 't'})
+		req.Write([]byte{types.VERSION, byte(types.CommandConnect), 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, uint8(dummyAddr.Port >> 8), uint8(dummyAddr.Port & 0xFF)})
+
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+
+		// Method selection, auth status and the request reply
+		out := make([]byte, 2+2+10)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, []byte{types.VERSION, byte(types.AuthUserPass), 0x01, 0x00}, out[:4])
+
+		return conn, out[5]
+	}
+
+	first, rep := connect(t)
+	defer first.Close()
+	require.EqualValues(t, types.ReplySucceeded, rep)
+
+	t.Run("concurrent sessions are capped", func(t *testing.T) {
+		second, rep := connect(t)
+		defer second.Close()
+		require.EqualValues(t, types.ReplyNotAllowed, rep)
+	})
+
+	t.Run("disabled account is terminated on reload", func(t *testing.T) {
+		_, err := first.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		out := make([]byte, 4)
+		_, err = io.ReadAtLeast(first, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(out))
+
+		require.NoError(t, os.WriteFile(filename, []byte("alice:"+hash+":disabled\n"), 0o600))
+		require.NoError(t, srv.reloadables[0].reload())
+
+		require.NoError(t, first.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = first.Read(out)
+		require.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestServer_SessionExpiry(t *testing.T) {
+	srv, err := New(ServerConfig{TerminateRevokedSessions: true})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	authCtx := &auth.AuthContext{
+		Method:   types.AuthUserPass,
+		Identity: &credentials.Identity{UserID: "alice", ExpiresAt: time.Now().Add(50 * time.Millisecond)},
+	}
+
+	ctx, release, err := srv.startSession(context.Background(), authCtx)
+	require.NoError(t, err)
+	defer release()
+
+	select {
+	case <-ctx.Done():
+		require.ErrorIs(t, context.Cause(ctx), credentials.ErrAccountExpired)
+	case <-time.After(time.Second):
+		t.Fatal("session outlived its account")
+	}
+}
@@ -0,0 +1,76 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/request"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+)
+
+func (s *Server) handleSOCKS4(ctx context.Context, conn net.Conn) {
+	log := contexts.GetLogger(ctx).WithValues("version", types.VERSION4)
+
+	// parsing SOCKS4 request
+	req, err := request.ParseV4(conn, SendReplyV4, s.requestOptions(ctx)...)
+	if err != nil {
+		if repErr := sendReplyV4(conn, types.ReplyV4Rejected, nil); repErr != nil {
+			log.Error(repErr, "failed to send SOCKS reply")
+		}
+
+		log.Error(err, "failed to parse SOCKS request", "phase", "request parsing")
+		return
+	}
+
+	// SOCKS4 has no method negotiation, the USERID field is the only identity the client provides
+	authCtx := &auth.AuthContext{Method: types.AuthNoAuthRequired}
+
+	if s.cfg.SOCKS4UserIDStore != nil {
+		identity, err := auth.ValidateCredentials(ctx, nil, s.cfg.SOCKS4UserIDStore, conn.RemoteAddr(), credentials.Parameters{
+			Username: req.GetUserID(),
+		})
+		if err != nil {
+			replyCode := types.ReplyV4IdentUnreachable
+			if errors.Is(err, credentials.ErrInvalidCredentials) {
+				replyCode = types.ReplyV4IdentMismatch
+			}
+
+			if repErr := sendReplyV4(conn, replyCode, nil); repErr != nil {
+				log.Error(repErr, "failed to send SOCKS reply")
+			}
+
+			log.Error(err, "failed to validate SOCKS4 USERID", "phase", "authentication", "userID", req.GetUserID())
+			return
+		}
+
+		authCtx.Payload = auth.AuthPayload{
+			"username": req.GetUserID(),
+		}
+		authCtx.Identity = identity
+	}
+
+	s.identifyClientCertificate(conn, authCtx)
+
+	// handling SOCKS request
+	reqCtx, release, err := s.startSession(contexts.WithAuth(ctx, authCtx), authCtx)
+	if err != nil {
+		if repErr := sendReplyV4(conn, types.ReplyV4Rejected, nil); repErr != nil {
+			log.Error(repErr, "failed to send SOCKS reply")
+		}
+
+		log.Error(err, "failed to start session", "phase", "session")
+		return
+	}
+	defer release()
+
+	if err := req.Handle(reqCtx, s.limitConn(reqCtx, conn)); err != nil {
+		log.Error(err, "failed to handle SOCKS request", "phase", "request handling")
+		return
+	}
+
+	log.Info("handling SOCKS request completed", "remote", req.GetAddress().String(), "phase", "completion")
+}
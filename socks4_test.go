@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_SOCKS4(t *testing.T) {
+	// Create dummy server
+	dummyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer dummyListener.Close()
+
+	dummyAddr := dummyListener.Addr().(*net.TCPAddr)
+
+	go func() {
+		for {
+			conn, err := dummyListener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte{'o', 'k'})
+			conn.Close()
+		}
+	}()
+
+	// Create SOCKS server
+	srvAddr := "127.0.0.1:20083"
+	srv, err := New(ServerConfig{
+		EnableSOCKS4:      true,
+		SOCKS4UserIDStore: credentials.MemoryStore{"user": ""},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	port := []byte{uint8(dummyAddr.Port >> 8), uint8(dummyAddr.Port & 0xFF)}
+
+	t.Run("SOCKS4 connect", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		req := bytes.NewBuffer(nil)
+		req.Write([]byte{types.VERSION4, byte(types.CommandConnect)})
+		req.Write(port)
+		req.Write([]byte{0x7F, 0x00, 0x00, 0x01})
+		req.Write([]byte{'u', 's', 'e', 'r', 0x00})
+
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+
+		out := make([]byte, 10)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x00, byte(types.ReplyV4Granted)}, out[:2])
+		require.Equal(t, []byte{0x7F, 0x00, 0x00, 0x01}, out[4:8])
+		require.Equal(t, "ok", string(out[8:]))
+	})
+
+	t.Run("SOCKS4a connect", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		req := bytes.NewBuffer(nil)
+		req.Write([]byte{types.VERSION4, byte(types.CommandConnect)})
+		req.Write(port)
+		req.Write([]byte{0x00, 0x00, 0x00, 0x01})
+		req.Write([]byte{'u', 's', 'e', 'r', 0x00})
+		req.Write([]byte("localhost"))
+		req.Write([]byte{0x00})
+
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+
+		out := make([]byte, 10)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x00, byte(types.ReplyV4Granted)}, out[:2])
+		require.Equal(t, "ok", string(out[8:]))
+	})
+
+	t.Run("USERID mismatch", func(t *testing.T) {
+		conn, err := net.Dial("tcp", srvAddr)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		req := bytes.NewBuffer(nil)
+		req.Write([]byte{types.VERSION4, byte(types.CommandConnect)})
+		req.Write(port)
+		req.Write([]byte{0x7F, 0x00, 0x00, 0x01})
+		req.Write([]byte{'b', 'a', 'd', 0x00})
+
+		_, err = conn.Write(req.Bytes())
+		require.NoError(t, err)
+
+		out := make([]byte, 8)
+		_, err = io.ReadAtLeast(conn, out, len(out))
+		require.NoError(t, err)
+		require.Equal(t, []byte{0x00, byte(types.ReplyV4IdentMismatch)}, out[:2])
+	})
+}
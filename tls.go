@@ -0,0 +1,221 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth"
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/tool/filewatch"
+)
+
+// TLSClientIdentity is which part of the client certificate becomes the identity of the client.
+type TLSClientIdentity int
+
+const (
+	// TLSIdentitySubject is the common name of the certificate subject.
+	TLSIdentitySubject TLSClientIdentity = iota
+
+	// TLSIdentitySAN is the first subject alternative name of the certificate,
+	// looking at the URIs (such as SPIFFE IDs), then the DNS names and the email addresses.
+	TLSIdentitySAN
+)
+
+// DefaultTLSHandshakeTimeout is how long a client has to complete the TLS handshake when none is given.
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// TLSConfig serves the listener over TLS, optionally requiring the clients to present a certificate.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate chain and private key of the server.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile is a PEM bundle of the CAs the client certificates are verified against, enabling mutual TLS.
+	// The verified certificate becomes the identity of the client, whenever the authentication method
+	// doesn't identify the client on its own, such as NO AUTHENTICATION REQUIRED.
+	ClientCAFile string
+
+	// ClientCertOptional accepts clients without a certificate, those are left to the authentication method.
+	// Otherwise, a verified client certificate is required once ClientCAFile is set.
+	ClientCertOptional bool
+
+	// ClientIdentity is which part of the client certificate becomes its identity, it defaults to TLSIdentitySubject.
+	ClientIdentity TLSClientIdentity
+
+	// MinVersion is the minimum TLS version accepted, it defaults to TLS 1.2.
+	MinVersion uint16
+
+	// HandshakeTimeout is how long a client has to complete the TLS handshake.
+	// It defaults to DefaultTLSHandshakeTimeout.
+	HandshakeTimeout time.Duration
+
+	// PollInterval is how often the files are polled for changes, on top of inotify.
+	// The files are also reloaded on SIGHUP, it defaults to 10 seconds.
+	PollInterval time.Duration
+}
+
+// tlsStore keeps the TLS configuration loaded from the files of TLSConfig.
+// The configuration is swapped atomically on reload, and kept as it is when the new files fail to load,
+// so the connections already established are left untouched and new handshakes pick up the new files.
+type tlsStore struct {
+	cfg    TLSConfig
+	config atomic.Pointer[tls.Config]
+}
+
+func newTLSStore(cfg TLSConfig) (*tlsStore, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls: both certificate and key files are required")
+	}
+
+	if cfg.ClientIdentity != TLSIdentitySubject && cfg.ClientIdentity != TLSIdentitySAN {
+		return nil, fmt.Errorf("tls: unknown client identity %d", cfg.ClientIdentity)
+	}
+
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+
+	t := &tlsStore{cfg: cfg}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Reload reads the files again, the current configuration is kept if any of them fails to load.
+func (t *tlsStore) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.cfg.CertFile, t.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: failed to load certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   t.cfg.MinVersion,
+	}
+
+	if t.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: failed to read client CAs: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificate found in %s", t.cfg.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if t.cfg.ClientCertOptional {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	t.config.Store(config)
+	return nil
+}
+
+// Watch reloads the configuration every time one of the files changes, until the context is done.
+// The outcome of every reload is reported to onReload.
+func (t *tlsStore) Watch(ctx context.Context, onReload func(error)) {
+	files := []string{t.cfg.CertFile, t.cfg.KeyFile}
+	if t.cfg.ClientCAFile != "" {
+		files = append(files, t.cfg.ClientCAFile)
+	}
+
+	for _, filename := range files {
+		go filewatch.Watch(ctx, filename, t.cfg.PollInterval, func() {
+			onReload(t.Reload())
+		})
+	}
+
+	<-ctx.Done()
+}
+
+// Listener wraps the listener to serve TLS, with the configuration current at the time of each handshake.
+func (t *tlsStore) Listener(l net.Listener) net.Listener {
+	return tls.NewListener(l, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return t.config.Load(), nil
+		},
+	})
+}
+
+// handshake completes the TLS handshake of the connection, it is a no-op for connections without TLS.
+func (s *Server) handshake(ctx context.Context, conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.tls.cfg.HandshakeTimeout)
+	defer cancel()
+
+	return tlsConn.HandshakeContext(ctx)
+}
+
+// identifyClientCertificate makes the verified client certificate the identity of the client,
+// unless the authentication method already identified it.
+func (s *Server) identifyClientCertificate(conn net.Conn, authCtx *auth.AuthContext) {
+	if authCtx == nil || authCtx.Identity != nil {
+		return
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return
+	}
+
+	authCtx.Identity = clientCertificateIdentity(state.VerifiedChains[0][0], s.tls.cfg.ClientIdentity)
+}
+
+// clientCertificateIdentity returns the identity of the client certificate, with its organizational units as the groups.
+// The identity expires with the certificate.
+func clientCertificateIdentity(cert *x509.Certificate, from TLSClientIdentity) *credentials.Identity {
+	userID := cert.Subject.CommonName
+
+	if from == TLSIdentitySAN {
+		switch {
+		case len(cert.URIs) > 0:
+			userID = cert.URIs[0].String()
+		case len(cert.DNSNames) > 0:
+			userID = cert.DNSNames[0]
+		case len(cert.EmailAddresses) > 0:
+			userID = cert.EmailAddresses[0]
+		default:
+			userID = ""
+		}
+	}
+
+	if userID == "" {
+		return nil
+	}
+
+	return &credentials.Identity{
+		UserID: userID,
+		Groups: cert.Subject.OrganizationalUnit,
+		Attributes: map[string]interface{}{
+			"tls.subject": cert.Subject.String(),
+			"tls.issuer":  cert.Issuer.String(),
+			"tls.serial":  cert.SerialNumber.String(),
+		},
+		ExpiresAt: cert.NotAfter,
+	}
+}
@@ -0,0 +1,226 @@
+package socks5
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ardikabs/socks5/pkg/auth/credentials"
+	"github.com/ardikabs/socks5/pkg/tool/contexts"
+	"github.com/ardikabs/socks5/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM encoded certificate and key signed by the CA, for the given template.
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) issueServer(t *testing.T, serial int64) ([]byte, []byte) {
+	return ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "proxy"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+func (ca *testCA) clientCertificate(t *testing.T) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(100),
+		Subject:      pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"ops"}},
+		URIs:         []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/alice"}},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	require.NoError(t, err)
+
+	return cert
+}
+
+func writeTestFile(t *testing.T, filename string, data []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filename, data, 0o600))
+}
+
+func TestServer_TLS(t *testing.T) {
+	ca := newTestCA(t)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	certPEM, keyPEM := ca.issueServer(t, 2)
+	writeTestFile(t, certFile, certPEM)
+	writeTestFile(t, keyFile, keyPEM)
+	writeTestFile(t, caFile, ca.pem)
+
+	identities := make(chan *credentials.Identity, 1)
+
+	srvAddr := "127.0.0.1:20093"
+	srv, err := New(ServerConfig{
+		EnabledAuthMethods: []types.AuthMethod{types.AuthNoAuthRequired},
+		TLS: &TLSConfig{
+			CertFile:     certFile,
+			KeyFile:      keyFile,
+			ClientCAFile: caFile,
+		},
+		Dialer: func(ctx context.Context, network, address string) (net.Conn, error) {
+			identities <- contexts.GetAuth(ctx).Identity
+			return nil, io.EOF
+		},
+	})
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	go func() { srv.ListenAndServe(srvAddr) }()
+
+	time.Sleep(20 * time.Millisecond)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dial := func(t *testing.T, certs ...tls.Certificate) (*tls.Conn, error) {
+		conn, err := tls.Dial("tcp", srvAddr, &tls.Config{RootCAs: roots, Certificates: certs})
+		if err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		// TLS 1.3 reports the rejected client certificate on the first read
+		_, err = conn.Write([]byte{types.VERSION, 0x01, byte(types.AuthNoAuthRequired)})
+		require.NoError(t, err)
+
+		out := make([]byte, 2)
+		if _, err := io.ReadAtLeast(conn, out, len(out)); err != nil {
+			return nil, err
+		}
+		require.Equal(t, []byte{types.VERSION, byte(types.AuthNoAuthRequired)}, out)
+
+		return conn, nil
+	}
+
+	t.Run("client certificate is the identity", func(t *testing.T) {
+		conn, err := dial(t, ca.clientCertificate(t))
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte{types.VERSION, byte(types.CommandConnect), 0x00, 0x01, 0x7F, 0x00, 0x00, 0x01, 0x00, 0x50})
+		require.NoError(t, err)
+
+		select {
+		case identity := <-identities:
+			require.NotNil(t, identity)
+			assert.Equal(t, "alice", identity.UserID)
+			assert.Equal(t, []string{"ops"}, identity.Groups)
+			assert.Equal(t, "100", identity.Attributes["tls.serial"])
+		case <-time.After(time.Second):
+			t.Fatal("request is not dialed")
+		}
+	})
+
+	t.Run("client certificate is required", func(t *testing.T) {
+		_, err := dial(t)
+		require.Error(t, err)
+	})
+
+	t.Run("certificate is reloaded", func(t *testing.T) {
+		certPEM, keyPEM := ca.issueServer(t, 3)
+		writeTestFile(t, certFile, certPEM)
+		writeTestFile(t, keyFile, keyPEM)
+		require.NoError(t, srv.reloadables[0].reload())
+
+		conn, err := dial(t, ca.clientCertificate(t))
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+
+		// A broken file keeps the previous certificate
+		writeTestFile(t, keyFile, []byte("garbage"))
+		require.Error(t, srv.reloadables[0].reload())
+
+		_, err = dial(t, ca.clientCertificate(t))
+		require.NoError(t, err)
+	})
+}
+
+func TestClientCertificateIdentity(t *testing.T) {
+	cert := &x509.Certificate{
+		SerialNumber:   big.NewInt(7),
+		Subject:        pkix.Name{CommonName: "alice", OrganizationalUnit: []string{"ops", "dev"}},
+		DNSNames:       []string{"alice.example.org"},
+		EmailAddresses: []string{"alice@example.org"},
+		NotAfter:       time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	identity := clientCertificateIdentity(cert, TLSIdentitySubject)
+	assert.Equal(t, "alice", identity.UserID)
+	assert.Equal(t, []string{"ops", "dev"}, identity.Groups)
+	assert.Equal(t, cert.NotAfter, identity.ExpiresAt)
+
+	assert.Equal(t, "alice.example.org", clientCertificateIdentity(cert, TLSIdentitySAN).UserID)
+
+	cert.URIs = []*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/alice"}}
+	assert.Equal(t, "spiffe://example.org/alice", clientCertificateIdentity(cert, TLSIdentitySAN).UserID)
+
+	cert.Subject.CommonName = ""
+	assert.Nil(t, clientCertificateIdentity(cert, TLSIdentitySubject))
+}